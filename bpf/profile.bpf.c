@@ -15,7 +15,7 @@ struct {
 struct {
 	__uint(type, BPF_MAP_TYPE_HASH);
 	__type(key, histogram_key_t);		/* per-process stack trace key */
-	__type(value, u64);			/* sample count */
+	__type(value, histogram_value_t);	/* sample count and time */
 	__uint(max_entries, K_NUM_MAP_ENTRIES);
 } histogram SEC(".maps");
 
@@ -26,6 +26,80 @@ struct {
 	__uint(max_entries, K_NUM_MAP_ENTRIES);
 } binprm_info SEC(".maps");
 
+struct {
+	__uint(type, BPF_MAP_TYPE_ARRAY);
+	__type(key, u32);
+	__type(value, filter_config_t);
+	__uint(max_entries, 1);
+} filter_config SEC(".maps");
+
+struct {
+	__uint(type, BPF_MAP_TYPE_HASH);
+	__type(key, u32);			/* tgid */
+	__type(value, u8);
+	__uint(max_entries, MAX_FILTER_ENTRIES);
+} filter_tgids SEC(".maps");
+
+struct {
+	__uint(type, BPF_MAP_TYPE_HASH);
+	__type(key, u32);			/* pid, the thread ID */
+	__type(value, u8);
+	__uint(max_entries, MAX_FILTER_ENTRIES);
+} filter_pids SEC(".maps");
+
+struct {
+	__uint(type, BPF_MAP_TYPE_HASH);
+	__type(key, u32);			/* pid */
+	__type(value, sample_drops_t);
+	__uint(max_entries, K_NUM_MAP_ENTRIES);
+} sample_drops SEC(".maps");
+
+/*
+ * map_entries are the counters of the entries inserted into the maps that are never deleted from,
+ * so that user space knows how full they are without iterating them.
+ */
+struct {
+	__uint(type, BPF_MAP_TYPE_ARRAY);
+	__type(key, u32);
+	__type(value, u64);
+	__uint(max_entries, MAP_ENTRIES);
+} map_entries SEC(".maps");
+
+/*
+ * stack_trace_ids are the IDs of the stacks stored into stack_traces, to count them once,
+ * as bpf_get_stackid returns the same ID for new and already stored stacks.
+ */
+struct {
+	__uint(type, BPF_MAP_TYPE_HASH);
+	__type(key, u32);			/* stack ID */
+	__type(value, u8);
+	__uint(max_entries, K_NUM_MAP_ENTRIES);
+} stack_trace_ids SEC(".maps");
+
+struct {
+	__uint(type, BPF_MAP_TYPE_LRU_HASH);
+	__type(key, u32);			/* pid */
+	__type(value, u8);
+	__uint(max_entries, K_NUM_MAP_ENTRIES);
+} seen_pids SEC(".maps");
+
+/*
+ * mapping_generations count the changes of the executable memory mappings of the processes,
+ * so that the samples of the same stack taken before and after a change are counted apart,
+ * as their addresses may belong to different objects.
+ */
+struct {
+	__uint(type, BPF_MAP_TYPE_HASH);
+	__type(key, u32);			/* pid */
+	__type(value, u32);			/* generation */
+	__uint(max_entries, K_NUM_MAP_ENTRIES);
+} mapping_generations SEC(".maps");
+
+struct {
+	__uint(type, BPF_MAP_TYPE_RINGBUF);
+	__uint(max_entries, PID_EVENTS_SIZE);
+} pid_events SEC(".maps");
+
 struct {
 	__uint(type, BPF_MAP_TYPE_PERCPU_ARRAY);
 	__type(key, u32);
@@ -33,6 +107,34 @@ struct {
 	__uint(max_entries, 1);
 } heaps SEC(".maps");
 
+struct {
+	__uint(type, BPF_MAP_TYPE_ARRAY);
+	__type(key, u32);
+	__type(value, unwind_row_t);
+	__uint(max_entries, UNWIND_ROWS_SIZE);
+} unwind_rows SEC(".maps");
+
+struct {
+	__uint(type, BPF_MAP_TYPE_HASH);
+	__type(key, u32);			/* pid */
+	__type(value, unwind_process_t);
+	__uint(max_entries, 1024);
+} unwind_processes SEC(".maps");
+
+struct {
+	__uint(type, BPF_MAP_TYPE_HASH);
+	__type(key, u32);			/* stack hash */
+	__type(value, stack_trace_t);
+	__uint(max_entries, K_NUM_MAP_ENTRIES);
+} dwarf_stack_traces SEC(".maps");
+
+struct {
+	__uint(type, BPF_MAP_TYPE_PERCPU_ARRAY);
+	__type(key, u32);
+	__type(value, stack_trace_t);
+	__uint(max_entries, 1);
+} unwind_stacks SEC(".maps");
+
 /*
  * get_pathname_from_path lookups pathname from path struct
  * Thanks to tracee: https://github.com/aquasecurity/tracee/blob/a6118678c6908c74d6ee26ca9183e99932d098c9/pkg/ebpf/c/common/filesystem.h#L160
@@ -151,54 +253,546 @@ static __always_inline void *get_task_exe_pathname(struct task_struct *task)
 	return &string_buf->data[buf_off];
 }
 
-SEC("perf_event")
-int sample_stack_trace(struct bpf_perf_event_data* ctx)
+/*
+ * notify_new_pid notifies user space of the first sample, or of the exec, of a process,
+ * to let it capture the process metadata while the process is alive.
+ */
+static __always_inline void notify_new_pid(u32 pid)
 {
-	char hist_insert_fmt[] = "stack trace histogram insert pid=%d comm=%s exe_path=%s\n";
-	histogram_key_t key;
-	struct bpf_perf_event_value value_buf;
-	u64 *count, one = 1;
-	char comm[TASK_COMM_LEN];
+	pid_event_t *event = bpf_ringbuf_reserve(&pid_events, sizeof(pid_event_t), 0);
+	if (event == NULL) {
+		return;
+	}
+	event->pid = pid;
+	bpf_ringbuf_submit(event, 0);
+}
 
-	struct task_struct *task; 
-	char *exe_path;
+/* count_map_entry increments the counter of the entries inserted into the map of the index. */
+static __always_inline void count_map_entry(u32 index)
+{
+	u64 *entries = bpf_map_lookup_elem(&map_entries, &index);
+
+	if (entries != NULL) {
+		__sync_fetch_and_add(entries, 1);
+	}
+}
+
+/* count_drop increments the counter of the dropped samples or stacks of the process for the reason. */
+static __always_inline void count_drop(u32 pid, int reason)
+{
+	sample_drops_t *drops, init = {};
+
+	if (reason < 0 || reason >= DROP_REASONS) {
+		return;
+	}
+	drops = bpf_map_lookup_elem(&sample_drops, &pid);
+	if (drops == NULL) {
+		bpf_map_update_elem(&sample_drops, &pid, &init, BPF_NOEXIST);
+		drops = bpf_map_lookup_elem(&sample_drops, &pid);
+		if (drops == NULL) {
+			return;
+		}
+	}
+	__sync_fetch_and_add(&drops->dropped[reason], 1);
+}
+
+/* bump_mapping_generation increments the generation of the memory mappings of the process. */
+static __always_inline void bump_mapping_generation(u32 pid)
+{
+	u32 *generation = bpf_map_lookup_elem(&mapping_generations, &pid);
+	u32 first = 1;
+
+	if (generation != NULL) {
+		__sync_fetch_and_add(generation, 1);
+		return;
+	}
+	bpf_map_update_elem(&mapping_generations, &pid, &first, BPF_NOEXIST);
+}
+
+/*
+ * count_stack_trace counts the stack of the ID returned by bpf_get_stackid, if not counted yet.
+ * The stacks can be new only in new histogram keys, so it's not run on every sample.
+ */
+static __always_inline void count_stack_trace(u32 stack_id)
+{
+	u8 seen = 1;
+
+	if ((int)stack_id < 0) {
+		return;
+	}
+	if (bpf_map_update_elem(&stack_trace_ids, &stack_id, &seen, BPF_NOEXIST) == 0) {
+		count_map_entry(MAP_ENTRIES_STACK_TRACES);
+	}
+}
+
+/*
+ * record_exe_path stores the executable pathname of the current process into binprm_info,
+ * and marks the process as seen. Walking the path components is expensive, so it's done
+ * only on exec and on the first sample of the processes, instead of on every sample.
+ * It returns -1 if the path could not be read, for the sample to be dropped.
+ * If it could not be stored, like when binprm_info is full, the sample is kept, and
+ * the lost path is counted for user space to grow the map.
+ */
+static __always_inline int record_exe_path(struct task_struct *task, u32 pid)
+{
 	char exe_path_str[MAX_ARRAY_SIZE];
-	int len = 0;
+	char *exe_path;
+	u8 seen = 1;
+	long err;
 
-	/* Get current task executable pathname */
-	task = (struct task_struct *)bpf_get_current_task(); /* Current task struct */
 	exe_path = get_task_exe_pathname(task);
 	if (exe_path == NULL) {
+		return -1;
+	}
+	if (bpf_core_read_str(&exe_path_str, sizeof(exe_path_str), exe_path) < 0) {
+		return -1;
+	}
+	err = bpf_map_update_elem(&binprm_info, &pid, &exe_path_str, BPF_NOEXIST);
+	if (err == 0) {
+		count_map_entry(MAP_ENTRIES_BINPRM_INFO);
+	} else if (err == -EEXIST) {
+		err = bpf_map_update_elem(&binprm_info, &pid, &exe_path_str, BPF_EXIST);
+	}
+	if (err < 0) {
+		/* The path of the previous executable, if any, is not valid anymore. */
+		bpf_map_delete_elem(&binprm_info, &pid);
+		count_drop(pid, DROP_REASON_BINPRM_INFO_FULL);
+	}
+	bpf_map_update_elem(&seen_pids, &pid, &seen, BPF_ANY);
+
+	return 0;
+}
+
+/*
+ * should_sample returns whether the current task matches the filters set by user space,
+ * so that the other tasks are neither sampled nor stored.
+ */
+static __always_inline bool should_sample(u64 pid_tgid)
+{
+	u32 zero = 0, tgid = pid_tgid >> 32, pid = (u32)pid_tgid;
+	filter_config_t *config = bpf_map_lookup_elem(&filter_config, &zero);
+
+	if (config == NULL || config->flags == 0) {
+		return true;
+	}
+	if ((config->flags & FILTER_F_TGID) && bpf_map_lookup_elem(&filter_tgids, &tgid) != NULL) {
+		return true;
+	}
+	if ((config->flags & FILTER_F_PID) && bpf_map_lookup_elem(&filter_pids, &pid) != NULL) {
+		return true;
+	}
+	return false;
+}
+
+/*
+ * count_stack_drop counts the stack lost by bpf_get_stackid, or by the update of
+ * dwarf_stack_traces, from its error. The other errors, like the missing user stack
+ * of kernel threads, are not drops.
+ */
+static __always_inline void count_stack_drop(u32 pid, u32 stack_id)
+{
+	switch ((int)stack_id) {
+	case -EEXIST:
+		count_drop(pid, DROP_REASON_STACK_COLLISION);
+		break;
+	case -ENOMEM:
+	case -E2BIG:
+		count_drop(pid, DROP_REASON_STACK_MAP_FULL);
+		break;
+	}
+}
+
+/* search_unwind_mapping is the bpf_loop callback of a step of the binary search of find_unwind_mapping. */
+static long search_unwind_mapping(u32 index, void *data)
+{
+	unwind_mapping_search_t *search = data;
+
+	if (search->lo >= search->hi) {
+		return 1;
+	}
+	u32 mid = (search->lo + search->hi) / 2;
+	if (mid >= MAX_UNWIND_MAPPINGS) {
+		return 1;
+	}
+	unwind_mapping_t *m = &search->proc->mappings[mid];
+	if (search->addr < m->start) {
+		search->hi = mid;
+	} else if (search->addr >= m->end) {
+		search->lo = mid + 1;
+	} else {
+		search->found = m;
+		return 1;
+	}
+	return 0;
+}
+
+/*
+ * find_unwind_mapping returns the mapping of the process the address belongs to.
+ * The binary searches run their steps in bpf_loop callbacks, as the verifier would
+ * otherwise walk every path through the steps of both, for every frame.
+ */
+static __always_inline unwind_mapping_t *find_unwind_mapping(unwind_process_t *proc, u64 addr)
+{
+	unwind_mapping_search_t search = {
+		.proc = proc,
+		.addr = addr,
+		.hi = proc->len,
+	};
+
+	if (search.hi > MAX_UNWIND_MAPPINGS) {
+		search.hi = MAX_UNWIND_MAPPINGS;
+	}
+	if (bpf_loop(UNWIND_MAPPINGS_SEARCH_STEPS, search_unwind_mapping, &search, 0) < 0) {
+		return NULL;
+	}
+	return search.found;
+}
+
+/* search_unwind_row is the bpf_loop callback of a step of the binary search of find_unwind_row. */
+static long search_unwind_row(u32 index, void *data)
+{
+	unwind_row_search_t *search = data;
+
+	if (search->lo >= search->hi) {
+		return 1;
+	}
+	u32 mid = search->lo + (search->hi - search->lo) / 2;
+	unwind_row_t *row = bpf_map_lookup_elem(&unwind_rows, &mid);
+	if (row == NULL) {
+		search->found = search->end;
+		return 1;
+	}
+	if (row->pc <= search->pc) {
+		search->found = mid;
+		search->lo = mid + 1;
+	} else {
+		search->hi = mid;
+	}
+	return 0;
+}
+
+/* find_unwind_row returns the last row of the mapping table at or before the table address. */
+static __always_inline unwind_row_t *find_unwind_row(unwind_mapping_t *m, u64 pc)
+{
+	unwind_row_search_t search = {
+		.pc = pc,
+		.lo = m->row_start,
+		.hi = m->row_start + m->row_count,
+		.end = m->row_start + m->row_count,
+		.found = m->row_start + m->row_count,
+	};
+
+	if (bpf_loop(UNWIND_ROWS_SEARCH_STEPS, search_unwind_row, &search, 0) < 0) {
+		return NULL;
+	}
+	if (search.found == search.end) {
+		return NULL;
+	}
+	return bpf_map_lookup_elem(&unwind_rows, &search.found);
+}
+
+/*
+ * unwind_frame is the bpf_loop callback that stores the frame at the index of the
+ * stack, and unwinds the caller one. It stops at the end of the stack, or when the
+ * caller frame cannot be unwound.
+ */
+static long unwind_frame(u32 index, void *data)
+{
+	stack_unwind_state_t *state = data;
+	u64 cfa, ra, bp = state->bp;
+	u64 i = index; /* bounded as 64 bits, for the verifier to track it into the stack offset */
+
+	if (i >= PERF_MAX_STACK_DEPTH || state->ip == 0) {
+		return 1;
+	}
+	state->stack->ips[i] = state->ip;
+	state->depth = i + 1;
+
+	/* Return addresses may belong to the next function of the call instruction. */
+	u64 addr = i > 0 ? state->ip - 1 : state->ip;
+	unwind_mapping_t *m = find_unwind_mapping(state->proc, addr);
+	unwind_row_t *row = m != NULL ? find_unwind_row(m, addr - m->bias) : NULL;
+
+	if (row != NULL && (row->cfa_type == CFA_TYPE_RSP || row->cfa_type == CFA_TYPE_RBP)) {
+		cfa = (row->cfa_type == CFA_TYPE_RSP ? state->sp : bp) + row->cfa_offset;
+		/* The return address is saved just below the canonical frame address. */
+		if (bpf_probe_read_user(&ra, sizeof(ra), (void *)(cfa - 8)) < 0) {
+			return 1;
+		}
+		if (row->rbp_type == RBP_TYPE_OFFSET &&
+		    bpf_probe_read_user(&bp, sizeof(bp), (void *)(cfa + row->rbp_offset)) < 0) {
+			return 1;
+		}
+	} else {
+		/* Frame pointer fallback: the caller one and the return address are on top of the frame. */
+		if (bp == 0) {
+			return 1;
+		}
+		cfa = bp + 16;
+		if (bpf_probe_read_user(&ra, sizeof(ra), (void *)(bp + 8)) < 0) {
+			return 1;
+		}
+		if (bpf_probe_read_user(&bp, sizeof(bp), (void *)bp) < 0) {
+			return 1;
+		}
+	}
+	state->sp = cfa;
+	state->bp = bp;
+	state->ip = ra;
+	return 0;
+}
+
+/*
+ * unwind_user_stack walks the user stack of the current task with the unwind tables
+ * of the mapped objects, compiled from their .eh_frame or .debug_frame sections.
+ * The frames of the code without them, like JIT code, are unwound with frame pointers.
+ * The frames are unwound by bpf_loop, so that the verifier checks the step once,
+ * instead of every unrolled one. It returns the number of frames.
+ */
+static __always_inline int unwind_user_stack(struct bpf_perf_event_data *ctx, unwind_process_t *proc,
+					     stack_trace_t *stack)
+{
+	stack_unwind_state_t state = {
+		.proc = proc,
+		.stack = stack,
+	};
+
+	/*
+	 * When sampled in kernel mode, the user registers are the ones saved on kernel entry.
+	 * The perf event context is read by whole registers only, so the mode is told by
+	 * the instruction pointer instead of the code segment.
+	 */
+	state.ip = PT_REGS_IP(&ctx->regs);
+	if (state.ip < USER_SPACE_END) {
+		state.sp = PT_REGS_SP(&ctx->regs);
+		state.bp = PT_REGS_FP(&ctx->regs);
+	} else {
+		struct pt_regs *regs = (struct pt_regs *)bpf_task_pt_regs(bpf_get_current_task_btf());
+		state.ip = PT_REGS_IP_CORE(regs);
+		state.sp = PT_REGS_SP_CORE(regs);
+		state.bp = PT_REGS_FP_CORE(regs);
+	}
+
+	for (int i = 0; i < PERF_MAX_STACK_DEPTH; i++) {
+		stack->ips[i] = 0;
+	}
+	if (bpf_loop(PERF_MAX_STACK_DEPTH, unwind_frame, &state, 0) < 0) {
 		return 0;
 	}
-	len = bpf_core_read_str(&exe_path_str, sizeof(exe_path_str), exe_path);
-	if (len < 0) {
+	return state.depth;
+}
+
+/* hash_stack returns the FNV-1a hash of the stack, as a non-negative stack ID. */
+static __always_inline u32 hash_stack(stack_trace_t *stack, int depth)
+{
+	u32 hash = 2166136261;
+
+	for (int i = 0; i < PERF_MAX_STACK_DEPTH; i++) {
+		if (i >= depth) {
+			break;
+		}
+		hash ^= (u32)stack->ips[i];
+		hash *= 16777619;
+		hash ^= (u32)(stack->ips[i] >> 32);
+		hash *= 16777619;
+	}
+	return hash & 0x7fffffff;
+}
+
+/*
+ * store_user_stack stores the unwound user stack into dwarf_stack_traces with the ID,
+ * unless the same stack is already stored with it. It returns -EEXIST if a different
+ * stack is stored with the ID, as their hashes collide, or the error of the update.
+ */
+static __always_inline long store_user_stack(u32 id, stack_trace_t *stack)
+{
+	long err = bpf_map_update_elem(&dwarf_stack_traces, &id, stack, BPF_NOEXIST);
+	if (err == 0) {
+		count_map_entry(MAP_ENTRIES_DWARF_STACK_TRACES);
+	}
+	if (err != -EEXIST) {
+		return err;
+	}
+	stack_trace_t *stored = bpf_map_lookup_elem(&dwarf_stack_traces, &id);
+	if (stored == NULL) {
+		return -EEXIST;
+	}
+	for (int i = 0; i < PERF_MAX_STACK_DEPTH; i++) {
+		if (stored->ips[i] != stack->ips[i]) {
+			return -EEXIST;
+		}
+		if (stack->ips[i] == 0) {
+			break;
+		}
+	}
+	return 0;
+}
+
+/*
+ * get_user_stack_id unwinds the user stack with the unwind tables of the process,
+ * if user space loaded them, and stores it into dwarf_stack_traces.
+ * Otherwise, or if it cannot be stored, the stack is walked by the kernel with frame pointers.
+ */
+static __always_inline u32 get_user_stack_id(struct bpf_perf_event_data *ctx, histogram_key_t *key)
+{
+	int zero = 0;
+	unwind_process_t *proc = bpf_map_lookup_elem(&unwind_processes, &key->pid);
+	stack_trace_t *stack = bpf_map_lookup_elem(&unwind_stacks, &zero);
+
+	if (proc != NULL && stack != NULL) {
+		int depth = unwind_user_stack(ctx, proc, stack);
+		if (depth > 0) {
+			u32 id = hash_stack(stack, depth);
+			long err = store_user_stack(id, stack);
+			if (err == 0) {
+				key->flags |= HISTOGRAM_F_DWARF_USER_STACK;
+				return id;
+			}
+			/* The unwound stack is lost, even if the frame pointer one is stored. */
+			count_stack_drop(key->pid, (u32)err);
+		}
+	}
+	return bpf_get_stackid(ctx, &stack_traces, 0 | BPF_F_FAST_STACK_CMP | BPF_F_USER_STACK);
+}
+
+/*
+ * record_exec records the new executable pathname of the processes that exec,
+ * and notifies user space to capture their new memory mappings.
+ */
+SEC("tracepoint/sched/sched_process_exec")
+int record_exec(void *ctx)
+{
+	u64 pid_tgid = bpf_get_current_pid_tgid();
+	u32 pid = pid_tgid >> 32;
+
+	if (!should_sample(pid_tgid)) {
 		return 0;
 	}
+	bump_mapping_generation(pid);
+	if (record_exe_path((struct task_struct *)bpf_get_current_task(), pid) == 0) {
+		notify_new_pid(pid);
+	} else {
+		/* The recorded path is the one of the previous executable: retry on the next sample. */
+		bpf_map_delete_elem(&seen_pids, &pid);
+	}
+
+	return 0;
+}
+
+/*
+ * sample_stack records the stack traces of the sampled task into the histogram.
+ * The user stacks are unwound with the unwind tables if dwarf, else with frame pointers.
+ */
+static __always_inline int sample_stack(struct bpf_perf_event_data *ctx, bool dwarf)
+{
+	histogram_key_t key = {};
+	histogram_value_t *value, init = {};
+	struct task_struct *task;
+	u32 *generation;
+
+	u64 pid_tgid = bpf_get_current_pid_tgid();
+
+	if (!should_sample(pid_tgid)) {
+		return 0;
+	}
+	key.pid = pid_tgid >> 32;
+	task = (struct task_struct *)bpf_get_current_task(); /* Current task struct */
+	generation = bpf_map_lookup_elem(&mapping_generations, &key.pid);
+	if (generation != NULL) {
+		key.mapping_generation = *generation;
+	}
+
+	/* The executable pathname is recorded on the first sample of the process, or on exec. */
+	if (bpf_map_lookup_elem(&seen_pids, &key.pid) == NULL) {
+		if (record_exe_path(task, key.pid) < 0) {
+			count_drop(key.pid, DROP_REASON_EXE_PATH);
+			return 0;
+		}
+		notify_new_pid(key.pid);
+	}
 
 	/* Sample the user and kernel stack traces, and record in the stack_traces structure. */
-	key.pid = bpf_get_current_pid_tgid() >> 32;
 	key.kernel_stack_id = bpf_get_stackid(ctx, &stack_traces, 0 | BPF_F_FAST_STACK_CMP);
-	key.user_stack_id = bpf_get_stackid(ctx, &stack_traces, 0 | BPF_F_FAST_STACK_CMP | BPF_F_USER_STACK);
+	if (dwarf) {
+		key.user_stack_id = get_user_stack_id(ctx, &key);
+	} else {
+		key.user_stack_id = bpf_get_stackid(ctx, &stack_traces, 0 | BPF_F_FAST_STACK_CMP | BPF_F_USER_STACK);
+	}
+	count_stack_drop(key.pid, key.kernel_stack_id);
+	count_stack_drop(key.pid, key.user_stack_id);
 	if ((int)key.kernel_stack_id < 0 && (int)key.user_stack_id < 0) {
 		return 0;
 	}
 
-	/* Get current task command */
-	bpf_get_current_comm(&comm, sizeof(comm));
-
-	/* Upsert stack trace histogram and binprm_info */
-	count = (u64*)bpf_map_lookup_elem(&histogram, &key);
-	if (count) {
-		(*count)++;
+	/* Upsert stack trace histogram */
+	/*
+	 * The time of the last sample lets user space resolve the addresses against
+	 * the memory mappings that were valid when the stack was last sampled.
+	 */
+	value = bpf_map_lookup_elem(&histogram, &key);
+	if (value) {
+		value->count++;
+		value->last_seen_ns = bpf_ktime_get_ns();
 	} else {
-		bpf_map_update_elem(&histogram, &key, &one, BPF_NOEXIST);
-		bpf_map_update_elem(&binprm_info, &key.pid, &exe_path_str, BPF_ANY);
-		bpf_trace_printk(hist_insert_fmt, sizeof(hist_insert_fmt), key.pid, comm, exe_path_str);
+		count_stack_trace(key.kernel_stack_id);
+		if (!(key.flags & HISTOGRAM_F_DWARF_USER_STACK)) {
+			count_stack_trace(key.user_stack_id);
+		}
+		init.count = 1;
+		init.last_seen_ns = bpf_ktime_get_ns();
+		if (bpf_map_update_elem(&histogram, &key, &init, BPF_NOEXIST) < 0) {
+			/* The key may have been inserted meanwhile on another CPU. */
+			value = bpf_map_lookup_elem(&histogram, &key);
+			if (value == NULL) {
+				count_drop(key.pid, DROP_REASON_HISTOGRAM_UPDATE);
+				return 0;
+			}
+			__sync_fetch_and_add(&value->count, 1);
+			value->last_seen_ns = init.last_seen_ns;
+			return 0;
+		}
+		count_map_entry(MAP_ENTRIES_HISTOGRAM);
+	}
+
+	return 0;
+}
+
+/*
+ * record_mmap bumps the mapping generation of the processes that map executable files,
+ * like the libraries loaded with dlopen. The anonymous mappings are not tracked, as the
+ * JIT code is resolved with the symbols of the JIT compilers, that change often.
+ */
+SEC("tracepoint/syscalls/sys_enter_mmap")
+int record_mmap(struct trace_event_raw_sys_enter *ctx)
+{
+	u64 pid_tgid = bpf_get_current_pid_tgid();
+
+	if (!(ctx->args[2] & PROT_EXEC) || (ctx->args[3] & MAP_ANONYMOUS)) {
+		return 0;
+	}
+	if (!should_sample(pid_tgid)) {
+		return 0;
 	}
+	bump_mapping_generation(pid_tgid >> 32);
 
 	return 0;
 }
 
+SEC("perf_event")
+int sample_stack_trace(struct bpf_perf_event_data* ctx)
+{
+	return sample_stack(ctx, false);
+}
+
+/*
+ * sample_stack_trace_dwarf is sample_stack_trace with the DWARF unwinder, that requires
+ * bpf_loop (Linux 5.17). User space loads it instead of the former, if the kernel supports it.
+ */
+SEC("perf_event")
+int sample_stack_trace_dwarf(struct bpf_perf_event_data* ctx)
+{
+	return sample_stack(ctx, true);
+}
+
 char _license[] SEC("license") = "GPL";
 
@@ -1,7 +1,62 @@
-#define K_NUM_MAP_ENTRIES	65536
+#define K_NUM_MAP_ENTRIES	65536 // default max entries, set by user space before loading
 #define PERF_MAX_STACK_DEPTH	127
 
-#define TASK_COMM_LEN	16
+#define PID_EVENTS_SIZE	(1 << 18) // ring buffer size in bytes
+
+#define UNWIND_ROWS_SIZE		(1 << 19) // rows of the unwind tables of all the objects
+#define UNWIND_ROWS_SEARCH_STEPS	20 // log2(UNWIND_ROWS_SIZE) + 1
+#define MAX_UNWIND_MAPPINGS		256 // per process
+#define UNWIND_MAPPINGS_SEARCH_STEPS	9 // log2(MAX_UNWIND_MAPPINGS) + 1
+#define USER_SPACE_END			0x0000800000000000 // end of the x86-64 user address space
+
+/* Canonical frame address rules of the unwind table rows. */
+#define CFA_TYPE_END		0
+#define CFA_TYPE_RSP		1
+#define CFA_TYPE_RBP		2
+#define CFA_TYPE_UNSUPPORTED	3
+
+/* Frame pointer rules of the unwind table rows. */
+#define RBP_TYPE_SAME		0
+#define RBP_TYPE_OFFSET		1
+#define RBP_TYPE_UNSUPPORTED	2
+
+/* The user stack has been unwound with the unwind tables, into dwarf_stack_traces. */
+#define HISTOGRAM_F_DWARF_USER_STACK	(1 << 0)
+
+/* Filters of the sampled tasks, in the filter_config flags. Without any, all the tasks are sampled. */
+#define FILTER_F_TGID		(1 << 0) // the processes in filter_tgids
+#define FILTER_F_PID		(1 << 1) // the threads in filter_pids
+#define MAX_FILTER_ENTRIES	1024
+
+/* Reasons of the dropped samples and stacks, the indexes of the sample_drops counters. */
+#define DROP_REASON_EXE_PATH		0 // the sample, as the executable path could not be read
+#define DROP_REASON_STACK_COLLISION	1 // a stack, as its hash collided in a stack map
+#define DROP_REASON_STACK_MAP_FULL	2 // a stack, as a stack map is full
+#define DROP_REASON_HISTOGRAM_UPDATE	3 // the sample, as the histogram update failed, like when full
+#define DROP_REASON_BINPRM_INFO_FULL	4 // the executable path, as binprm_info is full; the sample is kept
+#define DROP_REASONS			5
+
+/* Maps whose inserted entries are counted, the indexes of the map_entries counters. */
+#define MAP_ENTRIES_HISTOGRAM		0
+#define MAP_ENTRIES_DWARF_STACK_TRACES	1
+#define MAP_ENTRIES_BINPRM_INFO		2
+#define MAP_ENTRIES_STACK_TRACES	3
+#define MAP_ENTRIES			4
+
+/* Protection and flags of mmap(2), from <linux/mman.h>. */
+#define PROT_EXEC	0x4
+#define MAP_ANONYMOUS	0x20
+
+/* Error numbers of the bpf_get_stackid helper and of the map updates, from <errno.h>. */
+#ifndef ENOMEM
+#define ENOMEM	12
+#endif
+#ifndef EEXIST
+#define EEXIST	17
+#endif
+#ifndef E2BIG
+#define E2BIG	7
+#endif
 
 #define MAX_ARRAY_SIZE			(1 << 7) // on stack
 #define MAX_PERCPU_ARRAY_SIZE		(1 << 15) // on heaps
@@ -18,8 +73,88 @@ typedef struct histogram_key {
 	u32 pid;
 	u32 kernel_stack_id;
 	u32 user_stack_id;
+	u32 flags;
+	u32 mapping_generation; // of the memory mappings of the process, see mapping_generations
 } histogram_key_t;
 
+typedef struct histogram_value {
+	u64 count;
+	u64 last_seen_ns;	/* CLOCK_MONOTONIC time of the last sample */
+} histogram_value_t;
+
+/* pid_event notifies user space of the first sample of a process. */
+typedef struct pid_event {
+	u32 pid;
+} pid_event_t;
+
+/* filter_config is the configuration of the filters of the sampled tasks. */
+typedef struct filter_config {
+	u32 flags;
+} filter_config_t;
+
+/* sample_drops are the counters of the dropped samples and stacks of a process, by reason. */
+typedef struct sample_drops {
+	u64 dropped[DROP_REASONS];
+} sample_drops_t;
+
+typedef struct stack_trace {
+	u64 ips[PERF_MAX_STACK_DEPTH];
+} stack_trace_t;
+
+/* unwind_row is the rule to unwind the frames of the code from its address. */
+typedef struct unwind_row {
+	u64 pc;
+	u8 cfa_type;
+	u8 rbp_type;
+	s16 cfa_offset;
+	s16 rbp_offset;
+	u16 pad;
+} unwind_row_t;
+
+/* unwind_mapping is an executable mapping, with the rows of the unwind table of its object. */
+typedef struct unwind_mapping {
+	u64 start;
+	u64 end;
+	u64 bias;	/* the table addresses are the mapped ones minus the bias */
+	u32 row_start;
+	u32 row_count;
+} unwind_mapping_t;
+
+/* unwind_process is the unwind information of a process, with the mappings sorted by address. */
+typedef struct unwind_process {
+	u32 len;
+	u32 pad;
+	unwind_mapping_t mappings[MAX_UNWIND_MAPPINGS];
+} unwind_process_t;
+
+/* unwind_mapping_search is the state of the binary search of the mapping of an address. */
+typedef struct unwind_mapping_search {
+	unwind_process_t *proc;
+	u64 addr;
+	u32 lo;
+	u32 hi;
+	unwind_mapping_t *found;
+} unwind_mapping_search_t;
+
+/* unwind_row_search is the state of the binary search of the unwind row of an address. */
+typedef struct unwind_row_search {
+	u64 pc;
+	u32 lo;
+	u32 hi;
+	u32 end;
+	u32 found;
+} unwind_row_search_t;
+
+/* stack_unwind_state is the state of the unwinding of a user stack, across the frames. */
+typedef struct stack_unwind_state {
+	u64 ip;
+	u64 sp;
+	u64 bp;
+	int depth;
+	unwind_process_t *proc;
+	stack_trace_t *stack;
+} stack_unwind_state_t;
+
 typedef struct buffer {
 	u8 data[MAX_PERCPU_ARRAY_SIZE];
 } buffer_t;
@@ -1,25 +1,51 @@
 package profile
 
 import (
-	"errors"
-	"fmt"
+	"os"
+	"time"
 
 	log "github.com/rs/zerolog"
 	"github.com/spf13/cobra"
 
 	"github.com/maxgio92/yap/internal/commands/options"
-	"github.com/maxgio92/yap/pkg/dag"
+	"github.com/maxgio92/yap/internal/output"
+	"github.com/maxgio92/yap/pkg/debuginfod"
+	"github.com/maxgio92/yap/pkg/normalize"
 	"github.com/maxgio92/yap/pkg/profile"
+	"github.com/maxgio92/yap/pkg/symcache"
+	"github.com/maxgio92/yap/pkg/symtable"
 )
 
+// formatRaw is the output format of the unsymbolized profile.
+const formatRaw = "raw"
+
 type Options struct {
-	pid          int
-	outputFormat string
+	pid               int
+	tids              []int
+	outputFormat      string
+	symbolPaths       []string
+	debuginfodURLs    []string
+	debuginfodTimeout time.Duration
+	showOffsets       bool
+	demangle          string
+	normalize         bool
+	normalizeRules    []string
+	symCacheSize      int
+	indexCache        bool
+	indexCacheDir     string
+	indexCacheMaxSize int64
+	mapsPollInterval  time.Duration
+	dwarfUnwinding    bool
+	stackTracesSize   uint32
+	histogramSize     uint32
+	binprmInfoSize    uint32
+	mapAutoResize     bool
+	probeStats        bool
 	*options.CommonOptions
 }
 
 func NewCommand(opts *options.CommonOptions) *cobra.Command {
-	o := &Options{0, "", opts}
+	o := &Options{CommonOptions: opts}
 
 	cmd := &cobra.Command{
 		Use:   "profile",
@@ -27,7 +53,26 @@ func NewCommand(opts *options.CommonOptions) *cobra.Command {
 		RunE:  o.Run,
 	}
 	cmd.Flags().IntVar(&o.pid, "pid", 0, "the PID of the process")
-	cmd.Flags().StringVarP(&o.outputFormat, "output", "o", "dot", "the format of output (dot, text)")
+	cmd.Flags().IntSliceVar(&o.tids, "tid", nil, "the IDs of the threads of the process to sample, instead of all of them")
+	cmd.Flags().StringVarP(&o.outputFormat, "output", "o", "dot", "the format of output (dot, text, raw); raw is the unsymbolized profile, to be resolved with yap symbolize")
+	cmd.Flags().StringSliceVar(&o.symbolPaths, "symbol-path", nil, "the directories where to look up separate debug files, in addition to /usr/lib/debug")
+	cmd.Flags().StringSliceVar(&o.debuginfodURLs, "debuginfod-urls", debuginfod.URLsFromEnv(), "the debuginfod server URLs to fetch debug files from (default from $DEBUGINFOD_URLS)")
+	cmd.Flags().DurationVar(&o.debuginfodTimeout, "debuginfod-timeout", debuginfod.DefaultTimeout, "the timeout of requests to the debuginfod servers")
+	cmd.Flags().BoolVar(&o.showOffsets, "show-offsets", false, "show the frames as the symbol followed by the offset of the instruction, like func+0x1a")
+	cmd.Flags().StringVar(&o.demangle, "demangle", string(symtable.DemangleFull), "how the C++ and Rust function names are demangled (full, simplified, none); simplified strips the parameters, template arguments and hashes")
+	cmd.Flags().BoolVar(&o.normalize, "normalize", true, "whether to normalize the function names with the built-in rules, that merge Go closures and generic instantiations, Rust hashes, compiler clones and versioned and CPU specific C library functions")
+	cmd.Flags().StringArrayVar(&o.normalizeRules, "normalize-rule", nil, "a rule that normalizes the function names, in the rewrite:PATTERN=>REPLACEMENT or merge:PATTERN=>NAME form, applied after the built-in ones; rewrite replaces the matches of the regular expression, merge replaces the whole matching names")
+	cmd.Flags().IntVar(&o.symCacheSize, "symcache-size", symcache.DefaultSize, "the maximum number of instruction pointers whose symbols are cached (0 for unbounded)")
+	cmd.Flags().BoolVar(&o.indexCache, "symbol-cache", true, "whether to keep the symbol indexes of the profiled objects in a persistent cache")
+	cmd.Flags().StringVar(&o.indexCacheDir, "symbol-cache-dir", "", "the directory of the persistent symbol cache (default $XDG_CACHE_HOME/yap/symbols)")
+	cmd.Flags().Int64Var(&o.indexCacheMaxSize, "symbol-cache-max-size", symtable.DefaultIndexCacheMaxSize, "the maximum size in bytes of the persistent symbol cache (0 for unbounded)")
+	cmd.Flags().DurationVar(&o.mapsPollInterval, "maps-poll-interval", profile.DefaultMapsPollInterval, "the interval the memory mappings of the process are read at, to track the libraries loaded and unloaded while profiling (0 to disable)")
+	cmd.Flags().BoolVar(&o.dwarfUnwinding, "dwarf-unwinding", true, "whether to unwind the user stacks with the .eh_frame and .debug_frame unwind tables of the mapped objects, for the code built without frame pointers")
+	cmd.Flags().Uint32Var(&o.stackTracesSize, "stack-traces-map-size", profile.DefaultMapSize, "the max entries of the BPF maps of the stack traces")
+	cmd.Flags().Uint32Var(&o.histogramSize, "histogram-map-size", profile.DefaultMapSize, "the max entries of the BPF map of the sample counts, one per distinct pair of kernel and user stack traces")
+	cmd.Flags().Uint32Var(&o.binprmInfoSize, "binprm-info-map-size", profile.DefaultMapSize, "the max entries of the BPF maps of the sampled processes")
+	cmd.Flags().BoolVar(&o.mapAutoResize, "map-auto-resize", true, "whether to reload the BPF probe with maps of double size, up to 1048576 entries, when they are near full while profiling")
+	cmd.Flags().BoolVar(&o.probeStats, "probe-stats", false, "whether to log the run-time statistics of the BPF probe programs, like their run count and average run time")
 	cmd.MarkFlagRequired("pid")
 
 	return cmd
@@ -38,66 +83,67 @@ func (o *Options) Run(_ *cobra.Command, _ []string) error {
 		o.Logger = o.Logger.Level(log.DebugLevel)
 	}
 
+	demangle, err := symtable.ParseDemangleMode(o.demangle)
+	if err != nil {
+		return err
+	}
+
+	rules, err := normalize.FromFlags(!o.normalize, o.normalizeRules)
+	if err != nil {
+		return err
+	}
+
+	var indexCache *symtable.IndexCache
+	if o.indexCache {
+		dir := o.indexCacheDir
+		if dir == "" {
+			dir = symtable.DefaultIndexCacheDir()
+		}
+		indexCache = symtable.NewIndexCache(dir, symtable.WithIndexCacheMaxSize(o.indexCacheMaxSize))
+	}
+
 	profiler := profile.NewProfiler(
 		profile.WithPID(o.pid),
+		profile.WithTIDs(o.tids),
 		profile.WithSamplingPeriodMillis(11),
 		profile.WithProbeName("sample_stack_trace"),
 		profile.WithProbe(o.Probe),
 		profile.WithMapStackTraces("stack_traces"),
 		profile.WithMapHistogram("histogram"),
+		profile.WithSymbolPaths(o.symbolPaths),
+		profile.WithDebuginfod(debuginfod.NewClient(
+			debuginfod.WithURLs(o.debuginfodURLs),
+			debuginfod.WithTimeout(o.debuginfodTimeout),
+		)),
+		profile.WithShowOffsets(o.showOffsets),
+		profile.WithDemangle(demangle),
+		profile.WithNormalizeRules(rules),
+		profile.WithSymCacheSize(o.symCacheSize),
+		profile.WithIndexCache(indexCache),
+		profile.WithMapsPollInterval(o.mapsPollInterval),
+		profile.WithDWARFUnwinding(o.dwarfUnwinding),
+		profile.WithStackTracesMapSize(o.stackTracesSize),
+		profile.WithHistogramMapSize(o.histogramSize),
+		profile.WithBinprmInfoMapSize(o.binprmInfoSize),
+		profile.WithMapAutoResize(o.mapAutoResize),
+		profile.WithProbeStats(o.probeStats),
 		profile.WithLogger(o.Logger),
 	)
 
 	// Run profile.
-	report, err := profiler.RunProfile(o.Ctx)
-	if err != nil {
-		return err
-	}
-
-	switch o.outputFormat {
-	case "dot":
-		err = o.printDOT(report)
-	case "text":
-		err = o.printText(report)
-	default:
-		err = o.printText(report)
-	}
-	if err != nil {
-		return err
+	// The raw profile is written unsymbolized, to be symbolized later.
+	if o.outputFormat == formatRaw {
+		raw, err := profiler.RunRawProfile(o.Ctx)
+		if err != nil {
+			return err
+		}
+		return raw.Write(os.Stdout)
 	}
 
-	return nil
-}
-
-// printDOT prints a DOT representation of the profile DAG.
-func (o *Options) printDOT(graph *dag.DAG) error {
-	dot, err := graph.DOT()
+	report, err := profiler.RunProfile(o.Ctx)
 	if err != nil {
 		return err
 	}
-	fmt.Println(dot)
-
-	return nil
-}
-
-// printDOT prints a text representation of the profile DAG.
-func (o *Options) printText(graph *dag.DAG) error {
-	it := graph.Nodes()
-	for it.Next() {
-		n := it.Node()
-		if n == nil {
-			return errors.New("node is nil")
-		}
-
-		v := graph.Node(n.ID())
-		node, ok := v.(*dag.Node)
-		if !ok {
-			return fmt.Errorf("unexpected node type: %T", node)
-		}
-		if node.Weight > 0 {
-			fmt.Printf("%.1f%%	%s\n", node.Weight*100, node.Symbol)
-		}
-	}
 
-	return nil
+	return output.Print(report, o.outputFormat)
 }
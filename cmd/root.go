@@ -10,6 +10,7 @@ import (
 	"github.com/spf13/cobra"
 
 	"github.com/maxgio92/yap/cmd/profile"
+	"github.com/maxgio92/yap/cmd/symbolize"
 	"github.com/maxgio92/yap/internal/commands/options"
 )
 
@@ -21,6 +22,7 @@ func NewRootCmd(opts *options.CommonOptions) *cobra.Command {
 		DisableAutoGenTag: true,
 	}
 	cmd.AddCommand(profile.NewCommand(opts))
+	cmd.AddCommand(symbolize.NewCommand(opts))
 	cmd.PersistentFlags().BoolVar(&opts.Debug, "debug", false, "Sets log level to debug")
 
 	return cmd
@@ -0,0 +1,98 @@
+package symbolize
+
+import (
+	"io"
+	"os"
+
+	log "github.com/rs/zerolog"
+	"github.com/spf13/cobra"
+
+	"github.com/maxgio92/yap/internal/commands/options"
+	"github.com/maxgio92/yap/internal/output"
+	"github.com/maxgio92/yap/pkg/normalize"
+	"github.com/maxgio92/yap/pkg/profile"
+	"github.com/maxgio92/yap/pkg/symcache"
+	"github.com/maxgio92/yap/pkg/symtable"
+)
+
+type Options struct {
+	input          string
+	outputFormat   string
+	symbolPaths    []string
+	kallsymsPath   string
+	showOffsets    bool
+	demangle       string
+	normalize      bool
+	normalizeRules []string
+	symCacheSize   int
+	*options.CommonOptions
+}
+
+func NewCommand(opts *options.CommonOptions) *cobra.Command {
+	o := &Options{CommonOptions: opts}
+
+	cmd := &cobra.Command{
+		Use:   "symbolize",
+		Short: "symbolize resolves a raw profile recorded with yap profile --output=raw against local binaries and debug files",
+		RunE:  o.Run,
+	}
+	cmd.Flags().StringVarP(&o.input, "input", "i", "-", "the path of the raw profile, or - for the standard input")
+	cmd.Flags().StringVarP(&o.outputFormat, "output", "o", "dot", "the format of output (dot, text)")
+	cmd.Flags().StringSliceVar(&o.symbolPaths, "symbol-path", nil, "the directories where to look up the profiled binaries, by build-id, path or file name, and their separate debug files, in addition to /usr/lib/debug")
+	cmd.Flags().StringVar(&o.kallsymsPath, "kallsyms", "", "the path of the kallsyms file of the profiled machine, to symbolize kernel stacks")
+	cmd.Flags().BoolVar(&o.showOffsets, "show-offsets", false, "show the frames as the symbol followed by the offset of the instruction, like func+0x1a")
+	cmd.Flags().StringVar(&o.demangle, "demangle", string(symtable.DemangleFull), "how the C++ and Rust function names are demangled (full, simplified, none); simplified strips the parameters, template arguments and hashes")
+	cmd.Flags().BoolVar(&o.normalize, "normalize", true, "whether to normalize the function names with the built-in rules, that merge Go closures and generic instantiations, Rust hashes, compiler clones and versioned and CPU specific C library functions")
+	cmd.Flags().StringArrayVar(&o.normalizeRules, "normalize-rule", nil, "a rule that normalizes the function names, in the rewrite:PATTERN=>REPLACEMENT or merge:PATTERN=>NAME form, applied after the built-in ones; rewrite replaces the matches of the regular expression, merge replaces the whole matching names")
+	cmd.Flags().IntVar(&o.symCacheSize, "symcache-size", symcache.DefaultSize, "the maximum number of instruction pointers whose symbols are cached (0 for unbounded)")
+
+	return cmd
+}
+
+func (o *Options) Run(_ *cobra.Command, _ []string) error {
+	if o.Debug {
+		o.Logger = o.Logger.Level(log.DebugLevel)
+	}
+
+	demangle, err := symtable.ParseDemangleMode(o.demangle)
+	if err != nil {
+		return err
+	}
+
+	rules, err := normalize.FromFlags(!o.normalize, o.normalizeRules)
+	if err != nil {
+		return err
+	}
+
+	var r io.Reader = os.Stdin
+	if o.input != "-" {
+		f, err := os.Open(o.input)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	raw, err := profile.ReadRawProfile(r)
+	if err != nil {
+		return err
+	}
+
+	profiler := profile.NewProfiler(
+		profile.WithKallsymsPath(o.kallsymsPath),
+		profile.WithSymbolPaths(o.symbolPaths),
+		profile.WithShowOffsets(o.showOffsets),
+		profile.WithDemangle(demangle),
+		profile.WithNormalizeRules(rules),
+		profile.WithSymCacheSize(o.symCacheSize),
+		profile.WithLogger(o.Logger),
+	)
+
+	report, err := profiler.SymbolizeRawProfile(raw)
+	if err != nil {
+		return err
+	}
+
+	return output.Print(report, o.outputFormat)
+}
@@ -6,10 +6,12 @@ toolchain go1.23.3
 
 require (
 	github.com/aquasecurity/libbpfgo v0.6.0-libbpf-1.3
+	github.com/ianlancetaylor/demangle v0.0.0-20260724033716-83e58baca724
 	github.com/pkg/errors v0.9.1
 	github.com/rs/zerolog v1.32.0
 	github.com/spf13/cobra v1.8.1
 	github.com/stretchr/testify v1.8.4
+	github.com/ulikunitz/xz v0.5.17
 	golang.org/x/sys v0.14.0
 	gonum.org/v1/gonum v0.15.1
 )
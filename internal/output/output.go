@@ -0,0 +1,76 @@
+package output
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/maxgio92/yap/pkg/dag"
+)
+
+const (
+	FormatDOT  = "dot"
+	FormatText = "text"
+)
+
+// Print prints the profile DAG in the specified format, defaulting to text.
+func Print(graph *dag.DAG, format string) error {
+	switch format {
+	case FormatDOT:
+		return PrintDOT(graph)
+	default:
+		return PrintText(graph)
+	}
+}
+
+// PrintDOT prints a DOT representation of the profile DAG.
+func PrintDOT(graph *dag.DAG) error {
+	dot, err := graph.DOT()
+	if err != nil {
+		return err
+	}
+	fmt.Println(dot)
+
+	return nil
+}
+
+// PrintText prints a text representation of the profile DAG,
+// preceded by the quality summary and the warnings about its accuracy.
+func PrintText(graph *dag.DAG) error {
+	if quality := graph.Quality(); quality != nil {
+		fmt.Printf("# quality: %s\n", quality)
+	}
+	for _, warning := range graph.Warnings() {
+		fmt.Printf("# warning: %s\n", warning)
+	}
+
+	it := graph.Nodes()
+	for it.Next() {
+		n := it.Node()
+		if n == nil {
+			return errors.New("node is nil")
+		}
+
+		v := graph.Node(n.ID())
+		node, ok := v.(*dag.Node)
+		if !ok {
+			return fmt.Errorf("unexpected node type: %T", node)
+		}
+		if node.Weight > 0 {
+			symbol := node.Symbol
+			if node.Inlined {
+				symbol += " (inlined)"
+			}
+			location := node.Location()
+			if location == "" {
+				location = "-"
+			}
+			source := node.Source
+			if source == "" {
+				source = "-"
+			}
+			fmt.Printf("%.1f%%	%s	%s	%s\n", node.Weight*100, symbol, location, source)
+		}
+	}
+
+	return nil
+}
@@ -5,6 +5,8 @@ import (
 	"gonum.org/v1/gonum/graph/encoding"
 	"gonum.org/v1/gonum/graph/encoding/dot"
 	"gonum.org/v1/gonum/graph/simple"
+	"path/filepath"
+	"strings"
 )
 
 const (
@@ -17,6 +19,51 @@ type Node struct {
 	id     int64
 	Symbol string
 	Weight float64
+
+	// Source is the source of symbol information the symbol has been resolved from.
+	Source string
+
+	// File and Line are the source location of the frame, if known.
+	File string
+	Line int
+
+	// Inlined is whether the frame is of a function inlined into its caller.
+	Inlined bool
+}
+
+// NodeOption sets optional attributes of a node.
+type NodeOption func(node *Node)
+
+// WithSource sets the source of symbol information the node symbol has been resolved from.
+func WithSource(source string) NodeOption {
+	return func(node *Node) {
+		node.Source = source
+	}
+}
+
+// WithLocation sets the source file and line of the node frame.
+func WithLocation(file string, line int) NodeOption {
+	return func(node *Node) {
+		node.File = file
+		node.Line = line
+	}
+}
+
+// WithInlined sets whether the node frame is of an inlined function.
+func WithInlined(inlined bool) NodeOption {
+	return func(node *Node) {
+		node.Inlined = inlined
+	}
+}
+
+// Location returns the source location of the node frame in the file:line form,
+// or an empty string if it's unknown.
+func (n *Node) Location() string {
+	if n.File == "" {
+		return ""
+	}
+
+	return fmt.Sprintf("%s:%d", n.File, n.Line)
 }
 
 // ID returns the unique identifier of the node.
@@ -32,12 +79,18 @@ func (n *Node) Attributes() []encoding.Attribute {
 	}
 
 	label := n.Symbol
+	if n.Inlined {
+		label += " (inlined)"
+	}
+	if n.File != "" {
+		label += fmt.Sprintf("\n%s:%d", filepath.Base(n.File), n.Line)
+	}
 	fillcolor := "0 0 1"
 	if leaf {
 		label += fmt.Sprintf("\n%.1f%%", n.Weight*100)
 		fillcolor = fmt.Sprintf("0 %.1f 0.9", n.Weight)
 	}
-	return []encoding.Attribute{
+	attrs := []encoding.Attribute{
 		{Key: "label", Value: label}, // Symbol for the node
 		{Key: "style", Value: dotNodeStyle},
 		{Key: "fillcolor", Value: fillcolor},
@@ -45,13 +98,30 @@ func (n *Node) Attributes() []encoding.Attribute {
 		{Key: "width", Value: fmt.Sprintf("%.3f", n.Weight*5)},
 		{Key: "height", Value: fmt.Sprintf("%.3f", n.Weight*5)},
 	}
+	var tooltip string
+	if n.File != "" {
+		tooltip = n.Location()
+	}
+	if n.Source != "" {
+		if tooltip != "" {
+			tooltip += "\n"
+		}
+		tooltip += fmt.Sprintf("source: %s", n.Source)
+	}
+	if tooltip != "" {
+		attrs = append(attrs, encoding.Attribute{Key: "tooltip", Value: tooltip})
+	}
+
+	return attrs
 }
 
 // DAG wraps Gonum's directed graph and provides methods to
 // add nodes and edges, as well as export to DOT format.
 type DAG struct {
 	*simple.DirectedGraph
-	nodes map[int64]*Node
+	nodes    map[int64]*Node
+	warnings []string
+	quality  *Quality
 }
 
 // NewDAG creates a new DAG.
@@ -64,8 +134,11 @@ func NewDAG() *DAG {
 
 // AddCustomNode adds a node to the DAG and returns its ID.
 // func (dag *DAG) AddCustomNode(id int64, symbol string, weight float64) {
-func (dag *DAG) AddCustomNode(id int64, symbol string, weight float64) {
+func (dag *DAG) AddCustomNode(id int64, symbol string, weight float64, opts ...NodeOption) {
 	node := &Node{id: id, Symbol: symbol, Weight: weight}
+	for _, f := range opts {
+		f(node)
+	}
 	dag.nodes[id] = node
 	dag.AddNode(node)
 }
@@ -82,6 +155,49 @@ func (dag *DAG) AddCustomEdge(fromID, toID int64) error {
 	return nil
 }
 
+// AddWarning adds a warning about the accuracy of the profile,
+// like for frames resolved with binaries other than the profiled ones.
+func (dag *DAG) AddWarning(warning string) {
+	dag.warnings = append(dag.warnings, warning)
+}
+
+// Warnings returns the warnings about the accuracy of the profile.
+func (dag *DAG) Warnings() []string {
+	return dag.warnings
+}
+
+// DOTAttributers implements the dot.Attributers interface,
+// to show the quality summary and the warnings as the graph label.
+func (dag *DAG) DOTAttributers() (graph, node, edge encoding.Attributer) {
+	var graphAttrs attributes
+	labels := make([]string, 0, len(dag.warnings)+1)
+	if dag.quality != nil {
+		labels = append(labels, "quality: "+dag.quality.String())
+	}
+	for _, warning := range dag.warnings {
+		labels = append(labels, "warning: "+warning)
+	}
+	if len(labels) > 0 {
+		graphAttrs = attributes{
+			{Key: "label", Value: strings.Join(labels, "\n")},
+			{Key: "labelloc", Value: "t"},
+		}
+	}
+	if len(dag.warnings) > 0 {
+		graphAttrs = append(graphAttrs, encoding.Attribute{Key: "fontcolor", Value: "red"})
+	}
+
+	return graphAttrs, attributes{}, attributes{}
+}
+
+// attributes is a list of DOT attributes.
+type attributes []encoding.Attribute
+
+// Attributes implements the encoding.Attributer interface.
+func (a attributes) Attributes() []encoding.Attribute {
+	return a
+}
+
 // DOT returns a DOT representation of the DAG.
 func (dag *DAG) DOT() (string, error) {
 	data, err := dot.Marshal(dag, "DAG", "", "  ")
@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"gonum.org/v1/gonum/graph/encoding"
 
 	. "github.com/maxgio92/yap/pkg/dag"
 )
@@ -24,6 +25,36 @@ func TestAddCustomNode(t *testing.T) {
 	assert.Equal(t, "main.foo", node.Symbol)
 }
 
+func TestAddCustomNodeWithSource(t *testing.T) {
+	dag := NewDAG()
+	id := int64(1)
+	dag.AddCustomNode(id, "main.foo", 0.20, WithSource("gopclntab"))
+
+	node, ok := dag.Node(id).(*Node)
+	if !ok {
+		t.Fatal()
+	}
+
+	assert.Equal(t, "gopclntab", node.Source)
+	assert.Contains(t, node.Attributes(), encoding.Attribute{Key: "tooltip", Value: "source: gopclntab"})
+}
+
+func TestAddCustomNodeWithLocation(t *testing.T) {
+	dag := NewDAG()
+	id := int64(1)
+	dag.AddCustomNode(id, "main.foo", 0, WithLocation("/src/main.go", 12), WithInlined(true), WithSource("dwarf"))
+
+	node, ok := dag.Node(id).(*Node)
+	if !ok {
+		t.Fatal()
+	}
+
+	assert.Equal(t, "/src/main.go:12", node.Location())
+	assert.True(t, node.Inlined)
+	assert.Contains(t, node.Attributes(), encoding.Attribute{Key: "label", Value: "main.foo (inlined)\nmain.go:12"})
+	assert.Contains(t, node.Attributes(), encoding.Attribute{Key: "tooltip", Value: "/src/main.go:12\nsource: dwarf"})
+}
+
 func TestAddCustomEdge(t *testing.T) {
 	dag := NewDAG()
 	id1 := int64(1)
@@ -39,3 +70,38 @@ func TestAddCustomEdge(t *testing.T) {
 		t.Fatal()
 	}
 }
+
+func TestWarnings(t *testing.T) {
+	dag := NewDAG()
+	dag.AddCustomNode(1, "main.foo", 1)
+	dag.AddWarning("/usr/bin/app (deleted) symbolized with /usr/bin/app")
+
+	assert.Equal(t, []string{"/usr/bin/app (deleted) symbolized with /usr/bin/app"}, dag.Warnings())
+	dot, err := dag.DOT()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Contains(t, dot, `label="warning: /usr/bin/app (deleted) symbolized with /usr/bin/app"`)
+}
+
+func TestQuality(t *testing.T) {
+	dag := NewDAG()
+	dag.AddCustomNode(1, "main.foo", 1)
+	assert.Nil(t, dag.Quality())
+
+	dag.SetQuality(&Quality{
+		Collected:    100,
+		Dropped:      map[string]uint64{"stack_map_full": 3, "histogram_update": 1},
+		Truncated:    2,
+		Frames:       500,
+		Unsymbolized: 10,
+	})
+	assert.Equal(t, uint64(4), dag.Quality().TotalDropped())
+	summary := "collected 100 samples, dropped 4 (histogram_update 1, stack_map_full 3), truncated 2 stacks, unsymbolized 10/500 frames"
+	assert.Equal(t, summary, dag.Quality().String())
+	dot, err := dag.DOT()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Contains(t, dot, `label="quality: `+summary+`"`)
+}
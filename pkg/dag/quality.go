@@ -0,0 +1,66 @@
+package dag
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Quality is the summary of the samples the profile has been built from,
+// and of how much of them could be resolved.
+type Quality struct {
+	// Collected is the number of samples in the profile.
+	Collected uint64
+
+	// Dropped is the number of the samples, or of their stacks, lost while collecting, by reason.
+	Dropped map[string]uint64
+
+	// Truncated is the number of the samples with a stack deeper than the maximum depth.
+	Truncated uint64
+
+	// Frames is the number of the sampled frames, weighted by their samples,
+	// and Unsymbolized the number of the ones that could not be symbolized.
+	Frames       uint64
+	Unsymbolized uint64
+}
+
+// TotalDropped returns the number of the samples and stacks dropped for any reason.
+func (q *Quality) TotalDropped() uint64 {
+	var total uint64
+	for _, n := range q.Dropped {
+		total += n
+	}
+
+	return total
+}
+
+// String returns a one line summary, like
+// "collected 1000 samples, dropped 2 (stack_collision 2), truncated 0 stacks, unsymbolized 10/5000 frames".
+func (q *Quality) String() string {
+	dropped := fmt.Sprintf("dropped %d", q.TotalDropped())
+	if len(q.Dropped) > 0 {
+		reasons := make([]string, 0, len(q.Dropped))
+		for reason, n := range q.Dropped {
+			if n > 0 {
+				reasons = append(reasons, fmt.Sprintf("%s %d", reason, n))
+			}
+		}
+		sort.Strings(reasons)
+		if len(reasons) > 0 {
+			dropped += fmt.Sprintf(" (%s)", strings.Join(reasons, ", "))
+		}
+	}
+
+	return fmt.Sprintf("collected %d samples, %s, truncated %d stacks, unsymbolized %d/%d frames",
+		q.Collected, dropped, q.Truncated, q.Unsymbolized, q.Frames)
+}
+
+// SetQuality sets the summary of the samples of the profile.
+func (dag *DAG) SetQuality(quality *Quality) {
+	dag.quality = quality
+}
+
+// Quality returns the summary of the samples of the profile, or nil if unknown.
+func (dag *DAG) Quality() *Quality {
+	return dag.quality
+}
@@ -0,0 +1,225 @@
+package debuginfod
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/maxgio92/yap/pkg/symtable"
+)
+
+const (
+	// EnvURLs is the environment variable holding the space-separated debuginfod server URLs.
+	EnvURLs = "DEBUGINFOD_URLS"
+
+	// EnvCachePath is the environment variable holding the debuginfod client cache directory.
+	EnvCachePath = "DEBUGINFOD_CACHE_PATH"
+
+	// DefaultTimeout is the default timeout of a request to a debuginfod server.
+	DefaultTimeout = 10 * time.Second
+
+	cacheDirName      = "debuginfod_client"
+	debugInfoArtifact = "debuginfo"
+)
+
+var (
+	ErrNotFound        = errors.New("debuginfo not found")
+	ErrNoServers       = errors.New("no debuginfod servers configured")
+	ErrBadBuildID      = errors.New("invalid build-id")
+	ErrBuildIDMismatch = errors.New("debuginfo build-id mismatch")
+)
+
+// URLsFromEnv returns the debuginfod server URLs from the DEBUGINFOD_URLS environment variable.
+func URLsFromEnv() []string {
+	return strings.Fields(os.Getenv(EnvURLs))
+}
+
+// DefaultCacheDir returns the debuginfod client cache directory, that is
+// the DEBUGINFOD_CACHE_PATH environment variable if set, or the
+// debuginfod_client directory under the user cache directory.
+func DefaultCacheDir() string {
+	if dir := os.Getenv(EnvCachePath); dir != "" {
+		return dir
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+
+	return filepath.Join(dir, cacheDirName)
+}
+
+// Client is a client of the debuginfod HTTP API, that fetches debug files
+// by GNU build-id and keeps them in an on-disk cache.
+type Client struct {
+	urls       []string
+	cacheDir   string
+	httpClient *http.Client
+
+	// unreachable are the servers that could not be connected to, or did not
+	// respond in time, which are not queried anymore to not wait for timeouts
+	// on each request. The ones that respond with an error are queried again.
+	unreachable map[string]error
+	lock        sync.Mutex
+}
+
+type ClientOption func(c *Client)
+
+func WithURLs(urls []string) ClientOption {
+	return func(c *Client) {
+		c.urls = urls
+	}
+}
+
+func WithCacheDir(dir string) ClientOption {
+	return func(c *Client) {
+		c.cacheDir = dir
+	}
+}
+
+func WithTimeout(timeout time.Duration) ClientOption {
+	return func(c *Client) {
+		c.httpClient.Timeout = timeout
+	}
+}
+
+func NewClient(opts ...ClientOption) *Client {
+	client := new(Client)
+	client.urls = URLsFromEnv()
+	client.cacheDir = DefaultCacheDir()
+	client.httpClient = &http.Client{Timeout: DefaultTimeout}
+	client.unreachable = make(map[string]error)
+	for _, f := range opts {
+		f(client)
+	}
+
+	return client
+}
+
+// Enabled returns whether any debuginfod server is configured.
+func (c *Client) Enabled() bool {
+	return len(c.urls) > 0
+}
+
+// FetchDebugInfo returns the path of the debug file with the specified build-id.
+// The file is looked up in the cache first, and then fetched from the servers
+// in order, until one has it with the build-id. Unreachable servers are skipped afterwards.
+func (c *Client) FetchDebugInfo(ctx context.Context, buildID string) (string, error) {
+	if !validBuildID(buildID) {
+		return "", ErrBadBuildID
+	}
+	path := filepath.Join(c.cacheDir, buildID, debugInfoArtifact)
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+	if !c.Enabled() {
+		return "", ErrNoServers
+	}
+
+	for _, url := range c.urls {
+		if c.isUnreachable(url) {
+			continue
+		}
+		if err := c.fetch(ctx, url, buildID, path); err == nil {
+			return path, nil
+		}
+	}
+
+	return "", ErrNotFound
+}
+
+// fetch downloads the debug file from the server to the cache path,
+// if it has the build-id.
+func (c *Client) fetch(ctx context.Context, url, buildID, path string) error {
+	endpoint := fmt.Sprintf("%s/buildid/%s/%s", strings.TrimSuffix(url, "/"), buildID, debugInfoArtifact)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return errors.Wrap(err, "error creating the request")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		// Unless canceled by the caller, the server could not be reached.
+		if ctx.Err() == nil {
+			c.setUnreachable(url, err)
+		}
+		return errors.Wrap(err, "error requesting debuginfo")
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return ErrNotFound
+	case resp.StatusCode != http.StatusOK:
+		return fmt.Errorf("unexpected response status: %s", resp.Status)
+	}
+
+	if err = os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return errors.Wrap(err, "error creating the cache directory")
+	}
+	// Write to a temporary file and rename it, so that concurrent
+	// clients never see a partially written file.
+	tmp, err := os.CreateTemp(filepath.Dir(path), debugInfoArtifact+".*.tmp")
+	if err != nil {
+		return errors.Wrap(err, "error creating the cache file")
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = io.Copy(tmp, resp.Body); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "error downloading debuginfo")
+	}
+	if err = tmp.Close(); err != nil {
+		return errors.Wrap(err, "error writing the cache file")
+	}
+	// The servers may return a different file, like a stale or corrupted one.
+	id, err := symtable.ReadFileBuildID(tmp.Name())
+	if err != nil {
+		return errors.Wrap(err, "error reading the debuginfo build-id")
+	}
+	if id != buildID {
+		return errors.Wrap(ErrBuildIDMismatch, id)
+	}
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return errors.Wrap(err, "error writing the cache file")
+	}
+
+	return nil
+}
+
+func (c *Client) isUnreachable(url string) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	_, ok := c.unreachable[url]
+
+	return ok
+}
+
+func (c *Client) setUnreachable(url string, err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.unreachable[url] = err
+}
+
+// validBuildID returns whether the build-id is a hex string, so that
+// it's safe to be used in the cache paths and the request URLs.
+func validBuildID(buildID string) bool {
+	if len(buildID) == 0 {
+		return false
+	}
+	for _, c := range buildID {
+		if !strings.ContainsRune("0123456789abcdef", c) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,198 @@
+package debuginfod_test
+
+import (
+	"bytes"
+	"context"
+	"debug/elf"
+	"encoding/binary"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/maxgio92/yap/pkg/debuginfod"
+)
+
+const (
+	testBuildID      = "f39ff822ac4b7b6ef41a806600eb290465b46e48"
+	testOtherBuildID = "0123456789abcdef0123456789abcdef01234567"
+
+	// Sizes of the ELF64 headers, and type of the GNU build-id note.
+	elfHeaderSize  = 64
+	elfProgSize    = 56
+	elfSectionSize = 64
+	ntGNUBuildID   = 3
+)
+
+// newTestELF returns an ELF file with only a note segment, with the GNU build-id.
+func newTestELF(t *testing.T, buildID string) []byte {
+	id, err := hex.DecodeString(buildID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var note bytes.Buffer
+	binary.Write(&note, binary.LittleEndian, []uint32{4, uint32(len(id)), ntGNUBuildID})
+	note.WriteString("GNU\x00")
+	note.Write(id)
+
+	header := elf.Header64{
+		Type:      uint16(elf.ET_EXEC),
+		Machine:   uint16(elf.EM_X86_64),
+		Version:   uint32(elf.EV_CURRENT),
+		Phoff:     elfHeaderSize,
+		Ehsize:    elfHeaderSize,
+		Phentsize: elfProgSize,
+		Phnum:     1,
+		Shentsize: elfSectionSize,
+	}
+	copy(header.Ident[:], elf.ELFMAG)
+	header.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS64)
+	header.Ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	header.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+	prog := elf.Prog64{
+		Type:   uint32(elf.PT_NOTE),
+		Flags:  uint32(elf.PF_R),
+		Off:    elfHeaderSize + elfProgSize,
+		Filesz: uint64(note.Len()),
+		Memsz:  uint64(note.Len()),
+		Align:  4,
+	}
+
+	var file bytes.Buffer
+	binary.Write(&file, binary.LittleEndian, header)
+	binary.Write(&file, binary.LittleEndian, prog)
+	file.Write(note.Bytes())
+
+	return file.Bytes()
+}
+
+// newTestServer returns a server with the debug file of testBuildID, whose content has the build-id served.
+func newTestServer(t *testing.T, requests *int32, servedBuildID string) *httptest.Server {
+	debuginfo := newTestELF(t, servedBuildID)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(requests, 1)
+		if r.URL.Path != "/buildid/"+testBuildID+"/debuginfo" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(debuginfo)
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestFetchDebugInfo(t *testing.T) {
+	var requests int32
+	server := newTestServer(t, &requests, testBuildID)
+	client := NewClient(WithURLs([]string{server.URL}), WithCacheDir(t.TempDir()))
+
+	path, err := client.FetchDebugInfo(context.Background(), testBuildID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, newTestELF(t, testBuildID), data)
+
+	// The second fetch is served from the cache.
+	cached, err := client.FetchDebugInfo(context.Background(), testBuildID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, path, cached)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+}
+
+func TestFetchDebugInfoNotFound(t *testing.T) {
+	var requests int32
+	server := newTestServer(t, &requests, testBuildID)
+	client := NewClient(WithURLs([]string{server.URL}), WithCacheDir(t.TempDir()))
+
+	_, err := client.FetchDebugInfo(context.Background(), testOtherBuildID)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// Servers that respond are queried again.
+	_, err = client.FetchDebugInfo(context.Background(), testBuildID)
+	assert.NoError(t, err)
+}
+
+func TestFetchDebugInfoUnreachable(t *testing.T) {
+	var requests int32
+	unreachable := newTestServer(t, &requests, testBuildID)
+	unreachable.Close()
+	server := newTestServer(t, &requests, testBuildID)
+	client := NewClient(
+		WithURLs([]string{unreachable.URL, server.URL}),
+		WithCacheDir(t.TempDir()),
+		WithTimeout(time.Second),
+	)
+
+	// The next server is queried when one is unreachable.
+	_, err := client.FetchDebugInfo(context.Background(), testBuildID)
+	assert.NoError(t, err)
+}
+
+func TestFetchDebugInfoBuildIDMismatch(t *testing.T) {
+	var requests int32
+	mismatch := newTestServer(t, &requests, testOtherBuildID)
+	cacheDir := t.TempDir()
+	client := NewClient(WithURLs([]string{mismatch.URL}), WithCacheDir(cacheDir))
+
+	_, err := client.FetchDebugInfo(context.Background(), testBuildID)
+	assert.ErrorIs(t, err, ErrNotFound)
+	entries, err := os.ReadDir(filepath.Join(cacheDir, testBuildID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Empty(t, entries)
+
+	// The next server is queried when one has a different file.
+	server := newTestServer(t, &requests, testBuildID)
+	client = NewClient(WithURLs([]string{mismatch.URL, server.URL}), WithCacheDir(cacheDir))
+	_, err = client.FetchDebugInfo(context.Background(), testBuildID)
+	assert.NoError(t, err)
+}
+
+func TestFetchDebugInfoServerError(t *testing.T) {
+	var requests int32
+	debuginfo := newTestELF(t, testBuildID)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write(debuginfo)
+	}))
+	t.Cleanup(server.Close)
+	client := NewClient(WithURLs([]string{server.URL}), WithCacheDir(t.TempDir()))
+
+	_, err := client.FetchDebugInfo(context.Background(), testBuildID)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// Servers that respond with an error are queried again.
+	_, err = client.FetchDebugInfo(context.Background(), testBuildID)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&requests))
+}
+
+func TestFetchDebugInfoBadBuildID(t *testing.T) {
+	client := NewClient(WithURLs([]string{"http://localhost"}), WithCacheDir(t.TempDir()))
+	_, err := client.FetchDebugInfo(context.Background(), "../../etc/passwd")
+	assert.ErrorIs(t, err, ErrBadBuildID)
+}
+
+func TestFetchDebugInfoNoServers(t *testing.T) {
+	client := NewClient(WithURLs(nil), WithCacheDir(t.TempDir()))
+	assert.False(t, client.Enabled())
+	_, err := client.FetchDebugInfo(context.Background(), testBuildID)
+	assert.ErrorIs(t, err, ErrNoServers)
+}
@@ -0,0 +1,150 @@
+package normalize
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Action is what a rule does to the symbol names it matches.
+type Action string
+
+const (
+	// ActionRewrite replaces the matches of the pattern in the name with the replacement.
+	ActionRewrite Action = "rewrite"
+
+	// ActionMerge replaces the whole name with the replacement when the pattern matches,
+	// so that all the matching names are merged into one.
+	ActionMerge Action = "merge"
+)
+
+// ruleSeparator separates the pattern and the replacement in the rule specs.
+const ruleSeparator = "=>"
+
+var (
+	ErrInvalidRule = errors.New("invalid normalization rule")
+)
+
+// Rule is a regular expression rule that normalizes the symbol names.
+// The replacement can refer to the submatches of the pattern, like $1.
+type Rule struct {
+	Action      Action
+	Pattern     *regexp.Regexp
+	Replacement string
+}
+
+// NewRule returns the rule of the action that replaces the pattern with the replacement.
+func NewRule(action Action, pattern string, replacement string) (Rule, error) {
+	if action != ActionRewrite && action != ActionMerge {
+		return Rule{}, errors.Wrapf(ErrInvalidRule, "unknown action %q", action)
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return Rule{}, errors.Wrap(ErrInvalidRule, err.Error())
+	}
+
+	return Rule{Action: action, Pattern: re, Replacement: replacement}, nil
+}
+
+// ParseRule parses a rule in the ACTION:PATTERN=>REPLACEMENT form,
+// like merge:^__(mem\w+)_avx\w*$=>$1.
+func ParseRule(spec string) (Rule, error) {
+	action, rest, ok := strings.Cut(spec, ":")
+	if !ok {
+		return Rule{}, errors.Wrapf(ErrInvalidRule, "missing action in %q", spec)
+	}
+	pattern, replacement, ok := strings.Cut(rest, ruleSeparator)
+	if !ok {
+		return Rule{}, errors.Wrapf(ErrInvalidRule, "missing %s in %q", ruleSeparator, spec)
+	}
+
+	return NewRule(Action(action), pattern, replacement)
+}
+
+// Apply returns the name normalized by the rule.
+func (r Rule) Apply(name string) string {
+	switch r.Action {
+	case ActionRewrite:
+		return r.Pattern.ReplaceAllString(name, r.Replacement)
+	case ActionMerge:
+		match := r.Pattern.FindStringSubmatchIndex(name)
+		if match == nil {
+			return name
+		}
+		return string(r.Pattern.ExpandString(nil, r.Replacement, name, match))
+	default:
+		return name
+	}
+}
+
+// Rules is a list of rules, applied in order, each one to the result of the previous.
+type Rules []Rule
+
+// ParseRules parses the rules in the ACTION:PATTERN=>REPLACEMENT form.
+func ParseRules(specs []string) (Rules, error) {
+	rules := make(Rules, 0, len(specs))
+	for _, spec := range specs {
+		rule, err := ParseRule(spec)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+// FromFlags returns the rules parsed from the specs, after the built-in ones
+// unless noDefault, as configured with the command line flags.
+func FromFlags(noDefault bool, specs []string) (Rules, error) {
+	rules, err := ParseRules(specs)
+	if err != nil {
+		return nil, err
+	}
+	if !noDefault {
+		rules = append(DefaultRules(), rules...)
+	}
+
+	return rules, nil
+}
+
+// Apply returns the name normalized by the rules.
+func (r Rules) Apply(name string) string {
+	for _, rule := range r {
+		name = rule.Apply(name)
+	}
+
+	return name
+}
+
+// DefaultRules returns the built-in rules, that merge the symbols that are
+// logically the same function for Go, Rust, C++ and the C library.
+func DefaultRules() Rules {
+	return Rules{
+		// Go closures and go and defer statement wrappers, like main.foo.func1.2.
+		mustRule(ActionRewrite, `(\.(func|gowrap|deferwrap)\d+(\.\d+)*)+$`, ""),
+		// Go generic shape instantiations, like main.Map[go.shape.int,go.shape.string].
+		mustRule(ActionRewrite, `\[go\.shape\.(?:[^\[\]]|\[[^\[\]]*\])*\]`, "[...]"),
+		// Legacy Rust hashes, like core::fmt::write::h0f8e9a9c3f2d7b1a.
+		mustRule(ActionRewrite, `::h[0-9a-f]{16}$`, ""),
+		// Compiler clones of C and C++ functions, like foo.cold or foo.isra.0,
+		// also as demangled, like foo() [clone .cold].
+		mustRule(ActionRewrite, `(\.(cold|part|isra|constprop|lto_priv|llvm|localalias)(\.\d+)?)+$`, ""),
+		mustRule(ActionRewrite, `( \[clone [^\]]+\])+$`, ""),
+		// Symbol versions, like memcpy@@GLIBC_2.14.
+		mustRule(ActionRewrite, `@@?[\w.]+$`, ""),
+		// CPU specific implementations of the C library string functions,
+		// like __memmove_avx_unaligned_erms.
+		mustRule(ActionMerge, `^__((?:mem|str|stp|wmem|wcs)[a-z]*?)_(?:avx|avx2|avx512|evex|evex512|sse2|sse4|ssse3|erms|unaligned)(?:_\w+)?$`, "$1"),
+	}
+}
+
+func mustRule(action Action, pattern string, replacement string) Rule {
+	rule, err := NewRule(action, pattern, replacement)
+	if err != nil {
+		panic(err)
+	}
+
+	return rule
+}
@@ -0,0 +1,71 @@
+package normalize_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/maxgio92/yap/pkg/normalize"
+)
+
+func TestDefaultRules(t *testing.T) {
+	tests := map[string]string{
+		"main.foo.func1":                               "main.foo",
+		"main.foo.func1.2":                             "main.foo",
+		"main.(*Server).Serve.gowrap1":                 "main.(*Server).Serve",
+		"main.Map[go.shape.int,go.shape.string]":       "main.Map[...]",
+		"main.Sum[go.shape.[]int].func2":               "main.Sum[...]",
+		"core::fmt::write::h0f8e9a9c3f2d7b1a":          "core::fmt::write",
+		"do_work.cold":                                 "do_work",
+		"do_work.isra.0":                               "do_work",
+		"void foo::bar<int>(int) [clone .cold]":        "void foo::bar<int>(int)",
+		"memcpy@@GLIBC_2.14":                           "memcpy",
+		"__memmove_avx_unaligned_erms":                 "memmove",
+		"__strlen_avx2":                                "strlen",
+		"__memcpy_chk":                                 "__memcpy_chk",
+		"runtime.mallocgc":                             "runtime.mallocgc",
+		"std::vector<int, std::allocator<int> >::size": "std::vector<int, std::allocator<int> >::size",
+	}
+	rules := DefaultRules()
+	for name, expected := range tests {
+		assert.Equal(t, expected, rules.Apply(name), name)
+	}
+}
+
+func TestParseRules(t *testing.T) {
+	rules, err := ParseRules([]string{
+		`rewrite:^myapp/internal/=>`,
+		`merge:^handle(Get|Post)\w*$=>handle$1`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "server.Run", rules.Apply("myapp/internal/server.Run"))
+	assert.Equal(t, "handleGet", rules.Apply("handleGetUser"))
+	assert.Equal(t, "handle", rules.Apply("handle"))
+
+	for _, spec := range []string{`^foo=>bar`, `drop:foo=>bar`, `merge:foo`, `merge:(=>bar`} {
+		_, err = ParseRule(spec)
+		assert.ErrorIs(t, err, ErrInvalidRule, spec)
+	}
+}
+
+func TestFromFlags(t *testing.T) {
+	specs := []string{`rewrite:^myapp/internal/=>`}
+
+	rules, err := FromFlags(false, specs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, rules, len(DefaultRules())+1)
+	assert.Equal(t, "server.Run", rules.Apply("myapp/internal/server.Run.func1"))
+
+	rules, err = FromFlags(true, specs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "server.Run.func1", rules.Apply("myapp/internal/server.Run.func1"))
+
+	_, err = FromFlags(true, []string{`merge:foo`})
+	assert.ErrorIs(t, err, ErrInvalidRule)
+}
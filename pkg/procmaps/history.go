@@ -0,0 +1,135 @@
+package procmaps
+
+import (
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// Version is a memory mapping with the time range it's been valid in,
+// as CLOCK_MONOTONIC nanoseconds, the clock of the BPF ktime helpers.
+// Until is zero while the mapping is still valid.
+type Version struct {
+	Mapping
+	From  uint64
+	Until uint64
+}
+
+// ValidAt returns whether the mapping was valid at the time.
+func (v *Version) ValidAt(t uint64) bool {
+	return t >= v.From && (v.Until == 0 || t < v.Until)
+}
+
+// History is a time-versioned table of the memory mappings of a process,
+// that tracks the mappings created and removed over time,
+// like by the dynamic loader or by JIT compilers.
+type History struct {
+	versions []*Version
+	lock     sync.RWMutex
+}
+
+func NewHistory() *History {
+	return new(History)
+}
+
+// MonotonicNow returns the current CLOCK_MONOTONIC time in nanoseconds.
+func MonotonicNow() uint64 {
+	var ts unix.Timespec
+	if err := unix.ClockGettime(unix.CLOCK_MONOTONIC, &ts); err != nil {
+		return 0
+	}
+
+	return uint64(ts.Nano())
+}
+
+// Update records the memory mappings read at the time: the ones not valid anymore
+// end at that time, and the new ones start from it. The mappings of the first
+// update are considered valid since ever.
+func (h *History) Update(maps Maps, t uint64) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	from := t
+	if len(h.versions) == 0 {
+		from = 0
+	}
+
+	current := make(map[Mapping]struct{}, len(maps))
+	for _, m := range maps {
+		current[m] = struct{}{}
+	}
+	valid := make(map[Mapping]struct{}, len(maps))
+	for _, v := range h.versions {
+		if v.Until != 0 {
+			continue
+		}
+		if _, ok := current[v.Mapping]; ok {
+			valid[v.Mapping] = struct{}{}
+			continue
+		}
+		v.Until = t
+	}
+	for _, m := range maps {
+		if _, ok := valid[m]; !ok {
+			h.versions = append(h.versions, &Version{Mapping: m, From: from})
+		}
+	}
+}
+
+// Add adds a version of a mapping, like one recorded by another History.
+func (h *History) Add(v Version) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.versions = append(h.versions, &v)
+}
+
+// Find returns the version of the mapping that contains the address at the time.
+// As mappings are tracked periodically, when none was valid at the time the
+// earliest one created after it is returned, otherwise the latest one removed before it.
+func (h *History) Find(addr uint64, t uint64) (*Version, error) {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	var next, prev *Version
+	for _, v := range h.versions {
+		if !v.Contains(addr) {
+			continue
+		}
+		switch {
+		case v.ValidAt(t):
+			res := *v
+			return &res, nil
+		case v.From > t:
+			if next == nil || v.From < next.From {
+				next = v
+			}
+		default:
+			if prev == nil || v.Until > prev.Until {
+				prev = v
+			}
+		}
+	}
+	if next == nil {
+		next = prev
+	}
+	if next == nil {
+		return nil, ErrMappingNotFound
+	}
+	res := *next
+
+	return &res, nil
+}
+
+// Versions returns all the versions of the mappings, in order of creation.
+func (h *History) Versions() []Version {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	versions := make([]Version, 0, len(h.versions))
+	for _, v := range h.versions {
+		versions = append(versions, *v)
+	}
+
+	return versions
+}
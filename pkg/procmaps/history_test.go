@@ -0,0 +1,52 @@
+package procmaps_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/maxgio92/yap/pkg/procmaps"
+)
+
+func TestHistory(t *testing.T) {
+	exe := Mapping{Start: 0x1000, End: 0x2000, Perms: "r-xp", Pathname: "/usr/bin/app"}
+	plugin := Mapping{Start: 0x7000, End: 0x8000, Perms: "r-xp", Pathname: "/usr/lib/plugin.so"}
+	jit := Mapping{Start: 0x7000, End: 0x9000, Perms: "rwxp"}
+
+	h := NewHistory()
+	h.Update(Maps{exe}, 100)
+	h.Update(Maps{exe, plugin}, 200)
+	h.Update(Maps{exe, jit}, 300)
+
+	// The mappings of the first update are valid since ever.
+	v, err := h.Find(0x1100, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, exe, v.Mapping)
+
+	// Addresses are resolved against the mappings valid at the time.
+	v, err = h.Find(0x7100, 250)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, plugin, v.Mapping)
+	assert.Equal(t, uint64(200), v.From)
+	assert.Equal(t, uint64(300), v.Until)
+	v, err = h.Find(0x7100, 350)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, jit, v.Mapping)
+
+	// Before a mapping has been tracked, the earliest one created later is used.
+	v, err = h.Find(0x7100, 150)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, plugin, v.Mapping)
+
+	_, err = h.Find(0x5000, 250)
+	assert.ErrorIs(t, err, ErrMappingNotFound)
+	assert.Len(t, h.Versions(), 3)
+}
@@ -0,0 +1,244 @@
+package procmaps
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// deletedSuffix is the suffix of the pathname of files
+	// deleted or replaced after having been mapped.
+	deletedSuffix = " (deleted)"
+
+	// VDSOPathname is the pathname of the mapping of the vDSO.
+	VDSOPathname = "[vdso]"
+)
+
+var (
+	ErrMappingNotFound = errors.New("mapping not found")
+	ErrMalformedLine   = errors.New("malformed maps line")
+	ErrMalformedDev    = errors.New("malformed device numbers")
+)
+
+// Mapping is a memory mapping of a process address space,
+// as reported by the proc filesystem maps file.
+type Mapping struct {
+	Start    uint64
+	End      uint64
+	Perms    string
+	Offset   uint64
+	Dev      string
+	Inode    uint64
+	Pathname string
+}
+
+// IsExecutable returns whether the mapping is executable.
+func (m *Mapping) IsExecutable() bool {
+	return strings.Contains(m.Perms, "x")
+}
+
+// IsFileBacked returns whether the mapping is backed by a file
+// on a filesystem, as opposed to anonymous or pseudo mappings
+// like [heap], [stack] or [vdso].
+func (m *Mapping) IsFileBacked() bool {
+	return strings.HasPrefix(m.Pathname, "/")
+}
+
+// IsAnonymous returns whether the mapping is not backed by a file
+// on a filesystem, like the memory JIT compilers generate code into.
+// Memory file descriptors and named anonymous mappings are anonymous.
+func (m *Mapping) IsAnonymous() bool {
+	return m.Pathname == "" ||
+		strings.HasPrefix(m.Pathname, "[anon:") ||
+		strings.HasPrefix(m.Pathname, "/memfd:") ||
+		strings.HasPrefix(m.Pathname, "//anon")
+}
+
+// IsVDSO returns whether the mapping is the virtual dynamic shared object,
+// the ELF image the kernel maps into the processes to run system calls
+// like clock_gettime and gettimeofday in user space.
+func (m *Mapping) IsVDSO() bool {
+	return m.Pathname == VDSOPathname
+}
+
+// IsDeleted returns whether the backing file has been deleted or replaced
+// on the filesystem after having been mapped.
+func (m *Mapping) IsDeleted() bool {
+	return strings.HasSuffix(m.Pathname, deletedSuffix)
+}
+
+// FilePath returns the path of the backing file, without the suffix of deleted files.
+func (m *Mapping) FilePath() string {
+	return TrimDeleted(m.Pathname)
+}
+
+// TrimDeleted returns the pathname without the suffix the proc filesystem
+// appends to the paths of deleted files.
+func TrimDeleted(pathname string) string {
+	return strings.TrimSuffix(pathname, deletedSuffix)
+}
+
+// Contains returns whether the address falls in the mapping.
+func (m *Mapping) Contains(addr uint64) bool {
+	return addr >= m.Start && addr < m.End
+}
+
+// FileOffset returns the offset in the backing file
+// of an address that falls in the mapping.
+func (m *Mapping) FileOffset(addr uint64) uint64 {
+	return addr - m.Start + m.Offset
+}
+
+// DevNumbers returns the major and minor numbers of the device of the backing file.
+func (m *Mapping) DevNumbers() (uint32, uint32, error) {
+	major, minor, ok := strings.Cut(m.Dev, ":")
+	if !ok {
+		return 0, 0, ErrMalformedDev
+	}
+	maj, err := strconv.ParseUint(major, 16, 32)
+	if err != nil {
+		return 0, 0, ErrMalformedDev
+	}
+	min, err := strconv.ParseUint(minor, 16, 32)
+	if err != nil {
+		return 0, 0, ErrMalformedDev
+	}
+
+	return uint32(maj), uint32(min), nil
+}
+
+// MapFilesPath returns the path of the file backing the mapping in the map_files
+// directory of the process with the specified ID, which refers to the mapped file
+// regardless of the mount namespace. Opening it requires CAP_SYS_ADMIN.
+func (m *Mapping) MapFilesPath(pid int) string {
+	return fmt.Sprintf("/proc/%d/map_files/%x-%x", pid, m.Start, m.End)
+}
+
+// RootPath returns the path of a file of the mount namespace of the process with
+// the specified ID, through the process root directory, like for containers.
+func RootPath(pid int, pathname string) string {
+	return fmt.Sprintf("/proc/%d/root%s", pid, pathname)
+}
+
+// ExePath returns the path of the executable link of the process with the specified ID,
+// which refers to the executable even if it has been deleted or replaced.
+func ExePath(pid int) string {
+	return fmt.Sprintf("/proc/%d/exe", pid)
+}
+
+// Maps is the list of memory mappings of a process, sorted by start address.
+type Maps []Mapping
+
+// Find returns the mapping that contains the address.
+func (m Maps) Find(addr uint64) (*Mapping, error) {
+	i := sort.Search(len(m), func(i int) bool {
+		return m[i].End > addr
+	})
+	if i < len(m) && m[i].Contains(addr) {
+		return &m[i], nil
+	}
+
+	return nil, ErrMappingNotFound
+}
+
+// Read reads the memory mappings of the process with the specified ID.
+func Read(pid int) (Maps, error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/maps", pid))
+	if err != nil {
+		return nil, errors.Wrap(err, "error opening maps file")
+	}
+	defer f.Close()
+
+	return Parse(f)
+}
+
+// Parse parses memory mappings in the format of the proc filesystem maps file.
+func Parse(r io.Reader) (Maps, error) {
+	maps := make(Maps, 0)
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		m, err := parseLine(line)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("error parsing line %q", line))
+		}
+		maps = append(maps, *m)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "error reading maps")
+	}
+
+	sort.Slice(maps, func(i, j int) bool {
+		return maps[i].Start < maps[j].Start
+	})
+
+	return maps, nil
+}
+
+// parseLine parses a single line of the maps file, like:
+// 7f3c1a200000-7f3c1a228000 r-xp 00028000 fd:01 1835023    /usr/lib/libc.so.6
+// pathnameField returns the rest of the line after the first five fields, the pathname.
+func pathnameField(line string) string {
+	rest := line
+	for i := 0; i < 5; i++ {
+		rest = strings.TrimLeft(rest, " \t")
+		rest = rest[strings.IndexAny(rest, " \t")+1:]
+	}
+
+	return strings.TrimLeft(rest, " \t")
+}
+
+func parseLine(line string) (*Mapping, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 5 {
+		return nil, ErrMalformedLine
+	}
+
+	addrs := strings.SplitN(fields[0], "-", 2)
+	if len(addrs) != 2 {
+		return nil, ErrMalformedLine
+	}
+	start, err := strconv.ParseUint(addrs[0], 16, 64)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing start address")
+	}
+	end, err := strconv.ParseUint(addrs[1], 16, 64)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing end address")
+	}
+	offset, err := strconv.ParseUint(fields[2], 16, 64)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing offset")
+	}
+	inode, err := strconv.ParseUint(fields[4], 10, 64)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing inode")
+	}
+
+	m := &Mapping{
+		Start:  start,
+		End:    end,
+		Perms:  fields[1],
+		Offset: offset,
+		Dev:    fields[3],
+		Inode:  inode,
+	}
+	// The pathname may contain spaces, even repeated ones, e.g. for the " (deleted)"
+	// suffix: it's the rest of the line, after the padding of the inode.
+	if len(fields) > 5 {
+		m.Pathname = pathnameField(line)
+	}
+
+	return m, nil
+}
@@ -0,0 +1,113 @@
+package procmaps_test
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/maxgio92/yap/pkg/procmaps"
+)
+
+const testMaps = `55d0c0a00000-55d0c0a28000 r--p 00000000 fd:01 1835010                    /usr/bin/myprogram
+55d0c0a28000-55d0c0b00000 r-xp 00028000 fd:01 1835010                    /usr/bin/myprogram
+55d0c1e3c000-55d0c1e5d000 rw-p 00000000 00:00 0                          [heap]
+7f3c1a200000-7f3c1a228000 r--p 00000000 fd:01 1835023                    /usr/lib/libc.so.6
+7f3c1a228000-7f3c1a3bd000 r-xp 00028000 fd:01 1835023                    /usr/lib/libc.so.6
+7f3c1a400000-7f3c1a401000 r-xp 00000000 fd:01 1835099                    /opt/my app/lib.so (deleted)
+7f3c1a500000-7f3c1a501000 r-xp 00000000 fd:01 1835100                    /opt/my  app/lib.so
+7ffd5a9f2000-7ffd5a9f4000 r-xp 00000000 00:00 0                          [vdso]
+`
+
+func TestParse(t *testing.T) {
+	maps, err := Parse(strings.NewReader(testMaps))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Len(t, maps, 8)
+	assert.Equal(t, uint64(0x55d0c0a28000), maps[1].Start)
+	assert.Equal(t, uint64(0x55d0c0b00000), maps[1].End)
+	assert.Equal(t, uint64(0x28000), maps[1].Offset)
+	assert.Equal(t, uint64(1835010), maps[1].Inode)
+	assert.Equal(t, "/usr/bin/myprogram", maps[1].Pathname)
+	assert.True(t, maps[1].IsExecutable())
+	assert.True(t, maps[1].IsFileBacked())
+	assert.False(t, maps[2].IsExecutable())
+	assert.False(t, maps[2].IsFileBacked())
+	assert.Equal(t, "/opt/my app/lib.so (deleted)", maps[5].Pathname)
+	assert.True(t, maps[5].IsDeleted())
+	assert.Equal(t, "/opt/my app/lib.so", maps[5].FilePath())
+	assert.False(t, maps[4].IsDeleted())
+	assert.Equal(t, "/usr/lib/libc.so.6", maps[4].FilePath())
+	assert.Equal(t, "/opt/my  app/lib.so", maps[6].Pathname)
+	assert.False(t, maps[7].IsFileBacked())
+	assert.False(t, maps[7].IsAnonymous())
+	assert.True(t, maps[7].IsVDSO())
+	assert.False(t, maps[4].IsVDSO())
+}
+
+func TestIsAnonymous(t *testing.T) {
+	for pathname, anonymous := range map[string]bool{
+		"":                     true,
+		"[anon:v8 code]":       true,
+		"/memfd:jit (deleted)": true,
+		"//anon":               true,
+		"/usr/lib/libc.so.6":   false,
+		"[heap]":               false,
+		"/tmp/jit-1234.dump":   false,
+	} {
+		m := Mapping{Pathname: pathname}
+		assert.Equal(t, anonymous, m.IsAnonymous(), pathname)
+	}
+}
+
+func TestDevNumbers(t *testing.T) {
+	maps, err := Parse(strings.NewReader(testMaps))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	major, minor, err := maps[1].DevNumbers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, uint32(0xfd), major)
+	assert.Equal(t, uint32(0x01), minor)
+	assert.Equal(t, "/proc/42/map_files/55d0c0a28000-55d0c0b00000", maps[1].MapFilesPath(42))
+	assert.Equal(t, "/proc/42/root/usr/bin/myprogram", RootPath(42, maps[1].Pathname))
+
+	_, _, err = (&Mapping{Dev: "fd01"}).DevNumbers()
+	assert.ErrorIs(t, err, ErrMalformedDev)
+}
+
+func TestParseMalformed(t *testing.T) {
+	_, err := Parse(strings.NewReader("55d0c0a00000 r--p 00000000\n"))
+	assert.Error(t, err)
+}
+
+func TestFind(t *testing.T) {
+	maps, err := Parse(strings.NewReader(testMaps))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := maps.Find(0x7f3c1a228010)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "/usr/lib/libc.so.6", m.Pathname)
+	assert.Equal(t, uint64(0x28010), m.FileOffset(0x7f3c1a228010))
+
+	_, err = maps.Find(0x1000)
+	assert.ErrorIs(t, err, ErrMappingNotFound)
+}
+
+func TestRead(t *testing.T) {
+	maps, err := Read(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NotEmpty(t, maps)
+}
@@ -0,0 +1,38 @@
+package profile
+
+import (
+	"context"
+)
+
+// fetchDebugInfo fetches from debuginfod the debug files of the objects with
+// frames that could not be resolved, and adds them to the process symbol table.
+// Symbolization degrades to the local information when debug files cannot be fetched.
+func (p *Profiler) fetchDebugInfo(s *symbolization, samples []sample) {
+	// Resolve the user stacks to find out the objects with unresolved frames.
+	for _, smpl := range samples {
+		if smpl.userStack != nil {
+			p.getHumanReadableStackTrace(s, smpl.userStack, s.pid, smpl.time)
+		}
+	}
+
+	for _, m := range p.symTabProc.Unresolved() {
+		buildID, err := p.symTabProc.BuildID(&m)
+		if err != nil {
+			p.logger.Debug().Err(err).Str("path", m.Pathname).Msg("error reading build-id")
+			continue
+		}
+
+		// The profile context is done at this point: requests are bound by the client timeout.
+		debugPath, err := p.debuginfod.FetchDebugInfo(context.Background(), buildID)
+		if err != nil {
+			p.logger.Debug().Err(err).Str("path", m.Pathname).Str("build_id", buildID).Msg("error fetching debuginfo")
+			continue
+		}
+
+		if err = p.symTabProc.AddDebugFile(&m, debugPath); err != nil {
+			p.logger.Debug().Err(err).Str("path", m.Pathname).Str("debug_path", debugPath).Msg("error loading debuginfo")
+			continue
+		}
+		p.logger.Debug().Str("path", m.Pathname).Str("build_id", buildID).Msg("debuginfo fetched")
+	}
+}
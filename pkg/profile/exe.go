@@ -1,43 +1,75 @@
 package profile
 
 import (
-	"fmt"
 	"os"
 	"unsafe"
 
 	bpf "github.com/aquasecurity/libbpfgo"
 	"github.com/pkg/errors"
+
+	"github.com/maxgio92/yap/pkg/procmaps"
+)
+
+var (
+	ErrExeNotFound = errors.New("executable not found")
 )
 
 // getExePath returns the path of the executable for the specified process ID.
 // It checks the validity of the path shared in the binprm BPF hash map.
 // It falls back to the proc filesystem.
+// As the paths are relative to the mount namespace of the process, like for
+// containers, they're opened through the root directory of the process.
 func (p *Profiler) getExePath(binprmInfoMap *bpf.BPFMap, pid int32) (*string, error) {
-	var path string
 	v, err := binprmInfoMap.GetValue(unsafe.Pointer(&pid))
 	if err != nil {
 		p.logger.Debug().Err(err).Msg("error getting exe_path from binprm_info BPF map")
 	} else {
 		v = v[:clen(v)]
-		path = string(v)
-
-		_, err = os.Stat(path)
+		path, err := resolveExePath(int(pid), string(v))
 		if err == nil {
 			p.logger.Debug().Str("path", path).Int("pid", p.pid).Msg("exe_path found from binprm_info BPF map")
 			return &path, nil
 		}
-		p.logger.Debug().Err(err).Str("path", path).Msg("exe_path got from binprm_info BPF map not found")
+		p.logger.Debug().Err(err).Str("path", string(v)).Msg("exe_path got from binprm_info BPF map not found")
 	}
 
 	// Fallback to procfs.
-	path, err = os.Readlink(fmt.Sprintf("/proc/%d/exe", pid))
+	link, err := os.Readlink(procmaps.ExePath(int(pid)))
 	if err != nil {
 		return nil, errors.Wrap(err, "error getting exe_path from procfs")
 	}
-	if _, err = os.Stat(path); err != nil {
+	path, err := resolveExePath(int(pid), link)
+	if err != nil {
 		return nil, errors.Wrap(err, "error getting exe_path from procfs")
 	}
 	p.logger.Debug().Str("path", path).Int("pid", p.pid).Msg("exe_path found from procfs")
 
 	return &path, nil
 }
+
+// resolveExePath returns the path the executable at pathname, in the mount namespace
+// of the process, can be opened at. The path is looked up through the root directory
+// of the process first, and verified to be the executable of the process while it's alive.
+// The executable link of the process is used as the last resort, as it always
+// refers to the executable, even if replaced or deleted meanwhile.
+func resolveExePath(pid int, pathname string) (string, error) {
+	exeLink := procmaps.ExePath(pid)
+	exe, exeErr := os.Stat(exeLink)
+
+	// The link of a deleted executable has the same suffix as its mappings.
+	pathname = procmaps.TrimDeleted(pathname)
+	for _, candidate := range []string{procmaps.RootPath(pid, pathname), pathname} {
+		info, err := os.Stat(candidate)
+		if err != nil {
+			continue
+		}
+		if exeErr != nil || os.SameFile(info, exe) {
+			return candidate, nil
+		}
+	}
+	if exeErr == nil {
+		return exeLink, nil
+	}
+
+	return "", ErrExeNotFound
+}
@@ -0,0 +1,76 @@
+package profile
+
+import (
+	"fmt"
+	"os"
+	"unsafe"
+
+	bpf "github.com/aquasecurity/libbpfgo"
+	"github.com/pkg/errors"
+)
+
+const (
+	// mapFilterConfig is the configuration of the filters of the tasks sampled by the probe.
+	mapFilterConfig = "filter_config"
+
+	// mapFilterTGIDs are the IDs of the processes to sample.
+	mapFilterTGIDs = "filter_tgids"
+
+	// mapFilterPIDs are the IDs of the threads to sample.
+	mapFilterPIDs = "filter_pids"
+
+	// Flags of the filter_config_t struct of the BPF probe.
+	filterFlagTGID = 1 << 0
+	filterFlagPID  = 1 << 1
+)
+
+var (
+	ErrThreadNotFound = errors.New("thread not found in the process")
+)
+
+// setFilter populates the filter maps of the probe, so that only the profiled
+// process, or only the threads of it if set, are sampled.
+func (p *Profiler) setFilter(bpfModule *bpf.Module) error {
+	var flags uint32
+	if len(p.tids) == 0 {
+		flags |= filterFlagTGID
+		if err := addFilterID(bpfModule, mapFilterTGIDs, p.pid); err != nil {
+			return err
+		}
+	}
+	for _, tid := range p.tids {
+		if _, err := os.Stat(fmt.Sprintf("/proc/%d/task/%d", p.pid, tid)); err != nil {
+			return errors.Wrapf(ErrThreadNotFound, "%d", tid)
+		}
+		flags |= filterFlagPID
+		if err := addFilterID(bpfModule, mapFilterPIDs, tid); err != nil {
+			return err
+		}
+	}
+
+	config, err := bpfModule.GetMap(mapFilterConfig)
+	if err != nil {
+		return errors.Wrapf(err, "error getting %s BPF map", mapFilterConfig)
+	}
+	key := uint32(0)
+	if err = config.Update(unsafe.Pointer(&key), unsafe.Pointer(&flags)); err != nil {
+		return errors.Wrapf(err, "error updating %s BPF map", mapFilterConfig)
+	}
+
+	return nil
+}
+
+// addFilterID adds the process or thread ID to the filter map.
+func addFilterID(bpfModule *bpf.Module, name string, id int) error {
+	m, err := bpfModule.GetMap(name)
+	if err != nil {
+		return errors.Wrapf(err, "error getting %s BPF map", name)
+	}
+	key := uint32(id)
+	value := uint8(1)
+	if err = m.Update(unsafe.Pointer(&key), unsafe.Pointer(&value)); err != nil {
+		return errors.Wrapf(err, "error updating %s BPF map", name)
+	}
+
+	return nil
+}
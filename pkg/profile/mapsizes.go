@@ -0,0 +1,130 @@
+package profile
+
+import (
+	"encoding/binary"
+	"time"
+	"unsafe"
+
+	bpf "github.com/aquasecurity/libbpfgo"
+)
+
+const (
+	// DefaultMapSize is the default max entries of the BPF maps of the samples.
+	DefaultMapSize = 65536
+
+	// MaxMapSize is the max entries the BPF maps are grown up to when near full.
+	MaxMapSize = 1 << 20
+
+	// mapBinprmInfo are the executable paths of the sampled processes, by pid.
+	mapBinprmInfo = "binprm_info"
+
+	// mapSeenPIDs are the processes whose executable paths have been recorded.
+	mapSeenPIDs = "seen_pids"
+
+	// mapMappingGenerations are the generations of the memory mappings of the sampled processes, by pid.
+	mapMappingGenerations = "mapping_generations"
+
+	// mapStackTraceIDs are the IDs of the stacks counted in the stack traces map.
+	mapStackTraceIDs = "stack_trace_ids"
+
+	// mapMapEntries are the counters of the entries inserted by the probe into the
+	// maps that are never deleted from.
+	mapMapEntries = "map_entries"
+
+	// mapsCheckInterval is the interval the BPF maps are checked at to be near full.
+	mapsCheckInterval = 5 * time.Second
+
+	// mapsFullRatio is the ratio of the max entries a map is near full at.
+	mapsFullRatio = 0.9
+)
+
+// mapSizes are the max entries of the BPF maps of the samples.
+type mapSizes struct {
+	// stackTraces is the size of the maps of the kernel and of the unwound user stacks.
+	stackTraces uint32
+	histogram   uint32
+	// binprmInfo is the size of the maps keyed by pid.
+	binprmInfo uint32
+}
+
+// byMap returns the sizes by map name.
+func (s mapSizes) byMap(p *Profiler) map[string]uint32 {
+	return map[string]uint32{
+		p.mapStackTraces:      s.stackTraces,
+		mapDWARFStackTraces:   s.stackTraces,
+		mapStackTraceIDs:      s.stackTraces,
+		p.mapHistogram:        s.histogram,
+		mapBinprmInfo:         s.binprmInfo,
+		mapSampleDrops:        s.binprmInfo,
+		mapSeenPIDs:           s.binprmInfo,
+		mapMappingGenerations: s.binprmInfo,
+	}
+}
+
+// grownMapSizes returns the sizes of the maps with the ones near full, or that
+// dropped samples or stacks as full, doubled up to MaxMapSize, and whether any grew.
+func (p *Profiler) grownMapSizes(bpfModule *bpf.Module) (mapSizes, bool) {
+	dropped, err := p.readDrops(bpfModule)
+	if err != nil {
+		dropped = map[string]uint64{}
+	}
+	entries := p.mapEntries(bpfModule)
+
+	sizes := p.mapSizes
+	grown := false
+	grow := func(size *uint32, full bool, names ...string) {
+		for _, name := range names {
+			if full {
+				break
+			}
+			full = nearFull(bpfModule, name, entries)
+		}
+		if !full || *size >= MaxMapSize {
+			return
+		}
+		*size = min(*size*2, MaxMapSize)
+		grown = true
+	}
+	grow(&sizes.stackTraces, dropped[DropReasonStackMapFull] > 0, p.mapStackTraces, mapDWARFStackTraces)
+	grow(&sizes.histogram, dropped[DropReasonHistogramUpdate] > 0, p.mapHistogram)
+	grow(&sizes.binprmInfo, dropped[DropReasonBinprmInfoFull] > 0, mapBinprmInfo)
+
+	return sizes, grown
+}
+
+// mapEntries returns the numbers of the entries inserted by the probe into the maps,
+// by name, from the counters of the maps that are never deleted from.
+// The maps are not iterated, as it takes a syscall per entry.
+func (p *Profiler) mapEntries(bpfModule *bpf.Module) map[string]uint64 {
+	entries := make(map[string]uint64)
+	m, err := bpfModule.GetMap(mapMapEntries)
+	if err != nil {
+		return entries
+	}
+
+	// The maps by their index in the map_entries counters of the BPF probe.
+	for i, name := range []string{p.mapHistogram, mapDWARFStackTraces, mapBinprmInfo, p.mapStackTraces} {
+		key := uint32(i)
+		v, err := m.GetValue(unsafe.Pointer(&key))
+		if err != nil || len(v) < 8 {
+			continue
+		}
+		entries[name] = binary.LittleEndian.Uint64(v)
+	}
+
+	return entries
+}
+
+// nearFull returns whether the entries of the map have reached the ratio of its max entries.
+func nearFull(bpfModule *bpf.Module, name string, entries map[string]uint64) bool {
+	n, ok := entries[name]
+	if !ok {
+		return false
+	}
+	m, err := bpfModule.GetMap(name)
+	if err != nil {
+		return false
+	}
+
+	return float64(n) >= float64(m.MaxEntries())*mapsFullRatio
+}
@@ -1,7 +1,13 @@
 package profile
 
 import (
+	"time"
+
 	log "github.com/rs/zerolog"
+
+	"github.com/maxgio92/yap/pkg/debuginfod"
+	"github.com/maxgio92/yap/pkg/normalize"
+	"github.com/maxgio92/yap/pkg/symtable"
 )
 
 type ProfileOption func(profile *Profiler)
@@ -12,6 +18,13 @@ func WithPID(pid int) ProfileOption {
 	}
 }
 
+// WithTIDs sets the IDs of the threads of the process to sample, instead of all of them.
+func WithTIDs(tids []int) ProfileOption {
+	return func(t *Profiler) {
+		t.tids = tids
+	}
+}
+
 func WithSamplingPeriodMillis(period uint64) ProfileOption {
 	return func(t *Profiler) {
 		t.samplingPeriodMillis = period
@@ -42,6 +55,136 @@ func WithMapHistogram(name string) ProfileOption {
 	}
 }
 
+// WithKallsymsPath sets the path of the kernel symbol table file
+// used to symbolize the kernel stacks. It defaults to /proc/kallsyms.
+func WithKallsymsPath(path string) ProfileOption {
+	return func(t *Profiler) {
+		t.kallsymsPath = path
+	}
+}
+
+// WithSymbolPaths sets the directories where to look up the separate debug files
+// of the profiled objects, in addition to the global debug directory.
+func WithSymbolPaths(paths []string) ProfileOption {
+	return func(t *Profiler) {
+		t.symbolPaths = paths
+	}
+}
+
+// WithDebuginfod sets the debuginfod client used to fetch the debug files
+// of the objects that could not be symbolized with local information.
+func WithDebuginfod(client *debuginfod.Client) ProfileOption {
+	return func(t *Profiler) {
+		t.debuginfod = client
+	}
+}
+
+// WithShowOffsets sets whether to show the frames as the symbol name
+// followed by the offset of the address from the start of the symbol.
+func WithShowOffsets(show bool) ProfileOption {
+	return func(t *Profiler) {
+		t.showOffsets = show
+	}
+}
+
+// WithDemangle sets how the mangled C++ and Rust function names are demangled.
+func WithDemangle(mode symtable.DemangleMode) ProfileOption {
+	return func(t *Profiler) {
+		t.demangle = mode
+	}
+}
+
+// WithNormalizeRules sets the rules that normalize the function names, so that the
+// frames of the same logical function are merged. They replace the default ones.
+func WithNormalizeRules(rules normalize.Rules) ProfileOption {
+	return func(t *Profiler) {
+		t.normalizeRules = rules
+	}
+}
+
+// WithSymCacheSize sets the maximum number of instruction pointers whose
+// frames are cached, shared by the symbol tables of all the objects.
+func WithSymCacheSize(size int) ProfileOption {
+	return func(t *Profiler) {
+		t.symCacheSize = size
+	}
+}
+
+// WithIndexCache sets the persistent cache of the symbol indexes
+// of the profiled objects, shared by the profile runs.
+func WithIndexCache(cache *symtable.IndexCache) ProfileOption {
+	return func(t *Profiler) {
+		t.indexCache = cache
+	}
+}
+
+// WithSymbolizer registers a symbolizer, that is looked up before the built-in
+// ones, like the kernel and ELF symbol tables. Symbolizers are looked up in the
+// order they're registered.
+func WithSymbolizer(symbolizer symtable.Symbolizer) ProfileOption {
+	return func(t *Profiler) {
+		t.symbolizers = append(t.symbolizers, symbolizer)
+	}
+}
+
+// WithMapsPollInterval sets the interval the memory mappings of the profiled
+// process are read at, to symbolize the samples against the mappings valid
+// when taken. Zero disables the polling.
+func WithMapsPollInterval(interval time.Duration) ProfileOption {
+	return func(t *Profiler) {
+		t.mapsPollInterval = interval
+	}
+}
+
+// WithDWARFUnwinding sets whether to unwind the user stacks with the unwind tables
+// compiled from the .eh_frame and .debug_frame sections of the mapped objects,
+// for the code built without frame pointers. It defaults to true.
+func WithDWARFUnwinding(enabled bool) ProfileOption {
+	return func(t *Profiler) {
+		t.dwarfUnwinding = enabled
+	}
+}
+
+// WithStackTracesMapSize sets the max entries of the BPF maps of the stack traces.
+// It defaults to DefaultMapSize.
+func WithStackTracesMapSize(size uint32) ProfileOption {
+	return func(t *Profiler) {
+		t.mapSizes.stackTraces = size
+	}
+}
+
+// WithHistogramMapSize sets the max entries of the BPF map of the sample counts,
+// one per distinct pair of stack traces. It defaults to DefaultMapSize.
+func WithHistogramMapSize(size uint32) ProfileOption {
+	return func(t *Profiler) {
+		t.mapSizes.histogram = size
+	}
+}
+
+// WithBinprmInfoMapSize sets the max entries of the BPF maps of the sampled processes,
+// like of their executable paths. It defaults to DefaultMapSize.
+func WithBinprmInfoMapSize(size uint32) ProfileOption {
+	return func(t *Profiler) {
+		t.mapSizes.binprmInfo = size
+	}
+}
+
+// WithMapAutoResize sets whether to reload the BPF probe with larger maps,
+// up to MaxMapSize, when they're near full while profiling. It defaults to true.
+func WithMapAutoResize(enabled bool) ProfileOption {
+	return func(t *Profiler) {
+		t.mapAutoResize = enabled
+	}
+}
+
+// WithProbeStats sets whether to collect the run-time statistics of the
+// programs of the BPF probe, returned by ProbeStats. It requires CAP_SYS_ADMIN.
+func WithProbeStats(enabled bool) ProfileOption {
+	return func(t *Profiler) {
+		t.probeStatsEnabled = enabled
+	}
+}
+
 func WithLogger(logger log.Logger) ProfileOption {
 	return func(t *Profiler) {
 		t.logger = logger
@@ -7,7 +7,9 @@ import (
 	"encoding/binary"
 	"fmt"
 	"hash/fnv"
+	"sort"
 	"sync"
+	"time"
 	"unsafe"
 
 	bpf "github.com/aquasecurity/libbpfgo"
@@ -15,64 +17,427 @@ import (
 	log "github.com/rs/zerolog"
 
 	"github.com/maxgio92/yap/pkg/dag"
+	"github.com/maxgio92/yap/pkg/debuginfod"
+	"github.com/maxgio92/yap/pkg/normalize"
+	"github.com/maxgio92/yap/pkg/procmaps"
+	"github.com/maxgio92/yap/pkg/symcache"
 	"github.com/maxgio92/yap/pkg/symtable"
 )
 
+// HistogramKey is the key of the histogram BPF map.
+// The field layout must match the histogram_key_t struct of the BPF probe.
 type HistogramKey struct {
 	Pid int32
 
-	// UserStackId, an index into the stack-traces map.
-	UserStackId uint32
-
 	// KernelStackId, an index into the stack-traces map.
 	KernelStackId uint32
+
+	// UserStackId, an index into the stack-traces map, or into the
+	// dwarf_stack_traces map if unwound with the unwind tables.
+	UserStackId uint32
+
+	// Flags about how the stack traces have been collected.
+	Flags uint32
+
+	// MappingGeneration is the generation of the memory mappings of the process,
+	// that changes when it maps executable files, so that the samples of the same
+	// stacks before and after are counted apart.
+	MappingGeneration uint32
+}
+
+// HistogramValue is the value of the histogram BPF map.
+// The field layout must match the histogram_value_t struct of the BPF probe.
+type HistogramValue struct {
+	Count uint64
+
+	// LastSeenNs is the CLOCK_MONOTONIC time of the last sample of the stack traces.
+	LastSeenNs uint64
 }
 
 // StackTrace is an array of instruction pointers (IP).
 // 127 is the size of the profile, as for the default PERF_MAX_STACK_DEPTH.
 type StackTrace [127]uint64
 
+// sample is a sampled pair of kernel and user stack traces, with its count
+// and the CLOCK_MONOTONIC time of the last sample in nanoseconds.
+type sample struct {
+	count       int
+	time        uint64
+	kernelStack *StackTrace
+	userStack   *StackTrace
+}
+
 type Profiler struct {
 	pid                  int
+	tids                 []int
 	samplingPeriodMillis uint64
 	probe                []byte
 	probeName            string
 	mapStackTraces       string
 	mapHistogram         string
 	logger               log.Logger
+	kallsymsPath         string
+	symbolPaths          []string
+	debuginfod           *debuginfod.Client
+	showOffsets          bool
+	demangle             symtable.DemangleMode
+	normalizeRules       normalize.Rules
+	symCacheSize         int
+	mapsPollInterval     time.Duration
+	dwarfUnwinding       bool
+	mapSizes             mapSizes
+	mapAutoResize        bool
+	probeStatsEnabled    bool
+	probeStats           map[string]*ProbeStats
+	unwinder             *unwinder
+	symCache             *symcache.Cache[[]symtable.Frame]
+	indexCache           *symtable.IndexCache
+	symbolizers          []symtable.Symbolizer
+	symTabOpts           []symtable.ELFSymTabOption
 	symTabELF            *symtable.ELFSymTab
+	symTabProc           *symtable.ProcSymTab
+	symTabKernel         *symtable.KallSymTab
+
+	// dropped are the numbers of the samples and stacks dropped by the BPF probe, by reason.
+	dropped map[string]uint64
+}
+
+// symbolization is the context the stack traces of a process are symbolized in.
+type symbolization struct {
+	pid        int
+	symbolizer symtable.Chain
+
+	// findMapping returns the memory mapping of a user address at the time of the last sample of its stack.
+	findMapping func(addr uint64, t uint64) (*procmaps.Mapping, error)
+
+	// dropped are the numbers of the samples and stacks dropped by the BPF probe, by reason.
+	dropped map[string]uint64
+
+	// mismatched returns the objects symbolized with files that could not be
+	// verified to be the profiled ones, by their original paths.
+	mismatched func() map[string]string
+
+	// unavailable returns the objects whose files could not be opened, if known.
+	unavailable func() []string
 }
 
 func NewProfiler(opts ...ProfileOption) *Profiler {
 	profile := new(Profiler)
+	profile.kallsymsPath = symtable.DefaultKallsymsPath
+	profile.symCacheSize = symcache.DefaultSize
+	profile.demangle = symtable.DemangleFull
+	profile.normalizeRules = normalize.DefaultRules()
+	profile.mapsPollInterval = DefaultMapsPollInterval
+	profile.dwarfUnwinding = true
+	profile.mapSizes = mapSizes{DefaultMapSize, DefaultMapSize, DefaultMapSize}
+	profile.mapAutoResize = true
 	for _, f := range opts {
 		f(profile)
 	}
-	profile.symTabELF = symtable.NewELFSymTab()
+	// The frames of all the objects are cached in a single bounded cache.
+	profile.symCache = symcache.NewCache[[]symtable.Frame](symcache.WithSize(profile.symCacheSize))
+	symTabOpts := []symtable.ELFSymTabOption{
+		symtable.WithDebugFinder(symtable.NewDebugFinder(profile.symbolPaths...)),
+		symtable.WithCache(profile.symCache),
+	}
+	if profile.indexCache != nil {
+		symTabOpts = append(symTabOpts, symtable.WithIndexCache(profile.indexCache))
+	}
+	profile.symTabELF = symtable.NewELFSymTab(symTabOpts...)
+	profile.symTabProc = symtable.NewProcSymTab(profile.pid, symTabOpts...)
+	profile.symTabKernel = symtable.NewKallSymTab()
+	profile.symTabOpts = symTabOpts
 
 	return profile
 }
 
+// newSymbolization returns the context to symbolize the stack traces of the profiled process in.
+func (p *Profiler) newSymbolization() *symbolization {
+	// The symbolizers registered by the user come first, to take precedence
+	// over the built-in ones: the kernel symbol table, the process symbol table,
+	// that resolves the ELF objects and the JIT code mapped by the process, and
+	// the executable symbol table, for when the process mappings are not available.
+	symbolizer := append(symtable.Chain{}, p.symbolizers...)
+	symbolizer = append(symbolizer, p.symTabKernel, p.symTabProc, p.symTabELF)
+
+	return &symbolization{
+		pid:         p.pid,
+		symbolizer:  symbolizer,
+		findMapping: p.symTabProc.MappingAt,
+		dropped:     p.dropped,
+		mismatched:  p.symTabProc.Mismatched,
+		unavailable: p.symTabProc.Unavailable,
+	}
+}
+
+// RunProfile samples the stack traces of the process until the context is done,
+// and returns the profile DAG of the symbolized stack traces.
 func (p *Profiler) RunProfile(ctx context.Context) (*dag.DAG, error) {
+	defer p.symTabProc.Close()
+
+	samples, err := p.collect(ctx, p.loadSymbols)
+	if err != nil {
+		return nil, err
+	}
+
+	s := p.newSymbolization()
+	// Try to fetch the debug files of the objects that could not be fully symbolized.
+	if p.debuginfod != nil && p.debuginfod.Enabled() {
+		p.fetchDebugInfo(s, samples)
+	}
+
+	return p.buildProfile(s, samples)
+}
+
+// RunRawProfile samples the stack traces of the process until the context is done,
+// and returns the unsymbolized profile, to be symbolized later with SymbolizeRawProfile.
+// Only the memory mappings of the process are read, without loading any symbol table.
+func (p *Profiler) RunRawProfile(ctx context.Context) (*RawProfile, error) {
+	defer p.symTabProc.Close()
+
+	var exePath string
+	samples, err := p.collect(ctx, func(binprmInfo *bpf.BPFMap) {
+		if err := p.symTabProc.Load(); err == nil {
+			return
+		}
+		// Fallback to the executable only.
+		if path, err := p.getExePath(binprmInfo, int32(p.pid)); err == nil {
+			exePath = *path
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return p.newRawProfile(samples, exePath), nil
+}
+
+// collect samples the stack traces of the process until the context is done,
+// and returns the samples. The load function is run while the samples are read.
+// When the BPF maps are near full, the probe is reloaded with larger ones,
+// and the samples collected so far are kept.
+func (p *Profiler) collect(ctx context.Context, load func(binprmInfo *bpf.BPFMap)) ([]sample, error) {
 	bpf.SetLoggerCbs(bpf.Callbacks{
 		Log: func(level int, msg string) {
 			return
 		},
 	})
 
+	if p.probeStatsEnabled {
+		p.probeStats = make(map[string]*ProbeStats)
+		disableStats, err := enableProbeStats()
+		if err != nil {
+			p.logger.Warn().Err(err).Msg("error enabling the BPF probe statistics")
+		} else {
+			defer disableStats()
+		}
+	}
+
+	bpfModule, err := p.loadProbe(p.mapSizes)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		bpfModule.Close()
+	}()
+
+	stopWatching, err := p.startSampling(bpfModule)
+	if err != nil {
+		return nil, err
+	}
+	stopPolling := p.pollMaps()
+	p.logger.Info().Msg("collecting data")
+
+	samples := make([]sample, 0)
+	p.dropped = make(map[string]uint64)
+
+	var checkMaps <-chan time.Time
+	if p.mapAutoResize {
+		ticker := time.NewTicker(mapsCheckInterval)
+		defer ticker.Stop()
+		checkMaps = ticker.C
+	}
+
+	// Collect data until interrupt.
+	for done := false; !done; {
+		select {
+		case <-ctx.Done():
+			done = true
+		case <-checkMaps:
+			sizes, grow := p.grownMapSizes(bpfModule)
+			if !grow {
+				continue
+			}
+			p.logger.Info().
+				Uint32("stack_traces", sizes.stackTraces).
+				Uint32("histogram", sizes.histogram).
+				Uint32("binprm_info", sizes.binprmInfo).
+				Msg("BPF maps near full, reloading the probe with larger maps")
+
+			// The current probe samples until the new one is loaded. The snapshots
+			// in progress are waited for, before the unwinder is replaced.
+			stopPolling()
+			stopWatching()
+			newModule, err := p.loadProbe(sizes)
+			if err != nil {
+				p.logger.Warn().Err(err).Msg("error reloading the BPF probe, the maps will not be resized")
+				checkMaps = nil
+				stopWatching = p.watch(bpfModule)
+				stopPolling = p.pollMaps()
+				continue
+			}
+			drained, err := p.readSamples(bpfModule)
+			if err != nil {
+				newModule.Close()
+				return nil, err
+			}
+			samples = append(samples, drained...)
+			p.addDrops(bpfModule)
+			p.addProbeStats(bpfModule)
+			bpfModule.Close()
+			bpfModule = newModule
+			p.mapSizes = sizes
+
+			if stopWatching, err = p.startSampling(bpfModule); err != nil {
+				return nil, err
+			}
+			stopPolling = p.pollMaps()
+		}
+	}
+
+	stopPolling()
+	stopWatching()
+
+	// Open the objects mapped since the last snapshot, like the libraries loaded
+	// with dlopen, before the process can exit while the samples are symbolized.
+	if err := p.symTabProc.Snapshot(); err != nil {
+		p.logger.Debug().Err(err).Int("pid", p.pid).Msg("error taking the process snapshot")
+	}
+
+	p.logger.Debug().Msg("received signal, analysing data")
+
+	binprmInfo, err := bpfModule.GetMap(mapBinprmInfo)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error getting %s BPF map", mapBinprmInfo))
+	}
+
+	loadWG := &sync.WaitGroup{}
+	loadWG.Add(1)
+	go func() {
+		defer loadWG.Done()
+		load(binprmInfo)
+	}()
+
+	drained, err := p.readSamples(bpfModule)
+
+	// Wait for the load to complete.
+	loadWG.Wait()
+
+	if err != nil {
+		return nil, err
+	}
+	samples = append(samples, drained...)
+	p.addDrops(bpfModule)
+	p.addProbeStats(bpfModule)
+	for _, s := range p.ProbeStats() {
+		p.logger.Info().
+			Str("program", s.Program).
+			Uint64("run_count", s.RunCount).
+			Dur("run_time", s.RunTime).
+			Dur("avg_run_time", s.AvgRunTime()).
+			Msg("BPF probe statistics")
+	}
+
+	return samples, nil
+}
+
+// loadProbe creates the BPF module of the probe, with the maps of the sizes, and loads it.
+// If the kernel does not support the DWARF unwinder, like before Linux 5.17, the probe is
+// loaded with the sampler that unwinds the user stacks with frame pointers only.
+func (p *Profiler) loadProbe(sizes mapSizes) (*bpf.Module, error) {
+	if !p.dwarfUnwinding {
+		return p.loadProbeSampler(sizes, p.probeName)
+	}
+	bpfModule, err := p.loadProbeSampler(sizes, probeDWARFName)
+	if err == nil {
+		return bpfModule, nil
+	}
+	bpfModule, fpErr := p.loadProbeSampler(sizes, p.probeName)
+	if fpErr != nil {
+		return nil, fpErr
+	}
+	p.logger.Warn().Err(err).Msg("error loading the DWARF unwinder, the user stacks are unwound with frame pointers only")
+	p.dwarfUnwinding = false
+
+	return bpfModule, nil
+}
+
+// loadProbeSampler loads the probe with the maps of the sizes, and the sampler program of the name only.
+func (p *Profiler) loadProbeSampler(sizes mapSizes, sampler string) (*bpf.Module, error) {
 	bpfModule, err := bpf.NewModuleFromBuffer(p.probe, p.probeName)
 	if err != nil {
 		return nil, errors.Wrap(err, "error creating the BPF module object")
 	}
-	defer bpfModule.Close()
+
+	// The other sampler is not loaded, as the kernel may not support it.
+	for _, name := range []string{p.probeName, probeDWARFName} {
+		if name == sampler {
+			continue
+		}
+		prog, err := bpfModule.GetProgram(name)
+		if err != nil {
+			bpfModule.Close()
+			return nil, errors.Wrap(err, "error getting the BPF program object")
+		}
+		if err = prog.SetAutoload(false); err != nil {
+			bpfModule.Close()
+			return nil, errors.Wrap(err, fmt.Sprintf("error disabling the load of %s BPF program", name))
+		}
+	}
+
+	// The max entries of the maps can only be set before loading.
+	for name, size := range sizes.byMap(p) {
+		m, err := bpfModule.GetMap(name)
+		if err != nil {
+			bpfModule.Close()
+			return nil, errors.Wrap(err, fmt.Sprintf("error getting %s BPF map", name))
+		}
+		if err = m.SetMaxEntries(size); err != nil {
+			bpfModule.Close()
+			return nil, errors.Wrap(err, fmt.Sprintf("error setting the size of %s BPF map", name))
+		}
+	}
 	p.logger.Debug().Msg("loading BPF object")
 
 	if err := bpfModule.BPFLoadObject(); err != nil {
+		bpfModule.Close()
 		return nil, errors.Wrap(err, "error loading the BPF program")
 	}
+
+	return bpfModule, nil
+}
+
+// startSampling attaches the loaded probe to the sampling perf events, and snapshots
+// the process. It returns the function to stop watching the processes.
+// The watching and the polling of the previous probe, if any, must have been
+// stopped, as they load the unwind tables into the maps of the unwinder.
+func (p *Profiler) startSampling(bpfModule *bpf.Module) (func(), error) {
+	// The unwind tables of the process are loaded with its snapshots.
+	var unwinder *unwinder
+	if p.dwarfUnwinding {
+		var err error
+		if unwinder, err = newUnwinder(bpfModule); err != nil {
+			p.logger.Debug().Err(err).Msg("error initializing the DWARF unwinding")
+		}
+	}
+	p.unwinder = unwinder
+	// Only the profiled process is sampled.
+	if err := p.setFilter(bpfModule); err != nil {
+		return nil, errors.Wrap(err, "error setting the sampling filter")
+	}
 	p.logger.Debug().Msg("getting the loaded BPF program")
 
-	prog, err := bpfModule.GetProgram(p.probeName)
+	prog, err := bpfModule.GetProgram(p.samplerName())
 	if err != nil {
 		return nil, errors.Wrap(err, "error getting the BPF program object")
 	}
@@ -81,12 +446,58 @@ func (p *Profiler) RunProfile(ctx context.Context) (*dag.DAG, error) {
 	if err = p.attachSampler(prog); err != nil {
 		return nil, errors.Wrap(err, "error attaching the sampler")
 	}
-	p.logger.Info().Msg("collecting data")
 
-	// Collect data until interrupt.
-	<-ctx.Done()
+	// Otherwise, the executable paths are recorded only on the first samples of the processes.
+	if err = p.attachTracepoint(bpfModule, probeExecName, "sched", "sched_process_exec"); err != nil {
+		p.logger.Debug().Err(err).Msg("error attaching the exec tracepoint")
+	}
+	// Otherwise, the samples of the same stacks before and after the process maps
+	// an executable file are counted together, and resolved with the later mappings.
+	if err = p.attachTracepoint(bpfModule, probeMmapName, "syscalls", "sys_enter_mmap"); err != nil {
+		p.logger.Debug().Err(err).Msg("error attaching the mmap tracepoint")
+	}
 
-	p.logger.Debug().Msg("received signal, analysing data")
+	// Capture the process metadata while it's alive, in case it exits meanwhile.
+	p.snapshot()
+
+	return p.watch(bpfModule), nil
+}
+
+// samplerName returns the name of the program of the probe that samples the stack traces.
+func (p *Profiler) samplerName() string {
+	if p.dwarfUnwinding {
+		return probeDWARFName
+	}
+
+	return p.probeName
+}
+
+// attachTracepoint attaches the program of the probe with the name to the tracepoint.
+func (p *Profiler) attachTracepoint(bpfModule *bpf.Module, name string, category string, event string) error {
+	prog, err := bpfModule.GetProgram(name)
+	if err != nil {
+		return errors.Wrap(err, "error getting the BPF program object")
+	}
+	if _, err = prog.AttachTracepoint(category, event); err != nil {
+		return errors.Wrap(err, "error attaching the BPF program to the tracepoint")
+	}
+
+	return nil
+}
+
+// watch watches the processes sampled by the probe, and returns the function to stop.
+func (p *Profiler) watch(bpfModule *bpf.Module) func() {
+	stopWatching, err := p.watchProcesses(bpfModule)
+	if err != nil {
+		p.logger.Debug().Err(err).Msg("error watching the processes")
+		return func() {}
+	}
+
+	return stopWatching
+}
+
+// readSamples reads the samples of the process from the histogram and stack traces maps of the probe.
+func (p *Profiler) readSamples(bpfModule *bpf.Module) ([]sample, error) {
 	p.logger.Debug().Msg("getting the stack traces BPF map")
 
 	stackTracesMap, err := bpfModule.GetMap(p.mapStackTraces)
@@ -100,39 +511,16 @@ func (p *Profiler) RunProfile(ctx context.Context) (*dag.DAG, error) {
 		return nil, errors.Wrap(err, fmt.Sprintf("error getting %s BPF map", p.mapHistogram))
 	}
 
-	binprmInfo, err := bpfModule.GetMap("binprm_info")
+	dwarfStackTracesMap, err := bpfModule.GetMap(mapDWARFStackTraces)
 	if err != nil {
-		return nil, errors.Wrap(err, fmt.Sprintf("error getting %s BPF map", "binprm_info"))
+		return nil, errors.Wrap(err, fmt.Sprintf("error getting %s BPF map", mapDWARFStackTraces))
 	}
 
 	// Iterate over the stack profile counts histogramMap map.
-	counts := make(map[string]int, 0)
-	traces := make(map[string][]string, 0)
-	totalCount := 0
+	samples := make([]sample, 0)
 
 	p.logger.Debug().Msg("iterating over the retrieved histogramMap items")
 
-	// Try to load symbols.
-	symbolizationWG := &sync.WaitGroup{}
-	symbolizationWG.Add(1)
-	go func() {
-		defer symbolizationWG.Done()
-
-		// Get process executable path on filesystem.
-		exePath, err := p.getExePath(binprmInfo, int32(p.pid))
-		if err != nil {
-			p.logger.Debug().Int("pid", p.pid).Msg("error getting executable path for symbolization")
-			return
-		}
-		p.logger.Debug().Str("path", *exePath).Int("pid", p.pid).Msg("executable path found")
-
-		// Try to load ELF symbol table, if it's an ELF executable.
-		if err = p.symTabELF.Load(*exePath); err != nil {
-			p.logger.Debug().Err(err).Msg("error loading the ELF symbol table")
-			return
-		}
-	}()
-
 	// For each function (HistogramKey) sampled.
 	for it := histogramMap.Iterator(); it.Next(); {
 		k := it.Key()
@@ -142,68 +530,193 @@ func (p *Profiler) RunProfile(ctx context.Context) (*dag.DAG, error) {
 		if err != nil {
 			return nil, errors.Wrap(err, fmt.Sprintf("error getting stack profile count for key %v", k))
 		}
-		count := int(binary.LittleEndian.Uint64(v))
+		var value HistogramValue
+		if err = binary.Read(bytes.NewBuffer(v), binary.LittleEndian, &value); err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("error reading the stack profile count %v", v))
+		}
+		count := int(value.Count)
 
 		var key HistogramKey
 		if err = binary.Read(bytes.NewBuffer(k), binary.LittleEndian, &key); err != nil {
 			return nil, errors.Wrap(err, fmt.Sprintf("error reading the stack profile count key %v", k))
 		}
 
-		// Skip stack profile counts of other tasks.
+		// The probe samples only the profiled process, but skip the counts
+		// of other tasks in case the filter could not be set.
 		if int(key.Pid) != p.pid {
 			continue
 		}
-		p.logger.Debug().Int("pid", p.pid).Uint32("user_stack_id", key.UserStackId).Uint32("kernel_stack_id", key.KernelStackId).Int("count", count).Msg("got stack traces")
+		p.logger.Debug().Int("pid", p.pid).Uint32("user_stack_id", key.UserStackId).Uint32("kernel_stack_id", key.KernelStackId).Uint32("mapping_generation", key.MappingGeneration).Int("count", count).Msg("got stack traces")
 
-		// symbols contains the symbols list for current trace of the kernel and user stacks.
-		symbols := make([]string, 0)
+		var smpl sample
+		smpl.count = count
+		smpl.time = value.LastSeenNs
 
-		// Wait for the symbols to be loaded.
-		symbolizationWG.Wait()
+		if int32(key.KernelStackId) >= 0 {
+			stackTrace, err := p.getStackTraceByID(stackTracesMap, key.KernelStackId)
+			if err != nil {
+				p.logger.Err(err).Uint32("id", key.KernelStackId).Msg("error getting kernel stack trace")
+				return nil, errors.Wrap(err, "error getting kernel stack")
+			}
+			smpl.kernelStack = stackTrace
+		}
 
-		// Append symbols from user stack.
 		if int32(key.UserStackId) >= 0 {
-			stackTrace, err := p.getStackTraceByID(stackTracesMap, key.UserStackId)
+			userStackTracesMap := stackTracesMap
+			if key.Flags&histogramFlagDWARFUserStack != 0 {
+				userStackTracesMap = dwarfStackTracesMap
+			}
+			stackTrace, err := p.getStackTraceByID(userStackTracesMap, key.UserStackId)
 			if err != nil {
 				p.logger.Err(err).Uint32("id", key.UserStackId).Msg("error getting user stack trace")
 				return nil, errors.Wrap(err, "error getting user stack")
 			}
-			symbols = append(symbols, p.getHumanReadableStackTrace(stackTrace)...)
+			smpl.userStack = stackTrace
+		}
+		samples = append(samples, smpl)
+	}
+
+	return samples, nil
+}
+
+// addDrops adds the samples and stacks dropped by the probe to the dropped ones.
+func (p *Profiler) addDrops(bpfModule *bpf.Module) {
+	dropped, err := p.readDrops(bpfModule)
+	if err != nil {
+		p.logger.Debug().Err(err).Msg("error reading the dropped samples")
+		return
+	}
+	for reason, n := range dropped {
+		p.dropped[reason] += n
+	}
+}
+
+// loadSymbols loads the kernel symbol table and the process symbol table,
+// or the executable symbol table when the process mappings are not available.
+func (p *Profiler) loadSymbols(binprmInfo *bpf.BPFMap) {
+	symbolizationWG := &sync.WaitGroup{}
+	symbolizationWG.Add(2)
+	go func() {
+		defer symbolizationWG.Done()
+
+		// Try to load the kernel symbol table.
+		if err := p.symTabKernel.Load(p.kallsymsPath); err != nil {
+			p.logger.Debug().Err(err).Str("path", p.kallsymsPath).Msg("error loading the kernel symbol table")
+		}
+	}()
+	go func() {
+		defer symbolizationWG.Done()
+
+		// Try to load the process memory mappings, to symbolize
+		// shared libraries and position independent executables.
+		err := p.symTabProc.Load()
+		if err == nil {
+			return
+		}
+		p.logger.Debug().Err(err).Int("pid", p.pid).Msg("error loading the process memory mappings")
+
+		// Fallback to the executable only.
+		// Get process executable path on filesystem.
+		exePath, err := p.getExePath(binprmInfo, int32(p.pid))
+		if err != nil {
+			p.logger.Debug().Int("pid", p.pid).Msg("error getting executable path for symbolization")
+			return
+		}
+		p.logger.Debug().Str("path", *exePath).Int("pid", p.pid).Msg("executable path found")
+
+		// Try to load ELF symbol table, if it's an ELF executable.
+		if err = p.symTabELF.Load(*exePath); err != nil {
+			p.logger.Debug().Err(err).Msg("error loading the ELF symbol table")
+			return
 		}
+	}()
+	symbolizationWG.Wait()
+}
+
+// buildProfile symbolizes the samples in the symbolization context and builds the profile DAG.
+func (p *Profiler) buildProfile(s *symbolization, samples []sample) (*dag.DAG, error) {
+	counts := make(map[string]int, 0)
+	traces := make(map[string][]symtable.Frame, 0)
+	totalCount := 0
+	var unsymbolized uint64
+
+	for _, smpl := range samples {
+		// symbols contains the frames list for current trace of the kernel and user stacks.
+		symbols := make([]symtable.Frame, 0)
 
 		// Append symbols from kernel stack.
-		if int32(key.KernelStackId) >= 0 {
-			stackTrace, err := p.getStackTraceByID(stackTracesMap, key.KernelStackId)
-			if err != nil {
-				p.logger.Err(err).Uint32("id", key.KernelStackId).Msg("error getting kernel stack trace")
-				return nil, errors.Wrap(err, "error getting kernel stack")
-			}
-			symbols = append(symbols, p.getHumanReadableStackTrace(stackTrace)...)
+		// Kernel frames come first, as the kernel stack sits on top of the user stack.
+		if smpl.kernelStack != nil {
+			frames, n := p.getHumanReadableStackTrace(s, smpl.kernelStack, symtable.KernelPID, smpl.time)
+			symbols = append(symbols, frames...)
+			unsymbolized += uint64(n * smpl.count)
+		}
+
+		// Append symbols from user stack.
+		if smpl.userStack != nil {
+			frames, n := p.getHumanReadableStackTrace(s, smpl.userStack, s.pid, smpl.time)
+			symbols = append(symbols, frames...)
+			unsymbolized += uint64(n * smpl.count)
 		}
 
 		// Build a key for the histogram based on concatenated symbols.
 		var symbolsKey string
 		for _, symbol := range symbols {
-			symbolsKey += fmt.Sprintf("%s;", symbol)
+			symbolsKey += fmt.Sprintf("%s;", frameKey(symbol))
 		}
 
 		// Update the statistics.
-		totalCount += count
-		counts[symbolsKey] += count
+		totalCount += smpl.count
+		counts[symbolsKey] += smpl.count
 		traces[symbolsKey] = symbols
 	}
 
+	stats := p.symCache.Stats()
+	p.logger.Debug().
+		Uint64("hits", stats.Hits).
+		Uint64("misses", stats.Misses).
+		Uint64("evictions", stats.Evictions).
+		Int("len", stats.Len).
+		Msg("symbol cache statistics")
+
 	tree, err := buildDAG(counts, traces, totalCount)
 	if err != nil {
 		return nil, errors.Wrap(err, "error building profile DAG")
 	}
+	var unavailable []string
+	if s.unavailable != nil {
+		unavailable = s.unavailable()
+	}
+	for _, warning := range warnings(s.mismatched(), unavailable) {
+		p.logger.Warn().Msg(warning)
+		tree.AddWarning(warning)
+	}
+	quality := newQuality(samples, s.dropped, unsymbolized)
+	p.logger.Debug().Msgf("profile quality: %s", quality)
+	tree.SetQuality(quality)
 
 	return tree, nil
 }
 
+// warnings returns the warnings about the objects that have been symbolized
+// with files that could not be verified to be the profiled ones, and that
+// have not been symbolized as their files could not be opened.
+func warnings(mismatched map[string]string, unavailable []string) []string {
+	warnings := make([]string, 0, len(mismatched)+len(unavailable))
+	for pathname, path := range mismatched {
+		warnings = append(warnings, fmt.Sprintf("%s has been symbolized with %s, that could not be verified to be the profiled binary", pathname, path))
+	}
+	for _, pathname := range unavailable {
+		warnings = append(warnings, fmt.Sprintf("%s has not been symbolized, as it could not be opened, like when loaded after the last snapshot of the exited process", pathname))
+	}
+	sort.Strings(warnings)
+
+	return warnings
+}
+
 // buildDAG builds a DAG from the statistics represented by perTraceSampleCounts, totalSampleCount,
-// and the traces map that contains the symbolized function slices.
-func buildDAG(perTraceSampleCounts map[string]int, traces map[string][]string, totalSampleCount int) (*dag.DAG, error) {
+// and the traces map that contains the symbolized frame slices.
+func buildDAG(perTraceSampleCounts map[string]int, traces map[string][]symtable.Frame, totalSampleCount int) (*dag.DAG, error) {
 	tree := dag.NewDAG()
 	for k, symbols := range traces {
 		var parentID int64
@@ -215,16 +728,23 @@ func buildDAG(perTraceSampleCounts map[string]int, traces map[string][]string, t
 				weight = float64(perTraceSampleCounts[k]) / float64(totalSampleCount)
 			}
 
-			// Generate a hash from the symbol string for reproducibility.
-			// We want a unique node per function so that the directed graph can be generated
-			// as a tree where parent nodes represent callers and child callee functions.
-			id := generateHash(symbols[i])
-			if n := tree.Node(id); n == nil {
-				tree.AddCustomNode(id, symbols[i], weight)
+			// Generate a hash from the frame string for reproducibility.
+			// We want a unique node per function, or source line when known, so that the directed graph
+			// can be generated as a tree where parent nodes represent callers and child callee functions.
+			id := generateHash(frameKey(symbols[i]))
+			if n, ok := tree.Node(id).(*dag.Node); ok {
+				// Traces that end with the same frame add up, like the ones merged by normalization.
+				n.Weight += weight
+			} else {
+				tree.AddCustomNode(id, symbols[i].Name, weight,
+					dag.WithSource(string(symbols[i].Source)),
+					dag.WithLocation(symbols[i].File, symbols[i].Line),
+					dag.WithInlined(symbols[i].Inlined),
+				)
 			}
 
-			// Set relationships in the DAG.
-			if parentID != 0 {
+			// Set relationships in the DAG. The frames of a recursive call are the same node.
+			if parentID != 0 && parentID != id {
 				err := tree.AddCustomEdge(parentID, id)
 				if err != nil {
 					return nil, err
@@ -237,6 +757,16 @@ func buildDAG(perTraceSampleCounts map[string]int, traces map[string][]string, t
 	return tree, nil
 }
 
+// frameKey returns the string that identifies a frame, that is the function name
+// and the source location when known.
+func frameKey(frame symtable.Frame) string {
+	if frame.File == "" {
+		return frame.Name
+	}
+
+	return fmt.Sprintf("%s %s:%d", frame.Name, frame.File, frame.Line)
+}
+
 // generateHash generates a fnv-1a hash from a string.
 func generateHash(s string) int64 {
 	h := fnv.New64a()
@@ -0,0 +1,114 @@
+package profile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"unsafe"
+
+	bpf "github.com/aquasecurity/libbpfgo"
+
+	"github.com/maxgio92/yap/pkg/dag"
+)
+
+// Reasons of the samples, or of their stacks, dropped by the BPF probe.
+const (
+	// DropReasonExePath is for the samples dropped as the executable path could not be read.
+	DropReasonExePath = "exe_path"
+
+	// DropReasonStackCollision is for the stacks dropped as their hash collided in a stack map.
+	DropReasonStackCollision = "stack_collision"
+
+	// DropReasonStackMapFull is for the stacks dropped as a stack map is full.
+	DropReasonStackMapFull = "stack_map_full"
+
+	// DropReasonHistogramUpdate is for the samples dropped as the histogram
+	// could not be updated, like when full.
+	DropReasonHistogramUpdate = "histogram_update"
+
+	// DropReasonBinprmInfoFull is for the executable paths not stored as the
+	// binprm_info map is full. Their samples are kept.
+	DropReasonBinprmInfoFull = "binprm_info_full"
+)
+
+// mapSampleDrops are the counters of the dropped samples and stacks, by pid.
+const mapSampleDrops = "sample_drops"
+
+// dropReasons are the reasons by their index in the sample_drops_t struct of the BPF probe.
+var dropReasons = [...]string{
+	DropReasonExePath,
+	DropReasonStackCollision,
+	DropReasonStackMapFull,
+	DropReasonHistogramUpdate,
+	DropReasonBinprmInfoFull,
+}
+
+// sampleDrops is the value of the sample_drops BPF map.
+// The field layout must match the sample_drops_t struct of the BPF probe.
+type sampleDrops struct {
+	Dropped [len(dropReasons)]uint64
+}
+
+// readDrops returns the numbers of the dropped samples and stacks of the process,
+// by reason. It's empty if none has been dropped.
+func (p *Profiler) readDrops(bpfModule *bpf.Module) (map[string]uint64, error) {
+	dropsMap, err := bpfModule.GetMap(mapSampleDrops)
+	if err != nil {
+		return nil, err
+	}
+
+	dropped := make(map[string]uint64)
+	key := uint32(p.pid)
+	v, err := dropsMap.GetValue(unsafe.Pointer(&key))
+	if err != nil || len(v) == 0 {
+		// The process has no counters if nothing has been dropped.
+		return dropped, nil
+	}
+	var drops sampleDrops
+	if err = binary.Read(bytes.NewBuffer(v), binary.LittleEndian, &drops); err != nil {
+		return nil, err
+	}
+	for i, n := range drops.Dropped {
+		if n > 0 {
+			dropped[dropReasons[i]] = n
+		}
+	}
+
+	return dropped, nil
+}
+
+// stackDepth returns the number of the addresses of the stack trace, and
+// whether it has been truncated, as deeper than the maximum depth.
+func stackDepth(stackTrace *StackTrace) (int, bool) {
+	depth := 0
+	for _, ip := range stackTrace {
+		if ip != 0 {
+			depth++
+		}
+	}
+
+	return depth, depth == len(stackTrace)
+}
+
+// newQuality returns the quality summary of the samples, with the dropped ones
+// and the unsymbolized addresses of their stacks, weighted by the sample counts.
+func newQuality(samples []sample, dropped map[string]uint64, unsymbolized uint64) *dag.Quality {
+	quality := &dag.Quality{Dropped: dropped, Unsymbolized: unsymbolized}
+	for _, smpl := range samples {
+		count := uint64(smpl.count)
+		quality.Collected += count
+		truncated := false
+		for _, stackTrace := range []*StackTrace{smpl.kernelStack, smpl.userStack} {
+			if stackTrace == nil {
+				continue
+			}
+			depth, full := stackDepth(stackTrace)
+			quality.Frames += uint64(depth) * count
+			truncated = truncated || full
+		}
+		if truncated {
+			quality.Truncated += count
+		}
+	}
+
+	return quality
+}
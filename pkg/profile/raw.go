@@ -0,0 +1,334 @@
+package profile
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/pkg/errors"
+
+	"github.com/maxgio92/yap/pkg/dag"
+	"github.com/maxgio92/yap/pkg/procmaps"
+	"github.com/maxgio92/yap/pkg/symtable"
+)
+
+// RawProfileVersion is the version of the raw profile format.
+const RawProfileVersion = 1
+
+var (
+	ErrRawProfileVersion = errors.New("unsupported raw profile version")
+)
+
+// RawProfile is an unsymbolized profile, that records the sampled addresses
+// with the memory mappings and the build-ids of the objects they belong to,
+// so that it can be symbolized later, on a different machine.
+type RawProfile struct {
+	Version int `json:"version"`
+	PID     int `json:"pid"`
+
+	// Executable is the executable of the process, recorded when
+	// the memory mappings are not available.
+	Executable *RawObject   `json:"executable,omitempty"`
+	Mappings   []RawMapping `json:"mappings"`
+	Samples    []RawSample  `json:"samples"`
+
+	// Dropped are the numbers of the samples and stacks dropped while collecting, by reason.
+	Dropped map[string]uint64 `json:"dropped,omitempty"`
+}
+
+// RawObject is an ELF object of a raw profile.
+type RawObject struct {
+	Pathname string `json:"pathname"`
+	BuildID  string `json:"build_id,omitempty"`
+
+	// Mismatch is whether the build-id has been read from a file that
+	// could not be verified to be the mapped one, like after a deploy.
+	Mismatch bool `json:"mismatch,omitempty"`
+}
+
+// RawMapping is an executable memory mapping of the profiled process.
+type RawMapping struct {
+	Start  uint64 `json:"start"`
+	End    uint64 `json:"end"`
+	Offset uint64 `json:"offset"`
+	Perms  string `json:"perms"`
+
+	// From and Until are the CLOCK_MONOTONIC times in nanoseconds the mapping
+	// has been valid in. Until is zero if still valid at the end of the profile.
+	From  uint64 `json:"from,omitempty"`
+	Until uint64 `json:"until,omitempty"`
+
+	RawObject
+}
+
+// RawSample is a sampled pair of kernel and user stack traces, with its count.
+// Stacks are ordered from the top.
+type RawSample struct {
+	Count int `json:"count"`
+
+	// Time is the CLOCK_MONOTONIC time in nanoseconds of the last sample.
+	Time uint64 `json:"time,omitempty"`
+
+	KernelStack []uint64   `json:"kernel_stack,omitempty"`
+	UserStack   []RawFrame `json:"user_stack,omitempty"`
+}
+
+// RawFrame is an instruction pointer of a user stack trace.
+type RawFrame struct {
+	Addr uint64 `json:"addr"`
+
+	// Mapping is the index of the mapping of the address, or -1 if unknown.
+	Mapping int `json:"mapping"`
+
+	// FileOffset is the offset of the address in the file of its mapping, or zero if unknown.
+	FileOffset uint64 `json:"file_offset,omitempty"`
+}
+
+// address returns the address of the frame, from its offset in the file of
+// its mapping, if known, or the recorded one otherwise.
+func (f RawFrame) address(mappings []RawMapping) uint64 {
+	if f.FileOffset == 0 || f.Mapping < 0 || f.Mapping >= len(mappings) {
+		return f.Addr
+	}
+	m := mappings[f.Mapping]
+
+	return m.Start + f.FileOffset - m.Offset
+}
+
+// ReadRawProfile decodes a raw profile.
+func ReadRawProfile(r io.Reader) (*RawProfile, error) {
+	raw := new(RawProfile)
+	if err := json.NewDecoder(r).Decode(raw); err != nil {
+		return nil, errors.Wrap(err, "error decoding the raw profile")
+	}
+	if raw.Version != RawProfileVersion {
+		return nil, ErrRawProfileVersion
+	}
+
+	return raw, nil
+}
+
+// Write encodes the raw profile.
+func (r *RawProfile) Write(w io.Writer) error {
+	if err := json.NewEncoder(w).Encode(r); err != nil {
+		return errors.Wrap(err, "error encoding the raw profile")
+	}
+
+	return nil
+}
+
+// history returns the recorded memory mappings.
+func (r *RawProfile) history() *procmaps.History {
+	history := procmaps.NewHistory()
+	for _, m := range r.Mappings {
+		history.Add(procmaps.Version{
+			Mapping: procmaps.Mapping{
+				Start:    m.Start,
+				End:      m.End,
+				Perms:    m.Perms,
+				Offset:   m.Offset,
+				Pathname: m.Pathname,
+			},
+			From:  m.From,
+			Until: m.Until,
+		})
+	}
+
+	return history
+}
+
+// buildIDs returns the recorded build-ids of the objects, by path.
+func (r *RawProfile) buildIDs() map[string]string {
+	ids := make(map[string]string)
+	objs := make([]RawObject, 0, len(r.Mappings)+1)
+	if r.Executable != nil {
+		objs = append(objs, *r.Executable)
+	}
+	for _, m := range r.Mappings {
+		objs = append(objs, m.RawObject)
+	}
+	for _, obj := range objs {
+		if obj.BuildID != "" {
+			ids[obj.Pathname] = obj.BuildID
+		}
+	}
+
+	return ids
+}
+
+// samples returns the recorded samples.
+func (r *RawProfile) samples() []sample {
+	samples := make([]sample, 0, len(r.Samples))
+	for _, s := range r.Samples {
+		smpl := sample{count: s.Count, time: s.Time}
+		if len(s.KernelStack) > 0 {
+			smpl.kernelStack = new(StackTrace)
+			copy(smpl.kernelStack[:], s.KernelStack)
+		}
+		if len(s.UserStack) > 0 {
+			smpl.userStack = new(StackTrace)
+			for i := 0; i < len(s.UserStack) && i < len(smpl.userStack); i++ {
+				smpl.userStack[i] = s.UserStack[i].address(r.Mappings)
+			}
+		}
+		samples = append(samples, smpl)
+	}
+
+	return samples
+}
+
+// newRawProfile returns the raw profile of the samples, with the executable
+// memory mappings of the process over time, or its executable when not available.
+func (p *Profiler) newRawProfile(samples []sample, exePath string) *RawProfile {
+	raw := &RawProfile{
+		Version:  RawProfileVersion,
+		PID:      p.pid,
+		Mappings: make([]RawMapping, 0),
+		Samples:  make([]RawSample, 0, len(samples)),
+	}
+	if len(p.dropped) > 0 {
+		raw.Dropped = p.dropped
+	}
+
+	buildIDs := make(map[string]string)
+	buildID := func(pathname string) string {
+		id, ok := buildIDs[pathname]
+		if !ok {
+			id, _ = symtable.ReadFileBuildID(pathname)
+			buildIDs[pathname] = id
+		}
+		return id
+	}
+
+	// Versions are identified by their address and creation time.
+	type versionKey struct{ start, from uint64 }
+	history := p.symTabProc.History()
+	indexes := make(map[versionKey]int)
+	for _, v := range history.Versions() {
+		m := v.Mapping
+		if !m.IsExecutable() {
+			continue
+		}
+		obj := RawObject{Pathname: m.Pathname}
+		if m.IsFileBacked() && !m.IsAnonymous() {
+			// The pathname is relative to the mount namespace of the process.
+			if path, err := p.symTabProc.ObjectPath(&m); err == nil {
+				obj.BuildID = buildID(path)
+			}
+		}
+		if m.IsVDSO() {
+			obj.BuildID, _ = p.symTabProc.BuildID(&m)
+		}
+		indexes[versionKey{m.Start, v.From}] = len(raw.Mappings)
+		raw.Mappings = append(raw.Mappings, RawMapping{
+			Start:     m.Start,
+			End:       m.End,
+			Offset:    m.Offset,
+			Perms:     m.Perms,
+			From:      v.From,
+			Until:     v.Until,
+			RawObject: obj,
+		})
+	}
+	mismatched := p.symTabProc.Mismatched()
+	for i := range raw.Mappings {
+		_, raw.Mappings[i].Mismatch = mismatched[raw.Mappings[i].Pathname]
+	}
+	if p.symTabProc.Maps() == nil && exePath != "" {
+		raw.Executable = &RawObject{Pathname: exePath, BuildID: buildID(exePath)}
+	}
+
+	for _, smpl := range samples {
+		s := RawSample{Count: smpl.count, Time: smpl.time}
+		if smpl.kernelStack != nil {
+			for _, ip := range smpl.kernelStack {
+				if ip != 0 {
+					s.KernelStack = append(s.KernelStack, ip)
+				}
+			}
+		}
+		if smpl.userStack != nil {
+			for i, ip := range smpl.userStack {
+				if ip == 0 {
+					continue
+				}
+				frame := RawFrame{Addr: ip, Mapping: -1}
+				// The mapping of return addresses is the one of the call instruction.
+				addr := ip
+				if i > 0 {
+					addr--
+				}
+				if v, err := history.Find(addr, smpl.time); err == nil && v.IsExecutable() {
+					frame.Mapping = indexes[versionKey{v.Start, v.From}]
+					frame.FileOffset = v.FileOffset(ip)
+				}
+				s.UserStack = append(s.UserStack, frame)
+			}
+		}
+		raw.Samples = append(raw.Samples, s)
+	}
+
+	return raw
+}
+
+// SymbolizeRawProfile symbolizes a raw profile recorded on a different machine,
+// and builds the profile DAG. The objects are looked up in the symbol paths,
+// and their separate debug files as for the live profiles.
+// Kernel addresses are resolved only with the kernel symbol table of the
+// profiled machine, which is read from the kallsyms path, if set.
+func (p *Profiler) SymbolizeRawProfile(raw *RawProfile) (*dag.DAG, error) {
+	if raw.Version != RawProfileVersion {
+		return nil, ErrRawProfileVersion
+	}
+
+	if p.kallsymsPath != "" {
+		if err := p.symTabKernel.Load(p.kallsymsPath); err != nil {
+			p.logger.Debug().Err(err).Str("path", p.kallsymsPath).Msg("error loading the kernel symbol table")
+		}
+	}
+
+	objs := symtable.NewOfflineSymTab(p.symbolPaths, raw.buildIDs(), p.symTabOpts...)
+	if raw.Executable != nil {
+		path, err := objs.FindObject(raw.Executable.Pathname)
+		if err == nil {
+			err = p.symTabELF.Load(path)
+		}
+		if err != nil {
+			p.logger.Debug().Err(err).Str("path", raw.Executable.Pathname).Msg("error loading the ELF symbol table")
+		}
+	}
+
+	// The objects that could not be verified when recorded are reported as well.
+	mismatched := make(map[string]string)
+	for _, m := range raw.Mappings {
+		if !m.Mismatch {
+			continue
+		}
+		path, err := objs.FindObject(m.Pathname)
+		if err != nil {
+			path = m.Pathname
+		}
+		mismatched[m.Pathname] = path
+	}
+
+	// Addresses are resolved with the recorded mappings and objects, in place of
+	// the ones of the process.
+	history := raw.history()
+	s := &symbolization{
+		pid: raw.PID,
+		findMapping: func(addr uint64, t uint64) (*procmaps.Mapping, error) {
+			v, err := history.Find(addr, t)
+			if err != nil {
+				return nil, err
+			}
+			return &v.Mapping, nil
+		},
+		dropped: raw.Dropped,
+		mismatched: func() map[string]string {
+			return mismatched
+		},
+	}
+	s.symbolizer = append(symtable.Chain{}, p.symbolizers...)
+	s.symbolizer = append(s.symbolizer, p.symTabKernel, objs, p.symTabELF)
+
+	return p.buildProfile(s, raw.samples())
+}
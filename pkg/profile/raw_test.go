@@ -0,0 +1,176 @@
+package profile_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/maxgio92/yap/pkg/dag"
+	"github.com/maxgio92/yap/pkg/normalize"
+	"github.com/maxgio92/yap/pkg/procmaps"
+	. "github.com/maxgio92/yap/pkg/profile"
+)
+
+func TestSymbolizeRawProfile(t *testing.T) {
+	maps, err := procmaps.Read(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ip := uint64(reflect.ValueOf(TestSymbolizeRawProfile).Pointer())
+	m, err := maps.Find(ip)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw := &RawProfile{
+		Version: RawProfileVersion,
+		PID:     os.Getpid(),
+		Mappings: []RawMapping{{
+			Start:     m.Start,
+			End:       m.End,
+			Offset:    m.Offset,
+			Perms:     m.Perms,
+			RawObject: RawObject{Pathname: m.Pathname, Mismatch: true},
+		}},
+		Samples: []RawSample{{
+			Count:       1,
+			KernelStack: []uint64{0xffffffff81001010},
+			UserStack:   []RawFrame{{Addr: ip, Mapping: 0, FileOffset: m.FileOffset(ip)}},
+		}},
+	}
+
+	// The profile is symbolized after a round trip through its encoding.
+	var buf bytes.Buffer
+	if err = raw.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := ReadRawProfile(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, raw, decoded)
+
+	graph, err := NewProfiler(WithKallsymsPath("")).SymbolizeRawProfile(decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	symbols := nodeSymbols(graph)
+	assert.Contains(t, symbols, "github.com/maxgio92/yap/pkg/profile_test.TestSymbolizeRawProfile")
+
+	// Objects that could not be verified when recorded are reported.
+	assert.Len(t, graph.Warnings(), 1)
+	// Kernel addresses are not resolved without the kernel symbol table of the profiled machine.
+	assert.Contains(t, symbols, "0xffffffff81001010")
+
+	// The frames are resolved the same from the address and from the file offset.
+	for _, frame := range []RawFrame{{Addr: ip, Mapping: 0}, {Mapping: 0, FileOffset: m.FileOffset(ip)}} {
+		raw.Samples[0].UserStack = []RawFrame{frame}
+		graph, err := NewProfiler(WithKallsymsPath("")).SymbolizeRawProfile(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.ElementsMatch(t, symbols, nodeSymbols(graph))
+	}
+}
+
+func nodeSymbols(graph *dag.DAG) []string {
+	symbols := make([]string, 0)
+	for it := graph.Nodes(); it.Next(); {
+		symbols = append(symbols, graph.Node(it.Node().ID()).(*dag.Node).Symbol)
+	}
+
+	return symbols
+}
+
+func TestSymbolizeRawProfileNormalize(t *testing.T) {
+	kallsyms := filepath.Join(t.TempDir(), "kallsyms")
+	err := os.WriteFile(kallsyms, []byte("ffffffff81001000 T do_work\nffffffff81002000 t do_work.cold\nffffffff81003000 T do_syscall_64\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := &RawProfile{
+		Version: RawProfileVersion,
+		Samples: []RawSample{
+			{Count: 1, KernelStack: []uint64{0xffffffff81001010, 0xffffffff81003010}},
+			{Count: 3, KernelStack: []uint64{0xffffffff81002010, 0xffffffff81003010}},
+		},
+	}
+
+	graph, err := NewProfiler(WithKallsymsPath(kallsyms)).SymbolizeRawProfile(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The clone is merged into its function, and the traces into one.
+	weights := make(map[string]float64)
+	for it := graph.Nodes(); it.Next(); {
+		node := graph.Node(it.Node().ID()).(*dag.Node)
+		weights[node.Symbol] = node.Weight
+	}
+	assert.Equal(t, map[string]float64{"do_work": 1, "do_syscall_64": 0}, weights)
+}
+
+func TestSymbolizeRawProfileRecursion(t *testing.T) {
+	kallsyms := filepath.Join(t.TempDir(), "kallsyms")
+	err := os.WriteFile(kallsyms, []byte("ffffffff81001000 T do_work\nffffffff81003000 T do_syscall_64\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := &RawProfile{
+		Version: RawProfileVersion,
+		Samples: []RawSample{
+			{Count: 1, KernelStack: []uint64{0xffffffff81001010, 0xffffffff81001020, 0xffffffff81001020, 0xffffffff81003010}},
+		},
+	}
+
+	for _, rules := range []normalize.Rules{normalize.DefaultRules(), nil} {
+		graph, err := NewProfiler(WithKallsymsPath(kallsyms), WithNormalizeRules(rules)).SymbolizeRawProfile(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The recursive calls are the same node.
+		weights := make(map[string]float64)
+		for it := graph.Nodes(); it.Next(); {
+			node := graph.Node(it.Node().ID()).(*dag.Node)
+			weights[node.Symbol] = node.Weight
+		}
+		assert.Equal(t, map[string]float64{"do_work": 1, "do_syscall_64": 0}, weights)
+	}
+}
+
+func TestSymbolizeRawProfileQuality(t *testing.T) {
+	var deep []uint64
+	for i := 0; i < len(StackTrace{}); i++ {
+		deep = append(deep, 0xffffffff81001010)
+	}
+	raw := &RawProfile{
+		Version: RawProfileVersion,
+		Samples: []RawSample{
+			{Count: 2, KernelStack: []uint64{0xffffffff81001010, 0xffffffff81003010}},
+			{Count: 1, KernelStack: deep},
+		},
+		Dropped: map[string]uint64{DropReasonStackCollision: 5},
+	}
+
+	graph, err := NewProfiler(WithKallsymsPath("")).SymbolizeRawProfile(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Without the kernel symbol table no address is symbolized.
+	assert.Equal(t, &dag.Quality{
+		Collected:    3,
+		Dropped:      map[string]uint64{DropReasonStackCollision: 5},
+		Truncated:    1,
+		Frames:       uint64(4 + len(deep)),
+		Unsymbolized: uint64(4 + len(deep)),
+	}, graph.Quality())
+}
+
+func TestReadRawProfileVersion(t *testing.T) {
+	_, err := ReadRawProfile(strings.NewReader(`{"version": 0}`))
+	assert.ErrorIs(t, err, ErrRawProfileVersion)
+}
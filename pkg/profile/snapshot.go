@@ -0,0 +1,119 @@
+package profile
+
+import (
+	"encoding/binary"
+	"time"
+
+	bpf "github.com/aquasecurity/libbpfgo"
+	"github.com/pkg/errors"
+)
+
+const (
+	// mapPidEvents is the ring buffer of the notifications of the first sample of each process.
+	mapPidEvents = "pid_events"
+
+	pidEventsPollTimeoutMillis = 300
+
+	// DefaultMapsPollInterval is the default interval the memory mappings
+	// of the profiled process are read at, to track their changes.
+	DefaultMapsPollInterval = 500 * time.Millisecond
+)
+
+// watchProcesses snapshots the profiled process on the notification of its first
+// sample, so that it can be symbolized even if it exits before the end of the profile.
+// Samples of the other processes are discarded, so they're not snapshotted.
+// It returns the function to stop watching, that waits for the snapshot in progress, if any.
+func (p *Profiler) watchProcesses(bpfModule *bpf.Module) (func(), error) {
+	events := make(chan []byte)
+	rb, err := bpfModule.InitRingBuf(mapPidEvents, events)
+	if err != nil {
+		return nil, errors.Wrap(err, "error initializing the pid events ring buffer")
+	}
+	rb.Poll(pidEventsPollTimeoutMillis)
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			case data, ok := <-events:
+				if !ok {
+					return
+				}
+				if len(data) < 4 {
+					continue
+				}
+				if pid := int(binary.LittleEndian.Uint32(data)); pid == p.pid {
+					p.snapshot()
+				}
+			}
+		}
+	}()
+
+	return func() {
+		rb.Stop()
+		close(stop)
+		<-done
+		rb.Close()
+	}, nil
+}
+
+// snapshot captures the memory mappings of the profiled process and opens its mapped objects.
+func (p *Profiler) snapshot() {
+	if err := p.takeSnapshot(); err != nil {
+		p.logger.Debug().Err(err).Int("pid", p.pid).Msg("error taking the process snapshot")
+		return
+	}
+	p.logger.Debug().Int("pid", p.pid).Msg("process snapshot taken")
+}
+
+// takeSnapshot captures the memory mappings of the profiled process, and loads
+// the unwind tables of its mapped objects, if unwinding with them.
+func (p *Profiler) takeSnapshot() error {
+	if err := p.symTabProc.Snapshot(); err != nil {
+		return err
+	}
+	if p.unwinder == nil {
+		return nil
+	}
+	if err := p.unwinder.update(p.pid, p.symTabProc.Maps(), p.symTabProc.ObjectPath); err != nil {
+		p.logger.Debug().Err(err).Int("pid", p.pid).Msg("error loading the unwind tables")
+	}
+
+	return nil
+}
+
+// pollMaps periodically snapshots the profiled process, to track the memory mappings
+// created and removed while profiling, like of the libraries loaded with dlopen.
+// It returns the function to stop polling.
+func (p *Profiler) pollMaps() func() {
+	if p.mapsPollInterval <= 0 {
+		return func() {}
+	}
+
+	ticker := time.NewTicker(p.mapsPollInterval)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if err := p.takeSnapshot(); err != nil {
+					p.logger.Debug().Err(err).Int("pid", p.pid).Msg("error reading the process memory mappings")
+				}
+			}
+		}
+	}()
+
+	return func() {
+		ticker.Stop()
+		close(stop)
+		<-done
+	}
+}
@@ -7,6 +7,9 @@ import (
 	"unsafe"
 
 	bpf "github.com/aquasecurity/libbpfgo"
+
+	"github.com/maxgio92/yap/pkg/procmaps"
+	"github.com/maxgio92/yap/pkg/symtable"
 )
 
 // getStackTraceByID returns a StackTrace struct from the BPF_MAP_TYPE_STACK_TRACE map,
@@ -26,24 +29,98 @@ func (p *Profiler) getStackTraceByID(stackTraces *bpf.BPFMap, stackID uint32) (*
 	return &stackTrace, nil
 }
 
-// getHumanReadableStackTrace returns a string containing the resolved symbols separated by ';'
-// for the process of the ID that is passed as argument.
-// Symbolization is supported for non-stripped ELF executable binaries, because the .symtab
-// ELF section is looked up.
-func (p *Profiler) getHumanReadableStackTrace(stackTrace *StackTrace) []string {
-	symbols := make([]string, 0)
+// getHumanReadableStackTrace returns the resolved frames for the stack trace
+// of the process of the ID that is passed as argument, or of the kernel if KernelPID,
+// by using the chain of symbolizers of the symbolization context. Inlined functions are expanded into separate frames,
+// and the function names are demangled and normalized as configured. The consecutive
+// frames of different functions normalized to the same one are merged.
+// User addresses are resolved with the memory mappings valid at the time of the last sample of the stack.
+// It also returns the number of the addresses that could not be symbolized.
+func (p *Profiler) getHumanReadableStackTrace(s *symbolization, stackTrace *StackTrace, pid int, t uint64) ([]symtable.Frame, int) {
+	symbols := make([]symtable.Frame, 0)
+	// names are the function names of the symbols before normalization.
+	names := make([]string, 0)
+	unsymbolized := 0
 
-	for _, ip := range stackTrace {
+	for i, ip := range stackTrace {
 		if ip == 0 {
 			continue
 		}
-		symbol, err := p.symTabELF.GetName(ip)
-		if err != nil || symbol == "" {
+		// Except for the top frame, the addresses are return addresses, that may belong
+		// to the next line, or even function, of the call instruction. Look up the latter.
+		addr := ip
+		if i > 0 {
+			addr--
+		}
+		// The mapping is unknown for kernel addresses, or if the process mappings are not available.
+		var mapping *procmaps.Mapping
+		if pid != symtable.KernelPID {
+			mapping, _ = s.findMapping(addr, t)
+		}
+		frames, err := s.symbolizer.Symbolize(pid, addr, mapping)
+		if err != nil || len(frames) == 0 {
 			// Fallback to hex instruction pointer address.
-			symbol = fmt.Sprintf("%#016x", ip)
+			frames = []symtable.Frame{{Symbol: symtable.Symbol{Name: fmt.Sprintf("%#016x", ip)}}}
+			names = append(names, frames[0].Name)
+			unsymbolized++
+		} else {
+			frames = symtable.DemangleFrames(frames, p.demangle)
+			for _, frame := range frames {
+				names = append(names, frame.Name)
+			}
+			frames = p.normalize(frames)
+			if p.showOffsets {
+				frames = withOffsets(frames, ip-addr)
+			}
 		}
-		symbols = append(symbols, symbol)
+		symbols = append(symbols, frames...)
+	}
+	if len(p.normalizeRules) > 0 {
+		symbols = mergeFrames(symbols, names)
+	}
+
+	return symbols, unsymbolized
+}
+
+// normalize returns a copy of the frames with the function names normalized by the rules.
+func (p *Profiler) normalize(frames []symtable.Frame) []symtable.Frame {
+	if len(p.normalizeRules) == 0 {
+		return frames
+	}
+
+	res := make([]symtable.Frame, len(frames))
+	copy(res, frames)
+	for i := range res {
+		res[i].Name = p.normalizeRules.Apply(res[i].Name)
 	}
 
-	return symbols
+	return res
+}
+
+// mergeFrames merges the consecutive frames whose different function names, before
+// normalization, have been normalized to the same one, like a closure called by its
+// enclosing function, into the innermost one. The frames of recursive calls are kept.
+func mergeFrames(frames []symtable.Frame, names []string) []symtable.Frame {
+	res := make([]symtable.Frame, 0, len(frames))
+	for i, frame := range frames {
+		if n := len(res); n > 0 && res[n-1].Name == frame.Name && names[i-1] != names[i] {
+			continue
+		}
+		res = append(res, frame)
+	}
+
+	return res
+}
+
+// withOffsets returns a copy of the frames where the out-of-line function is named
+// with the offset of the instruction pointer, like func+0x1a. The adjustment is
+// added back to the offset of the looked up address, for return addresses.
+func withOffsets(frames []symtable.Frame, adjustment uint64) []symtable.Frame {
+	res := make([]symtable.Frame, len(frames))
+	copy(res, frames)
+	outer := &res[len(res)-1]
+	outer.Offset += adjustment
+	outer.Name = outer.NameWithOffset()
+
+	return res
 }
@@ -0,0 +1,148 @@
+package profile
+
+import (
+	"time"
+	"unsafe"
+
+	bpf "github.com/aquasecurity/libbpfgo"
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// probeExecName is the program of the probe that records the executable paths on exec.
+	probeExecName = "record_exec"
+
+	// probeMmapName is the program of the probe that tracks the executable memory mappings.
+	probeMmapName = "record_mmap"
+)
+
+// progInfo is the bpf_prog_info struct of <linux/bpf.h>, up to the run-time statistics.
+// The kernel fills the fields up to the size passed, and older ones without the
+// statistics leave them zero.
+type progInfo struct {
+	Type                 uint32
+	ID                   uint32
+	Tag                  [8]byte
+	JitedProgLen         uint32
+	XlatedProgLen        uint32
+	JitedProgInsns       uint64
+	XlatedProgInsns      uint64
+	LoadTime             uint64
+	CreatedByUID         uint32
+	NrMapIDs             uint32
+	MapIDs               uint64
+	Name                 [16]byte
+	Ifindex              uint32
+	GPLCompatible        uint32
+	NetnsDev             uint64
+	NetnsIno             uint64
+	NrJitedKsyms         uint32
+	NrJitedFuncLens      uint32
+	JitedKsyms           uint64
+	JitedFuncLens        uint64
+	BTFID                uint32
+	FuncInfoRecSize      uint32
+	FuncInfo             uint64
+	NrFuncInfo           uint32
+	NrLineInfo           uint32
+	LineInfo             uint64
+	JitedLineInfo        uint64
+	NrJitedLineInfo      uint32
+	LineInfoRecSize      uint32
+	JitedLineInfoRecSize uint32
+	NrProgTags           uint32
+	ProgTags             uint64
+	RunTimeNs            uint64
+	RunCnt               uint64
+}
+
+// ProbeStats are the run-time statistics of a program of the BPF probe,
+// as collected by the kernel when enabled with WithProbeStats.
+type ProbeStats struct {
+	Program string
+
+	// RunCount is the number of runs of the program, and RunTime their total duration.
+	RunCount uint64
+	RunTime  time.Duration
+}
+
+// AvgRunTime returns the average duration of a run of the program.
+func (s ProbeStats) AvgRunTime() time.Duration {
+	if s.RunCount == 0 {
+		return 0
+	}
+
+	return s.RunTime / time.Duration(s.RunCount)
+}
+
+// ProbeStats returns the run-time statistics of the programs of the BPF probe
+// during the last profile, if enabled with WithProbeStats.
+func (p *Profiler) ProbeStats() []ProbeStats {
+	stats := make([]ProbeStats, 0, len(p.probeStats))
+	for _, name := range []string{p.probeName, probeDWARFName, probeExecName, probeMmapName} {
+		if s, ok := p.probeStats[name]; ok {
+			stats = append(stats, *s)
+		}
+	}
+
+	return stats
+}
+
+// enableProbeStats enables the collection of the run-time statistics of the BPF programs
+// by the kernel, until the returned function is called.
+func enableProbeStats() (func(), error) {
+	attr := struct{ typ uint32 }{unix.BPF_STATS_RUN_TIME}
+	fd, _, errno := unix.Syscall(unix.SYS_BPF, unix.BPF_ENABLE_STATS, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr))
+	if errno != 0 {
+		return nil, errors.Wrap(errno, "error enabling the BPF run-time statistics")
+	}
+
+	return func() {
+		unix.Close(int(fd))
+	}, nil
+}
+
+// addProbeStats adds the run-time statistics of the programs of the probe
+// to the ones of the profile, as the probe may be reloaded meanwhile.
+func (p *Profiler) addProbeStats(bpfModule *bpf.Module) {
+	if p.probeStats == nil {
+		return
+	}
+
+	for _, name := range []string{p.samplerName(), probeExecName, probeMmapName} {
+		prog, err := bpfModule.GetProgram(name)
+		if err != nil {
+			continue
+		}
+		runCount, runTime, err := progRunStats(prog.FileDescriptor())
+		if err != nil {
+			p.logger.Debug().Err(err).Str("program", name).Msg("error reading the BPF run-time statistics")
+			continue
+		}
+		s, ok := p.probeStats[name]
+		if !ok {
+			s = &ProbeStats{Program: name}
+			p.probeStats[name] = s
+		}
+		s.RunCount += runCount
+		s.RunTime += runTime
+	}
+}
+
+// progRunStats returns the number of runs of the BPF program and their total duration.
+func progRunStats(fd int) (uint64, time.Duration, error) {
+	info := new(progInfo)
+	// The info is referenced by a pointer field, so that it's kept on the heap by the garbage collector.
+	attr := struct {
+		fd      uint32
+		infoLen uint32
+		info    unsafe.Pointer
+	}{uint32(fd), uint32(unsafe.Sizeof(*info)), unsafe.Pointer(info)}
+	_, _, errno := unix.Syscall(unix.SYS_BPF, unix.BPF_OBJ_GET_INFO_BY_FD, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr))
+	if errno != 0 {
+		return 0, 0, errno
+	}
+
+	return info.RunCnt, time.Duration(info.RunTimeNs), nil
+}
@@ -0,0 +1,16 @@
+package profile
+
+import (
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// The offsets of the run-time statistics in the bpf_prog_info struct of <linux/bpf.h>.
+func TestProgInfoLayout(t *testing.T) {
+	var info progInfo
+	assert.Equal(t, uintptr(192), unsafe.Offsetof(info.RunTimeNs))
+	assert.Equal(t, uintptr(200), unsafe.Offsetof(info.RunCnt))
+	assert.Equal(t, uintptr(208), unsafe.Sizeof(info))
+}
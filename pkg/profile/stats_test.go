@@ -0,0 +1,19 @@
+package profile_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/maxgio92/yap/pkg/profile"
+)
+
+func TestProbeStats(t *testing.T) {
+	stats := ProbeStats{Program: "sample_stack_trace", RunCount: 4, RunTime: 10 * time.Microsecond}
+	assert.Equal(t, 2500*time.Nanosecond, stats.AvgRunTime())
+	assert.Zero(t, ProbeStats{}.AvgRunTime())
+
+	// Without a profile run with the statistics enabled there are none.
+	assert.Empty(t, NewProfiler(WithProbeStats(true)).ProbeStats())
+}
@@ -0,0 +1,240 @@
+package profile
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"reflect"
+	"sync"
+	"unsafe"
+
+	bpf "github.com/aquasecurity/libbpfgo"
+	"github.com/pkg/errors"
+
+	"github.com/maxgio92/yap/pkg/procmaps"
+	"github.com/maxgio92/yap/pkg/unwind"
+)
+
+const (
+	// mapUnwindRows is the array of the rows of the unwind tables of all the objects.
+	mapUnwindRows = "unwind_rows"
+
+	// mapUnwindProcesses are the executable mappings of the processes whose
+	// user stacks are unwound with the unwind tables, by pid.
+	mapUnwindProcesses = "unwind_processes"
+
+	// mapDWARFStackTraces are the user stacks unwound with the unwind tables, by hash.
+	mapDWARFStackTraces = "dwarf_stack_traces"
+
+	// probeDWARFName is the program of the probe that samples the stack traces unwinding
+	// the user stacks with the unwind tables. It requires bpf_loop, from Linux 5.17.
+	probeDWARFName = "sample_stack_trace_dwarf"
+
+	// maxUnwindMappings is the maximum number of executable mappings of a process.
+	maxUnwindMappings = 256
+
+	// histogramFlagDWARFUserStack is the HistogramKey flag of the user stacks
+	// unwound with the unwind tables, stored in the dwarf_stack_traces map.
+	histogramFlagDWARFUserStack = 1 << 0
+)
+
+var (
+	ErrUnwindRowsFull = errors.New("unwind rows map is full")
+)
+
+// unwindRow is the value of the unwind_rows BPF map.
+// The field layout must match the unwind_row_t struct of the BPF probe.
+type unwindRow struct {
+	PC        uint64
+	CFAType   uint8
+	RBPType   uint8
+	CFAOffset int16
+	RBPOffset int16
+	_         [2]byte
+}
+
+// unwindMapping must match the unwind_mapping_t struct of the BPF probe.
+type unwindMapping struct {
+	Start uint64
+	End   uint64
+	// Bias is subtracted from the addresses of the mapping to get the ones of the table.
+	Bias     uint64
+	RowStart uint32
+	RowCount uint32
+}
+
+// unwindProcess is the value of the unwind_processes BPF map.
+// The field layout must match the unwind_process_t struct of the BPF probe.
+type unwindProcess struct {
+	Len      uint32
+	_        uint32
+	Mappings [maxUnwindMappings]unwindMapping
+}
+
+// unwindObject is the unwind table of an object loaded into the rows map.
+type unwindObject struct {
+	rowStart uint32
+	rowCount uint32
+	// progs are the executable loadable segments of the object.
+	progs []elf.ProgHeader
+	err   error
+}
+
+// unwinder loads the unwind tables of the objects mapped by the profiled processes
+// into the BPF maps, so that the probe unwinds their user stacks without frame pointers.
+// The tables are appended to the rows map once per object, and never removed.
+type unwinder struct {
+	lock     sync.Mutex
+	rows     *bpf.BPFMap
+	procs    *bpf.BPFMap
+	capacity uint32
+	next     uint32
+	objects  map[string]*unwindObject
+	loaded   map[int][]unwindMapping
+}
+
+func newUnwinder(bpfModule *bpf.Module) (*unwinder, error) {
+	rows, err := bpfModule.GetMap(mapUnwindRows)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error getting %s BPF map", mapUnwindRows)
+	}
+	procs, err := bpfModule.GetMap(mapUnwindProcesses)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error getting %s BPF map", mapUnwindProcesses)
+	}
+
+	return &unwinder{
+		rows:     rows,
+		procs:    procs,
+		capacity: rows.MaxEntries(),
+		objects:  make(map[string]*unwindObject),
+		loaded:   make(map[int][]unwindMapping),
+	}, nil
+}
+
+// update loads the unwind tables of the executable file-backed mappings of the
+// process, and its mappings, unless unchanged since the last update.
+// The objects are opened at the paths returned by objectPath.
+// The mappings whose objects have no unwind table are left out, so that their
+// frames are unwound with frame pointers.
+func (u *unwinder) update(pid int, maps procmaps.Maps, objectPath func(*procmaps.Mapping) (string, error)) error {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	mappings := make([]unwindMapping, 0)
+	for i := range maps {
+		m := &maps[i]
+		if !m.IsExecutable() || !m.IsFileBacked() {
+			continue
+		}
+		if len(mappings) == maxUnwindMappings {
+			break
+		}
+		path, err := objectPath(m)
+		if err != nil {
+			continue
+		}
+		obj := u.object(path)
+		if obj.err != nil {
+			continue
+		}
+		bias, ok := obj.bias(m)
+		if !ok {
+			continue
+		}
+		mappings = append(mappings, unwindMapping{
+			Start:    m.Start,
+			End:      m.End,
+			Bias:     bias,
+			RowStart: obj.rowStart,
+			RowCount: obj.rowCount,
+		})
+	}
+	if reflect.DeepEqual(u.loaded[pid], mappings) {
+		return nil
+	}
+
+	proc := unwindProcess{Len: uint32(len(mappings))}
+	copy(proc.Mappings[:], mappings)
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, binary.LittleEndian, &proc); err != nil {
+		return errors.Wrap(err, "error encoding the unwind mappings")
+	}
+	key := uint32(pid)
+	if err := u.procs.Update(unsafe.Pointer(&key), unsafe.Pointer(&buf.Bytes()[0])); err != nil {
+		return errors.Wrapf(err, "error updating %s BPF map", mapUnwindProcesses)
+	}
+	u.loaded[pid] = mappings
+
+	return nil
+}
+
+// object returns the unwind table of the object at the path, loading it into
+// the rows map on first use. Failed loads are remembered to not retry them.
+func (u *unwinder) object(path string) *unwindObject {
+	if obj, ok := u.objects[path]; ok {
+		return obj
+	}
+
+	obj := &unwindObject{}
+	obj.err = u.load(obj, path)
+	u.objects[path] = obj
+
+	return obj
+}
+
+func (u *unwinder) load(obj *unwindObject, path string) error {
+	file, err := elf.Open(path)
+	if err != nil {
+		return errors.Wrap(err, "error opening ELF file")
+	}
+	defer file.Close()
+
+	table, err := unwind.NewTable(file)
+	if err != nil {
+		return err
+	}
+	if uint64(u.next)+uint64(len(table)) > uint64(u.capacity) {
+		return errors.Wrap(ErrUnwindRowsFull, path)
+	}
+	for _, prog := range file.Progs {
+		if prog.Type == elf.PT_LOAD && prog.Flags&elf.PF_X != 0 {
+			obj.progs = append(obj.progs, prog.ProgHeader)
+		}
+	}
+
+	obj.rowStart = u.next
+	for _, row := range table {
+		var buf bytes.Buffer
+		value := unwindRow{
+			PC:        row.PC,
+			CFAType:   uint8(row.CFAType),
+			RBPType:   uint8(row.RBPType),
+			CFAOffset: row.CFAOffset,
+			RBPOffset: row.RBPOffset,
+		}
+		if err := binary.Write(&buf, binary.LittleEndian, &value); err != nil {
+			return errors.Wrap(err, "error encoding the unwind row")
+		}
+		key := u.next
+		if err := u.rows.Update(unsafe.Pointer(&key), unsafe.Pointer(&buf.Bytes()[0])); err != nil {
+			return errors.Wrapf(err, "error updating %s BPF map", mapUnwindRows)
+		}
+		u.next++
+	}
+	obj.rowCount = u.next - obj.rowStart
+
+	return nil
+}
+
+// bias returns the difference between the addresses of the mapping and the
+// virtual addresses of the object, from the segment of its file offset.
+func (o *unwindObject) bias(m *procmaps.Mapping) (uint64, bool) {
+	for _, prog := range o.progs {
+		if m.Offset >= prog.Off && m.Offset < prog.Off+prog.Filesz {
+			return m.Start - m.Offset + prog.Off - prog.Vaddr, true
+		}
+	}
+
+	return 0, false
+}
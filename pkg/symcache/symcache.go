@@ -1,8 +1,15 @@
 package symcache
 
 import (
-	"github.com/pkg/errors"
+	"container/list"
 	"sync"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultSize is the default maximum number of entries of a cache.
+	DefaultSize = 1 << 16
 )
 
 var (
@@ -10,33 +17,168 @@ var (
 )
 
 type addr uint64
-type name string
 
-type SymCache struct {
-	syms map[addr]name
-	lock sync.RWMutex
+// key is the key of a cache entry, that is an address in a namespace.
+type key struct {
+	ns   uint32
+	addr addr
+}
+
+type entry[V any] struct {
+	key key
+	sym V
+}
+
+// Stats are the statistics of the usage of a cache.
+type Stats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+
+	// Len is the number of entries, and Size the maximum one, or zero if unbounded.
+	Len  int
+	Size int
+}
+
+// store is the storage of a cache, shared by its namespaces.
+type store[V any] struct {
+	size       int
+	entries    map[key]*list.Element
+	lru        *list.List
+	namespaces map[string]uint32
+	stats      Stats
+	lock       sync.Mutex
 }
 
-func NewSymCache() *SymCache {
-	cache := new(SymCache)
-	cache.syms = make(map[addr]name)
+// Cache is a cache of values resolved from instruction pointer addresses,
+// bounded in size by evicting the least recently used entries.
+// It's safe for concurrent use.
+type Cache[V any] struct {
+	store *store[V]
+	ns    uint32
+}
+
+// SymCache is a cache of symbol names resolved from instruction pointer addresses.
+type SymCache = Cache[string]
+
+type config struct {
+	size int
+}
+
+type CacheOption func(c *config)
+
+// WithSize sets the maximum number of entries of the cache.
+// A size of zero or less makes the cache unbounded.
+func WithSize(size int) CacheOption {
+	return func(c *config) {
+		c.size = size
+	}
+}
+
+func NewCache[V any](opts ...CacheOption) *Cache[V] {
+	cfg := &config{size: DefaultSize}
+	for _, f := range opts {
+		f(cfg)
+	}
+	if cfg.size < 0 {
+		cfg.size = 0
+	}
+
+	cache := new(Cache[V])
+	cache.store = &store[V]{
+		size:       cfg.size,
+		entries:    make(map[key]*list.Element),
+		lru:        list.New(),
+		namespaces: map[string]uint32{"": 0},
+	}
 
 	return cache
 }
 
-func (s *SymCache) Set(sym string, ip uint64) {
-	defer s.lock.Unlock()
-	s.lock.Lock()
-	s.syms[addr(ip)] = name(sym)
+func NewSymCache(opts ...CacheOption) *SymCache {
+	return NewCache[string](opts...)
 }
 
-func (s *SymCache) Get(ip uint64) (string, error) {
-	defer s.lock.RUnlock()
-	s.lock.RLock()
-	sym, ok := s.syms[addr(ip)]
+// Namespace returns a view of the cache whose entries are separated from the ones
+// of other namespaces, so that one cache can be shared by the symbol tables of
+// many objects, whose addresses overlap. The size and statistics are shared.
+func (s *Cache[V]) Namespace(name string) *Cache[V] {
+	s.store.lock.Lock()
+	defer s.store.lock.Unlock()
+
+	ns, ok := s.store.namespaces[name]
 	if !ok {
-		return "", ErrKeyNotFound
+		ns = uint32(len(s.store.namespaces))
+		s.store.namespaces[name] = ns
+	}
+
+	return &Cache[V]{store: s.store, ns: ns}
+}
+
+func (s *Cache[V]) Set(sym V, ip uint64) {
+	st := s.store
+	defer st.lock.Unlock()
+	st.lock.Lock()
+
+	k := key{s.ns, addr(ip)}
+	if el, ok := st.entries[k]; ok {
+		el.Value.(*entry[V]).sym = sym
+		st.lru.MoveToFront(el)
+		return
+	}
+	st.entries[k] = st.lru.PushFront(&entry[V]{key: k, sym: sym})
+
+	// Evict the least recently used entries.
+	for st.size > 0 && st.lru.Len() > st.size {
+		el := st.lru.Back()
+		st.lru.Remove(el)
+		delete(st.entries, el.Value.(*entry[V]).key)
+		st.stats.Evictions++
 	}
+}
+
+func (s *Cache[V]) Get(ip uint64) (V, error) {
+	st := s.store
+	defer st.lock.Unlock()
+	st.lock.Lock()
+
+	el, ok := st.entries[key{s.ns, addr(ip)}]
+	if !ok {
+		st.stats.Misses++
+		var zero V
+		return zero, ErrKeyNotFound
+	}
+	st.stats.Hits++
+	st.lru.MoveToFront(el)
+
+	return el.Value.(*entry[V]).sym, nil
+}
+
+// Reset removes all the entries of the namespace from the cache.
+func (s *Cache[V]) Reset() {
+	st := s.store
+	defer st.lock.Unlock()
+	st.lock.Lock()
+
+	for el := st.lru.Front(); el != nil; {
+		next := el.Next()
+		if k := el.Value.(*entry[V]).key; k.ns == s.ns {
+			st.lru.Remove(el)
+			delete(st.entries, k)
+		}
+		el = next
+	}
+}
+
+// Stats returns the statistics of the cache, shared by all its namespaces.
+func (s *Cache[V]) Stats() Stats {
+	st := s.store
+	defer st.lock.Unlock()
+	st.lock.Lock()
+
+	stats := st.stats
+	stats.Len = st.lru.Len()
+	stats.Size = st.size
 
-	return string(sym), nil
+	return stats
 }
@@ -1,6 +1,7 @@
 package symcache_test
 
 import (
+	"sync"
 	"testing"
 
 	"github.com/maxgio92/yap/pkg/symcache"
@@ -33,3 +34,94 @@ func TestGet(t *testing.T) {
 		t.Fatal("TestGet did not return error")
 	}
 }
+
+func TestReset(t *testing.T) {
+	cache := symcache.NewSymCache()
+	cache.Set("foo", 1234)
+	cache.Reset()
+	if _, err := cache.Get(1234); err == nil {
+		t.Fatal("TestReset did not remove entries")
+	}
+}
+
+func TestEviction(t *testing.T) {
+	cache := symcache.NewSymCache(symcache.WithSize(2))
+	cache.Set("foo", 1)
+	cache.Set("bar", 2)
+	// Make foo the most recently used.
+	if _, err := cache.Get(1); err != nil {
+		t.Fatal(err)
+	}
+	cache.Set("baz", 3)
+
+	if _, err := cache.Get(2); err == nil {
+		t.Fatal("TestEviction did not evict the least recently used entry")
+	}
+	for _, ip := range []uint64{1, 3} {
+		if _, err := cache.Get(ip); err != nil {
+			t.Fatalf("TestEviction evicted entry %d", ip)
+		}
+	}
+
+	stats := cache.Stats()
+	if stats.Hits != 3 || stats.Misses != 1 || stats.Evictions != 1 || stats.Len != 2 || stats.Size != 2 {
+		t.Fatalf("TestEviction returned wrong stats: %+v", stats)
+	}
+}
+
+func TestUnbounded(t *testing.T) {
+	cache := symcache.NewSymCache(symcache.WithSize(0))
+	for ip := uint64(0); ip < symcache.DefaultSize+1; ip++ {
+		cache.Set("foo", ip)
+	}
+	if stats := cache.Stats(); stats.Evictions != 0 || stats.Len != symcache.DefaultSize+1 {
+		t.Fatalf("TestUnbounded returned wrong stats: %+v", stats)
+	}
+}
+
+func TestNamespace(t *testing.T) {
+	cache := symcache.NewSymCache()
+	libc := cache.Namespace("/usr/lib/libc.so.6")
+	exe := cache.Namespace("/usr/bin/myprogram")
+	libc.Set("memcpy", 1234)
+	exe.Set("main", 1234)
+
+	if name, _ := libc.Get(1234); name != "memcpy" {
+		t.Fatal("TestNamespace returned wrong value")
+	}
+	if name, _ := cache.Namespace("/usr/bin/myprogram").Get(1234); name != "main" {
+		t.Fatal("TestNamespace returned wrong value")
+	}
+	if _, err := cache.Get(1234); err == nil {
+		t.Fatal("TestNamespace returned value of another namespace")
+	}
+
+	exe.Reset()
+	if _, err := exe.Get(1234); err == nil {
+		t.Fatal("TestNamespace did not reset the namespace")
+	}
+	if _, err := libc.Get(1234); err != nil {
+		t.Fatal("TestNamespace reset another namespace")
+	}
+}
+
+func TestConcurrent(t *testing.T) {
+	cache := symcache.NewSymCache(symcache.WithSize(64))
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(ns string) {
+			defer wg.Done()
+			c := cache.Namespace(ns)
+			for ip := uint64(0); ip < 1000; ip++ {
+				c.Set(ns, ip)
+				c.Get(ip / 2)
+			}
+		}(string(rune('a' + i)))
+	}
+	wg.Wait()
+
+	if stats := cache.Stats(); stats.Len != 64 {
+		t.Fatalf("TestConcurrent returned wrong length: %d", stats.Len)
+	}
+}
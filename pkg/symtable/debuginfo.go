@@ -0,0 +1,257 @@
+package symtable
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"encoding/hex"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultDebugDir is the global directory of separate debug files.
+	DefaultDebugDir = "/usr/lib/debug"
+
+	buildIDDir     = ".build-id"
+	buildIDSuffix  = ".debug"
+	debugLinkDir   = ".debug"
+	noteNameGNU    = "GNU\x00"
+	ntGNUBuildID   = 3
+	noteHeaderSize = 12
+)
+
+var (
+	ErrBuildIDNotFound   = errors.New("build-id not found")
+	ErrDebugFileNotFound = errors.New("debug file not found")
+)
+
+// ReadBuildID returns the hex-encoded GNU build-id of the ELF file,
+// read from the .note.gnu.build-id section or from the PT_NOTE segments.
+func ReadBuildID(file *elf.File) (string, error) {
+	notes := make([]io.ReadSeeker, 0)
+	if section := file.Section(".note.gnu.build-id"); section != nil {
+		notes = append(notes, section.Open())
+	}
+	for _, prog := range file.Progs {
+		if prog.Type == elf.PT_NOTE {
+			notes = append(notes, prog.Open())
+		}
+	}
+
+	for _, r := range notes {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			continue
+		}
+		if id, ok := parseBuildIDNote(data, file.ByteOrder); ok {
+			return id, nil
+		}
+	}
+
+	return "", ErrBuildIDNotFound
+}
+
+// ReadFileBuildID returns the hex-encoded GNU build-id of the ELF file at pathname.
+func ReadFileBuildID(pathname string) (string, error) {
+	file, err := elf.Open(pathname)
+	if err != nil {
+		return "", errors.Wrap(err, "error opening ELF file")
+	}
+	defer file.Close()
+
+	return ReadBuildID(file)
+}
+
+// parseBuildIDNote looks up the GNU build-id note in the notes data.
+func parseBuildIDNote(data []byte, order binary.ByteOrder) (string, bool) {
+	for len(data) >= noteHeaderSize {
+		namesz := order.Uint32(data[0:4])
+		descsz := order.Uint32(data[4:8])
+		typ := order.Uint32(data[8:12])
+		data = data[noteHeaderSize:]
+
+		nameEnd := align4(namesz)
+		descEnd := nameEnd + align4(descsz)
+		if uint64(len(data)) < descEnd {
+			return "", false
+		}
+		name := data[:namesz]
+		desc := data[nameEnd : nameEnd+uint64(descsz)]
+		if typ == ntGNUBuildID && string(name) == noteNameGNU {
+			return hex.EncodeToString(desc), true
+		}
+		data = data[descEnd:]
+	}
+
+	return "", false
+}
+
+func align4(n uint32) uint64 {
+	return (uint64(n) + 3) &^ 3
+}
+
+// readDebugLink returns the file name and the CRC32 checksum of the
+// separate debug file, from the .gnu_debuglink section.
+func readDebugLink(file *elf.File) (string, uint32, error) {
+	section := file.Section(".gnu_debuglink")
+	if section == nil {
+		return "", 0, ErrSectionNotFound
+	}
+	data, err := section.Data()
+	if err != nil {
+		return "", 0, errors.Wrap(err, "error reading .gnu_debuglink section")
+	}
+
+	// The name is null-terminated and padded to 4 bytes, followed by the checksum.
+	end := bytes.IndexByte(data, 0)
+	if end <= 0 || uint64(len(data)) < align4(uint32(end+1))+4 {
+		return "", 0, errors.New("malformed .gnu_debuglink section")
+	}
+	off := align4(uint32(end + 1))
+
+	return string(data[:end]), file.ByteOrder.Uint32(data[off : off+4]), nil
+}
+
+// DebugFinder finds the separate debug files of ELF objects, that hold the
+// symbols and the debugging information stripped from them.
+type DebugFinder struct {
+	debugDirs   []string
+	symbolPaths []string
+}
+
+// NewDebugFinder returns a DebugFinder that searches the global debug directory
+// and the symbol paths, which are directories like the ones of build artifacts.
+func NewDebugFinder(symbolPaths ...string) *DebugFinder {
+	finder := new(DebugFinder)
+	finder.debugDirs = []string{DefaultDebugDir}
+	finder.symbolPaths = symbolPaths
+
+	return finder
+}
+
+// Find returns the path of the separate debug file for the ELF file at pathname,
+// in the mount namespace of the root directory, like /proc/PID/root, if not empty.
+// Candidates are looked up by GNU build-id in the .build-id layout of the debug
+// directories and the symbol paths, then by the .gnu_debuglink section, and by
+// the file name in the symbol paths. The debug directories are looked up in
+// the root directory first, and then in the host.
+// Candidates whose build-id, or debuglink checksum if the build-id is missing,
+// does not match the ELF file are rejected.
+func (f *DebugFinder) Find(root string, pathname string, file *elf.File) (string, error) {
+	buildID, _ := ReadBuildID(file)
+
+	debugDirs := f.debugDirs
+	if root != "" {
+		debugDirs = make([]string, 0, 2*len(f.debugDirs))
+		for _, dir := range f.debugDirs {
+			debugDirs = append(debugDirs, filepath.Join(root, dir))
+		}
+		debugDirs = append(debugDirs, f.debugDirs...)
+	}
+
+	candidates := make([]string, 0)
+	if len(buildID) > 2 {
+		for _, dirs := range [][]string{debugDirs, f.symbolPaths} {
+			for _, dir := range dirs {
+				candidates = append(candidates,
+					filepath.Join(dir, buildIDDir, buildID[:2], buildID[2:]+buildIDSuffix),
+				)
+			}
+		}
+	}
+
+	link, crc, linkErr := readDebugLink(file)
+	if linkErr == nil {
+		dir := filepath.Dir(pathname)
+		candidates = append(candidates,
+			filepath.Join(root, dir, link),
+			filepath.Join(root, dir, debugLinkDir, link),
+		)
+		for _, debugDir := range debugDirs {
+			candidates = append(candidates, filepath.Join(debugDir, dir, link))
+		}
+		for _, symbolPath := range f.symbolPaths {
+			candidates = append(candidates, filepath.Join(symbolPath, link))
+		}
+	}
+	for _, symbolPath := range f.symbolPaths {
+		candidates = append(candidates, filepath.Join(symbolPath, filepath.Base(pathname)))
+	}
+
+	for _, candidate := range candidates {
+		// Skip the file itself.
+		if same, _ := sameFile(candidate, filepath.Join(root, pathname)); same {
+			continue
+		}
+		ok, err := matchDebugFile(candidate, buildID, crc, linkErr == nil)
+		if err != nil || !ok {
+			continue
+		}
+
+		return candidate, nil
+	}
+
+	return "", ErrDebugFileNotFound
+}
+
+// matchDebugFile returns whether the candidate debug file matches the build-id,
+// or the debuglink checksum when there's no build-id to compare.
+func matchDebugFile(pathname string, buildID string, crc uint32, checkCRC bool) (bool, error) {
+	file, err := elf.Open(pathname)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	if buildID != "" {
+		id, err := ReadBuildID(file)
+		if err != nil {
+			return false, err
+		}
+		return id == buildID, nil
+	}
+	if !checkCRC {
+		return false, nil
+	}
+
+	sum, err := fileCRC32(pathname)
+	if err != nil {
+		return false, err
+	}
+
+	return sum == crc, nil
+}
+
+// fileCRC32 returns the CRC32 checksum of the file, as used by .gnu_debuglink.
+func fileCRC32(pathname string) (uint32, error) {
+	f, err := os.Open(pathname)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	h := crc32.NewIEEE()
+	if _, err = io.Copy(h, f); err != nil {
+		return 0, err
+	}
+
+	return h.Sum32(), nil
+}
+
+func sameFile(a, b string) (bool, error) {
+	fa, err := os.Stat(a)
+	if err != nil {
+		return false, err
+	}
+	fb, err := os.Stat(b)
+	if err != nil {
+		return false, err
+	}
+
+	return os.SameFile(fa, fb), nil
+}
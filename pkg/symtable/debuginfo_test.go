@@ -0,0 +1,114 @@
+package symtable_test
+
+import (
+	"debug/elf"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/maxgio92/yap/pkg/symtable"
+)
+
+func copyFile(t *testing.T, src, dst string) {
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	if _, err = io.Copy(out, in); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func openSelf(t *testing.T) (*elf.File, string) {
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := elf.Open(exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { file.Close() })
+
+	return file, exe
+}
+
+func TestReadBuildID(t *testing.T) {
+	file, _ := openSelf(t)
+	id, err := ReadBuildID(file)
+	if err != nil {
+		t.Skip("test binary has no GNU build-id")
+	}
+	assert.Regexp(t, "^[0-9a-f]+$", id)
+}
+
+func TestDebugFinderBuildID(t *testing.T) {
+	file, exe := openSelf(t)
+	id, err := ReadBuildID(file)
+	if err != nil {
+		t.Skip("test binary has no GNU build-id")
+	}
+
+	symbolPath := t.TempDir()
+	debugPath := filepath.Join(symbolPath, ".build-id", id[:2], id[2:]+".debug")
+	copyFile(t, exe, debugPath)
+
+	path, err := NewDebugFinder(symbolPath).Find("", exe, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, debugPath, path)
+}
+
+func TestDebugFinderRoot(t *testing.T) {
+	file, exe := openSelf(t)
+	id, err := ReadBuildID(file)
+	if err != nil {
+		t.Skip("test binary has no GNU build-id")
+	}
+
+	// The debug file is in the debug directory of the root, like of a container.
+	root := t.TempDir()
+	debugPath := filepath.Join(root, DefaultDebugDir, ".build-id", id[:2], id[2:]+".debug")
+	copyFile(t, exe, debugPath)
+	copyFile(t, exe, filepath.Join(root, "/usr/bin/app"))
+
+	path, err := NewDebugFinder().Find(root, "/usr/bin/app", file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, debugPath, path)
+}
+
+func TestDebugFinderBuildIDMismatch(t *testing.T) {
+	file, exe := openSelf(t)
+	id, err := ReadBuildID(file)
+	if err != nil {
+		t.Skip("test binary has no GNU build-id")
+	}
+
+	// Store a file with a different build-id in the path of the expected one.
+	other, err := elf.Open(testLibc)
+	if err != nil {
+		t.Skipf("%s not available", testLibc)
+	}
+	other.Close()
+	symbolPath := t.TempDir()
+	copyFile(t, testLibc, filepath.Join(symbolPath, ".build-id", id[:2], id[2:]+".debug"))
+	copyFile(t, testLibc, filepath.Join(symbolPath, filepath.Base(exe)))
+
+	_, err = NewDebugFinder(symbolPath).Find("", exe, file)
+	assert.ErrorIs(t, err, ErrDebugFileNotFound)
+}
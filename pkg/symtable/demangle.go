@@ -0,0 +1,75 @@
+package symtable
+
+import (
+	"strings"
+
+	"github.com/ianlancetaylor/demangle"
+	"github.com/pkg/errors"
+)
+
+// DemangleMode is how the mangled symbol names are demangled.
+type DemangleMode string
+
+const (
+	// DemangleFull demangles the names with the parameter and template argument types.
+	DemangleFull DemangleMode = "full"
+
+	// DemangleSimplified demangles the names without the parameter types, the template
+	// and generic arguments, the clone suffixes, and the hashes of the Rust names.
+	DemangleSimplified DemangleMode = "simplified"
+
+	// DemangleNone keeps the names as found in the symbol tables.
+	DemangleNone DemangleMode = "none"
+)
+
+var (
+	ErrInvalidDemangleMode = errors.New("invalid demangle mode")
+)
+
+// ParseDemangleMode returns the demangle mode of the name.
+func ParseDemangleMode(name string) (DemangleMode, error) {
+	switch mode := DemangleMode(name); mode {
+	case DemangleFull, DemangleSimplified, DemangleNone:
+		return mode, nil
+	default:
+		return "", errors.Wrap(ErrInvalidDemangleMode, name)
+	}
+}
+
+// Demangle returns the demangled name of an Itanium C++ or Rust, legacy
+// and v0, symbol. Names that are not mangled, or fail to demangle,
+// are returned as they are.
+func Demangle(name string, mode DemangleMode) string {
+	if mode == DemangleNone || !isMangled(name) {
+		return name
+	}
+
+	if mode != DemangleSimplified {
+		return demangle.Filter(name)
+	}
+
+	res := demangle.Filter(name, demangle.NoParams, demangle.NoTemplateParams, demangle.NoClones)
+	// The generic arguments of Rust v0 names are left empty.
+	return strings.ReplaceAll(res, "::<>", "")
+}
+
+// isMangled returns whether the name looks like an Itanium C++ or Rust v0 mangled name.
+// Legacy Rust names are Itanium-mangled.
+func isMangled(name string) bool {
+	return strings.HasPrefix(name, "_Z") || strings.HasPrefix(name, "_R")
+}
+
+// DemangleFrames returns a copy of the frames with the demangled function names.
+func DemangleFrames(frames []Frame, mode DemangleMode) []Frame {
+	if mode == DemangleNone {
+		return frames
+	}
+
+	res := make([]Frame, len(frames))
+	copy(res, frames)
+	for i := range res {
+		res[i].Name = Demangle(res[i].Name, mode)
+	}
+
+	return res
+}
@@ -0,0 +1,38 @@
+package symtable_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/maxgio92/yap/pkg/symtable"
+)
+
+func TestDemangle(t *testing.T) {
+	tests := []struct {
+		name       string
+		full       string
+		simplified string
+	}{
+		{"_ZNSt6vectorIiSaIiEE9push_backERKi", "std::vector<int, std::allocator<int> >::push_back(int const&)", "std::vector::push_back"},
+		{"_ZN3foo3barIiEEvT_.cold", "void foo::bar<int>(int) [clone .cold]", "foo::bar"},
+		{"_ZN4core3fmt9Formatter3pad17h0f8e9a9c3f2d7b1aE", "core::fmt::Formatter::pad", "core::fmt::Formatter::pad"},
+		{"_RINvNtCs9ltgdHTiPiY_4core3ptr13drop_in_placeNtNtCs1_5alloc6string6StringEB5_", "core::ptr::drop_in_place::<alloc::string::String>", "core::ptr::drop_in_place"},
+		{"do_syscall_64", "do_syscall_64", "do_syscall_64"},
+		{"_Zinvalid", "_Zinvalid", "_Zinvalid"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.full, Demangle(tt.name, DemangleFull))
+		assert.Equal(t, tt.simplified, Demangle(tt.name, DemangleSimplified))
+		assert.Equal(t, tt.name, Demangle(tt.name, DemangleNone))
+	}
+}
+
+func TestParseDemangleMode(t *testing.T) {
+	mode, err := ParseDemangleMode("simplified")
+	assert.NoError(t, err)
+	assert.Equal(t, DemangleSimplified, mode)
+
+	_, err = ParseDemangleMode("pretty")
+	assert.ErrorIs(t, err, ErrInvalidDemangleMode)
+}
@@ -0,0 +1,287 @@
+package symtable
+
+import (
+	"debug/dwarf"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// maxOriginDepth limits the chain of abstract origin and specification
+// references followed to resolve a function name.
+const maxOriginDepth = 8
+
+// lineRow is a row of the DWARF line number table.
+type lineRow struct {
+	addr uint64
+	file string
+	line int
+	// end marks the first address after the end of a sequence.
+	end bool
+}
+
+// dwarfInline is an inlined function instance.
+type dwarfInline struct {
+	ranges   [][2]uint64
+	depth    int
+	origin   dwarf.Offset
+	callFile string
+	callLine int
+}
+
+// dwarfFunc is a concrete, out-of-line, function instance
+// and the functions inlined into it.
+type dwarfFunc struct {
+	origin  dwarf.Offset
+	inlines []dwarfInline
+}
+
+// funcRange is an address range of a dwarfFunc.
+type funcRange struct {
+	low  uint64
+	high uint64
+	fn   int
+}
+
+// dwarfTable resolves addresses to source locations and inlined frames,
+// with the DWARF debugging information of an ELF object.
+type dwarfTable struct {
+	lines  []lineRow
+	funcs  []dwarfFunc
+	ranges []funcRange
+	names  map[dwarf.Offset]string
+}
+
+// newDWARFTable builds the line and function indexes from the DWARF data.
+func newDWARFTable(d *dwarf.Data) (*dwarfTable, error) {
+	t := &dwarfTable{
+		lines:  make([]lineRow, 0),
+		funcs:  make([]dwarfFunc, 0),
+		ranges: make([]funcRange, 0),
+		names:  make(map[dwarf.Offset]string),
+	}
+	// refs are references from unnamed entries to the entries holding their names.
+	refs := make(map[dwarf.Offset]dwarf.Offset)
+
+	var (
+		files []*dwarf.LineFile
+		depth int
+		// fn is the index of the function whose children are being read, or -1.
+		fn      = -1
+		fnDepth int
+	)
+	r := d.Reader()
+	for {
+		e, err := r.Next()
+		if err != nil {
+			return nil, errors.Wrap(err, "error reading DWARF entry")
+		}
+		if e == nil {
+			break
+		}
+		if e.Tag == 0 {
+			depth--
+			if fn >= 0 && depth <= fnDepth {
+				fn = -1
+			}
+			continue
+		}
+
+		switch e.Tag {
+		case dwarf.TagCompileUnit:
+			files = nil
+			lr, err := d.LineReader(e)
+			if err != nil {
+				return nil, errors.Wrap(err, "error reading DWARF line table")
+			}
+			if lr != nil {
+				t.readLines(lr)
+				files = lr.Files()
+			}
+		case dwarf.TagSubprogram:
+			if name := entryName(e); name != "" {
+				t.names[e.Offset] = name
+			} else if ref := entryRef(e); ref != 0 {
+				refs[e.Offset] = ref
+			}
+			if fn >= 0 {
+				break
+			}
+			ranges, err := d.Ranges(e)
+			if err != nil || len(ranges) == 0 {
+				break
+			}
+			t.funcs = append(t.funcs, dwarfFunc{origin: e.Offset})
+			for _, rng := range ranges {
+				t.ranges = append(t.ranges, funcRange{low: rng[0], high: rng[1], fn: len(t.funcs) - 1})
+			}
+			// Read the inlined functions among the children.
+			if e.Children {
+				fn, fnDepth = len(t.funcs)-1, depth
+			}
+		case dwarf.TagInlinedSubroutine:
+			if fn < 0 {
+				break
+			}
+			ranges, err := d.Ranges(e)
+			if err != nil || len(ranges) == 0 {
+				break
+			}
+			inline := dwarfInline{ranges: ranges, depth: depth, origin: entryRef(e)}
+			if idx, ok := e.Val(dwarf.AttrCallFile).(int64); ok && idx >= 0 && int(idx) < len(files) && files[idx] != nil {
+				inline.callFile = files[idx].Name
+			}
+			if line, ok := e.Val(dwarf.AttrCallLine).(int64); ok {
+				inline.callLine = int(line)
+			}
+			t.funcs[fn].inlines = append(t.funcs[fn].inlines, inline)
+		}
+
+		if e.Children {
+			depth++
+		}
+	}
+
+	// Resolve the names of the entries that refer to other entries.
+	for off, ref := range refs {
+		for i := 0; i < maxOriginDepth; i++ {
+			if name, ok := t.names[ref]; ok {
+				t.names[off] = name
+				break
+			}
+			next, ok := refs[ref]
+			if !ok {
+				break
+			}
+			ref = next
+		}
+	}
+
+	sortLines(t.lines)
+	sort.Slice(t.ranges, func(i, j int) bool {
+		return t.ranges[i].low < t.ranges[j].low
+	})
+
+	return t, nil
+}
+
+// readLines appends the rows of a compilation unit line table.
+func (t *dwarfTable) readLines(lr *dwarf.LineReader) {
+	var le dwarf.LineEntry
+	for lr.Next(&le) == nil {
+		row := lineRow{addr: le.Address, line: le.Line, end: le.EndSequence}
+		if le.File != nil {
+			row.file = le.File.Name
+		}
+		t.lines = append(t.lines, row)
+	}
+}
+
+// sortLines sorts the line table rows by address. At the same address, the rows
+// ending the previous sequence come first, so that lookups prefer the rows starting
+// the next one, and the rows of a sequence are kept in order.
+func sortLines(lines []lineRow) {
+	sort.SliceStable(lines, func(i, j int) bool {
+		if lines[i].addr == lines[j].addr {
+			return lines[i].end && !lines[j].end
+		}
+		return lines[i].addr < lines[j].addr
+	})
+}
+
+// lookupLine returns the source file and line of an address.
+func (t *dwarfTable) lookupLine(addr uint64) (string, int, bool) {
+	i := sort.Search(len(t.lines), func(i int) bool {
+		return t.lines[i].addr > addr
+	})
+	if i == 0 || t.lines[i-1].end {
+		return "", 0, false
+	}
+	row := t.lines[i-1]
+
+	return row.file, row.line, true
+}
+
+// lookupFunc returns the function that contains an address.
+func (t *dwarfTable) lookupFunc(addr uint64) *dwarfFunc {
+	i := sort.Search(len(t.ranges), func(i int) bool {
+		return t.ranges[i].low > addr
+	})
+	if i == 0 || addr >= t.ranges[i-1].high {
+		return nil
+	}
+
+	return &t.funcs[t.ranges[i-1].fn]
+}
+
+// lookup returns the logical frames of an address, innermost first.
+// The last frame is the out-of-line function that contains the address, and
+// the preceding ones are the functions inlined into it that contain the address.
+// The returned name of the out-of-line function is empty if it's unknown.
+func (t *dwarfTable) lookup(addr uint64) []Frame {
+	file, line, _ := t.lookupLine(addr)
+
+	fn := t.lookupFunc(addr)
+	if fn == nil {
+		return []Frame{{File: file, Line: line}}
+	}
+
+	// Collect the inlined functions that contain the address, innermost first.
+	chain := make([]*dwarfInline, 0)
+	for i := range fn.inlines {
+		for _, rng := range fn.inlines[i].ranges {
+			if addr >= rng[0] && addr < rng[1] {
+				chain = append(chain, &fn.inlines[i])
+				break
+			}
+		}
+	}
+	sort.SliceStable(chain, func(i, j int) bool {
+		return chain[i].depth > chain[j].depth
+	})
+
+	frames := make([]Frame, 0, len(chain)+1)
+	for _, inline := range chain {
+		frames = append(frames, Frame{
+			Symbol:  Symbol{Name: t.names[inline.origin], Source: SourceDWARF},
+			File:    file,
+			Line:    line,
+			Inlined: true,
+		})
+		// The location of the caller is the call site of the inlined function.
+		file, line = inline.callFile, inline.callLine
+	}
+	frames = append(frames, Frame{
+		Symbol: Symbol{Name: t.names[fn.origin], Source: SourceDWARF},
+		File:   file,
+		Line:   line,
+	})
+
+	return frames
+}
+
+// entryName returns the name of a DWARF entry, preferring the linkage name
+// to match the ELF symbol names.
+func entryName(e *dwarf.Entry) string {
+	if name, ok := e.Val(dwarf.AttrLinkageName).(string); ok {
+		return name
+	}
+	if name, ok := e.Val(dwarf.AttrName).(string); ok {
+		return name
+	}
+
+	return ""
+}
+
+// entryRef returns the offset of the entry that holds the name of a DWARF entry,
+// like the abstract origin of inlined functions or the declaration of methods.
+func entryRef(e *dwarf.Entry) dwarf.Offset {
+	if ref, ok := e.Val(dwarf.AttrAbstractOrigin).(dwarf.Offset); ok {
+		return ref
+	}
+	if ref, ok := e.Val(dwarf.AttrSpecification).(dwarf.Offset); ok {
+		return ref
+	}
+
+	return 0
+}
@@ -0,0 +1,37 @@
+package symtable
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLookupLineAdjacentSequences(t *testing.T) {
+	// The second sequence starts where the first one ends, like at the
+	// boundary of two compilation units.
+	table := &dwarfTable{lines: []lineRow{
+		{addr: 0x200, file: "b.c", line: 7},
+		{addr: 0x300, end: true},
+		{addr: 0x100, file: "a.c", line: 1},
+		{addr: 0x200, end: true},
+	}}
+	sortLines(table.lines)
+
+	for addr, expected := range map[uint64]struct {
+		file string
+		line int
+		ok   bool
+	}{
+		0x0ff: {"", 0, false},
+		0x100: {"a.c", 1, true},
+		0x1ff: {"a.c", 1, true},
+		0x200: {"b.c", 7, true},
+		0x210: {"b.c", 7, true},
+		0x300: {"", 0, false},
+	} {
+		file, line, ok := table.lookupLine(addr)
+		assert.Equal(t, expected.file, file, "%#x", addr)
+		assert.Equal(t, expected.line, line, "%#x", addr)
+		assert.Equal(t, expected.ok, ok, "%#x", addr)
+	}
+}
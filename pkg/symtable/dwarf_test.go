@@ -0,0 +1,77 @@
+package symtable_test
+
+import (
+	"debug/elf"
+	"os"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/maxgio92/yap/pkg/symtable"
+)
+
+// returnPCs returns the return addresses of the calling frames.
+// The Go runtime reports a separate address for each inlined frame.
+//
+//go:noinline
+func returnPCs() []uintptr {
+	pcs := make([]uintptr, 2)
+	runtime.Callers(2, pcs)
+
+	return pcs
+}
+
+// callerPCs is expected to be inlined into its callers.
+func callerPCs() []uintptr {
+	return returnPCs()
+}
+
+func TestProcSymTabGetFramesInlined(t *testing.T) {
+	exe, err := elf.Open("/proc/self/exe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer exe.Close()
+	if _, err = exe.DWARF(); err != nil {
+		t.Skip("test binary has no DWARF debugging information")
+	}
+
+	pcs := callerPCs()
+
+	// The Go runtime expands inlined frames with the same semantics.
+	want := make([]runtime.Frame, 0)
+	it := runtime.CallersFrames(pcs)
+	for {
+		frame, more := it.Next()
+		want = append(want, frame)
+		if !more {
+			break
+		}
+	}
+	if len(want) < 2 || want[0].Func != nil {
+		t.Skip("callerPCs has not been inlined")
+	}
+	pc := pcs[0]
+
+	tab := NewProcSymTab(os.Getpid())
+	if err := tab.Load(); err != nil {
+		t.Fatal(err)
+	}
+	// The return address belongs to the call instruction of the previous one.
+	frames, err := tab.GetFrames(uint64(pc) - 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !assert.Len(t, frames, len(want)) {
+		t.FailNow()
+	}
+	for i := range want {
+		assert.Equal(t, want[i].Function, frames[i].Name)
+		assert.Equal(t, want[i].File, frames[i].File)
+		assert.Equal(t, want[i].Line, frames[i].Line)
+		assert.Equal(t, i < len(want)-1, frames[i].Inlined)
+	}
+	assert.Equal(t, SourceDWARF, frames[0].Source)
+}
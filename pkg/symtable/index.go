@@ -0,0 +1,269 @@
+package symtable
+
+import (
+	"cmp"
+	"debug/elf"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// symRange is an address range resolved to a symbol name.
+type symRange struct {
+	start uint64
+	end   uint64
+	name  string
+	// value is the address of the symbol, that may precede the range start.
+	value uint64
+}
+
+// indexSym is the subset of the ELF symbol fields needed to build a symIndex.
+type indexSym struct {
+	value   uint64
+	size    uint64
+	name    string
+	info    uint8
+	section elf.SectionIndex
+}
+
+// sectionEnds are the end addresses of the executable sections of an ELF file.
+type sectionEnds map[elf.SectionIndex]uint64
+
+func newSectionEnds(file *elf.File) sectionEnds {
+	ends := make(sectionEnds)
+	for i, section := range file.Sections {
+		if section.Flags&elf.SHF_EXECINSTR != 0 {
+			ends[elf.SectionIndex(i)] = section.Addr + section.Size
+		}
+	}
+
+	return ends
+}
+
+// symIndex is an index of non-overlapping address ranges of symbols,
+// sorted by address, to look up symbols with a binary search.
+//
+// Overlapping symbols are flattened so that each address resolves to the
+// innermost symbol that contains it, that is the one starting last, or the
+// smallest among the ones starting at the same address.
+// Among aliases, that are symbols with the same address range, functions are
+// preferred over other types, then the names with less leading underscores,
+// like the public names of C library functions, then global symbols over weak
+// and local ones. Ties are broken by name, for the lookups to be deterministic.
+//
+// Symbols without size, like the ones of assembly routines, are resolved as
+// a fallback for the addresses that don't belong to any sized symbol, up to
+// the next symbol in the same executable section, or the end of the section.
+type symIndex struct {
+	ranges   []symRange
+	fallback []symRange
+}
+
+// newSymIndex builds the index of the symbols that can be resolved from
+// instruction pointers, that are the ones defined. The sections are the
+// executable sections symbols without size are bounded by.
+func newSymIndex(syms []elf.Symbol, sections sectionEnds) *symIndex {
+	candidates := make([]indexSym, 0, len(syms))
+	sizeless := make([]indexSym, 0)
+	for _, s := range syms {
+		if !indexable(s) {
+			continue
+		}
+		sym := indexSym{s.Value, s.Size, s.Name, s.Info, s.Section}
+		if s.Size == 0 {
+			if _, ok := sections[s.Section]; ok && isCode(s) {
+				sizeless = append(sizeless, sym)
+			}
+			continue
+		}
+		candidates = append(candidates, sym)
+	}
+	slices.SortFunc(candidates, func(a, b indexSym) int {
+		if a.value != b.value {
+			return cmp.Compare(a.value, b.value)
+		}
+		// Outer symbols first, to be pushed below the inner ones.
+		if a.size != b.size {
+			return cmp.Compare(b.size, a.size)
+		}
+		if preferAlias(a, b) {
+			return -1
+		}
+		if preferAlias(b, a) {
+			return 1
+		}
+		return 0
+	})
+
+	x := &symIndex{ranges: make([]symRange, 0, len(candidates))}
+	x.fallback = newFallbackRanges(sizeless, candidates, sections)
+
+	var (
+		stack []symRange
+		cur   uint64
+	)
+	emit := func(start uint64, r symRange) {
+		if start < cur {
+			start = cur
+		}
+		if start < r.end {
+			x.ranges = append(x.ranges, symRange{start, r.end, r.name, r.value})
+			cur = r.end
+		}
+	}
+	for i, s := range candidates {
+		// Skip the aliases that are not preferred.
+		if i > 0 && s.value == candidates[i-1].value && s.size == candidates[i-1].size {
+			continue
+		}
+		r := symRange{start: s.value, end: s.value + s.size, name: s.name, value: s.value}
+
+		// Close the ranges that end before this one starts.
+		for len(stack) > 0 && stack[len(stack)-1].end <= r.start {
+			top := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			emit(cur, top)
+		}
+		// The enclosing range, if any, resolves up to the start of this one.
+		if len(stack) > 0 {
+			top := stack[len(stack)-1]
+			top.end = r.start
+			emit(cur, top)
+		}
+		if cur < r.start {
+			cur = r.start
+		}
+		stack = append(stack, r)
+	}
+	for len(stack) > 0 {
+		top := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		emit(cur, top)
+	}
+
+	return x
+}
+
+// newFallbackRanges returns the ranges of the symbols without size, sorted by address.
+// Each one extends up to the next symbol in the same section, or to the section end.
+func newFallbackRanges(sizeless []indexSym, sized []indexSym, sections sectionEnds) []symRange {
+	if len(sizeless) == 0 {
+		return nil
+	}
+	slices.SortFunc(sizeless, func(a, b indexSym) int {
+		if a.value != b.value {
+			return cmp.Compare(a.value, b.value)
+		}
+		if preferAlias(a, b) {
+			return -1
+		}
+		if preferAlias(b, a) {
+			return 1
+		}
+		return 0
+	})
+
+	// starts are the sorted start addresses of all the symbols, per section.
+	starts := make(map[elf.SectionIndex][]uint64)
+	for _, syms := range [][]indexSym{sized, sizeless} {
+		for _, s := range syms {
+			starts[s.section] = append(starts[s.section], s.value)
+		}
+	}
+	for _, addrs := range starts {
+		slices.Sort(addrs)
+	}
+
+	ranges := make([]symRange, 0, len(sizeless))
+	for i, s := range sizeless {
+		// Skip the aliases that are not preferred.
+		if i > 0 && s.value == sizeless[i-1].value {
+			continue
+		}
+		end := sections[s.section]
+		addrs := starts[s.section]
+		if j := sort.Search(len(addrs), func(j int) bool { return addrs[j] > s.value }); j < len(addrs) {
+			end = addrs[j]
+		}
+		if s.value < end {
+			ranges = append(ranges, symRange{start: s.value, end: end, name: s.name, value: s.value})
+		}
+	}
+
+	return ranges
+}
+
+// lookup returns the range of the sized symbol that contains the address.
+func (x *symIndex) lookup(addr uint64) (*symRange, bool) {
+	return lookupRange(x.ranges, addr)
+}
+
+// lookupSizeless returns the range of the nearest preceding symbol without size
+// in the same section of the address, if no other symbol precedes it.
+func (x *symIndex) lookupSizeless(addr uint64) (*symRange, bool) {
+	return lookupRange(x.fallback, addr)
+}
+
+func lookupRange(ranges []symRange, addr uint64) (*symRange, bool) {
+	i := sort.Search(len(ranges), func(i int) bool {
+		return ranges[i].end > addr
+	})
+	if i == len(ranges) || addr < ranges[i].start {
+		return nil, false
+	}
+
+	return &ranges[i], true
+}
+
+// indexable returns whether the symbol is defined and can contain code.
+func indexable(s elf.Symbol) bool {
+	if s.Section == elf.SHN_UNDEF || s.Name == "" {
+		return false
+	}
+	switch elf.ST_TYPE(s.Info) {
+	case elf.STT_SECTION, elf.STT_FILE, elf.STT_TLS:
+		return false
+	}
+
+	return true
+}
+
+// isCode returns whether the symbol can be a function entry point.
+func isCode(s elf.Symbol) bool {
+	switch elf.ST_TYPE(s.Info) {
+	case elf.STT_FUNC, elf.STT_NOTYPE:
+		return true
+	}
+
+	return false
+}
+
+// preferAlias returns whether the symbol a is preferred over its alias b.
+func preferAlias(a, b indexSym) bool {
+	if fa, fb := elf.ST_TYPE(a.info) == elf.STT_FUNC, elf.ST_TYPE(b.info) == elf.STT_FUNC; fa != fb {
+		return fa
+	}
+	if ua, ub := leadingUnderscores(a.name), leadingUnderscores(b.name); ua != ub {
+		return ua < ub
+	}
+	if ra, rb := bindRank(a.info), bindRank(b.info); ra != rb {
+		return ra < rb
+	}
+
+	return a.name < b.name
+}
+
+func bindRank(info uint8) int {
+	switch elf.ST_BIND(info) {
+	case elf.STB_GLOBAL:
+		return 0
+	case elf.STB_WEAK:
+		return 1
+	default:
+		return 2
+	}
+}
+
+func leadingUnderscores(name string) int {
+	return len(name) - len(strings.TrimLeft(name, "_"))
+}
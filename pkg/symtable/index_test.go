@@ -0,0 +1,181 @@
+package symtable
+
+import (
+	"debug/elf"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func sym(name string, value, size uint64, bind elf.SymBind, typ elf.SymType) elf.Symbol {
+	return elf.Symbol{
+		Name:    name,
+		Value:   value,
+		Size:    size,
+		Info:    elf.ST_INFO(bind, typ),
+		Section: elf.SectionIndex(1),
+	}
+}
+
+func TestSymIndexAliases(t *testing.T) {
+	x := newSymIndex([]elf.Symbol{
+		sym("__memcpy_local", 0x1000, 0x100, elf.STB_LOCAL, elf.STT_FUNC),
+		sym("memcpy_weak", 0x1000, 0x100, elf.STB_WEAK, elf.STT_FUNC),
+		sym("memcpy", 0x1000, 0x100, elf.STB_GLOBAL, elf.STT_FUNC),
+		sym("memcpy_object", 0x1000, 0x100, elf.STB_GLOBAL, elf.STT_OBJECT),
+		sym("__getpid", 0x1800, 0x100, elf.STB_GLOBAL, elf.STT_FUNC),
+		sym("getpid", 0x1800, 0x100, elf.STB_WEAK, elf.STT_FUNC),
+		sym("b", 0x2000, 0x100, elf.STB_GLOBAL, elf.STT_FUNC),
+		sym("a", 0x2000, 0x100, elf.STB_GLOBAL, elf.STT_FUNC),
+	}, nil)
+
+	for addr, want := range map[uint64]string{
+		0x1010: "memcpy",
+		0x1810: "getpid",
+		0x2010: "a",
+	} {
+		r, ok := x.lookup(addr)
+		assert.True(t, ok)
+		assert.Equal(t, want, r.name)
+	}
+}
+
+func TestSymIndexOverlaps(t *testing.T) {
+	x := newSymIndex([]elf.Symbol{
+		sym("outer", 0x1000, 0x1000, elf.STB_GLOBAL, elf.STT_FUNC),
+		sym("inner", 0x1100, 0x100, elf.STB_LOCAL, elf.STT_FUNC),
+		sym("inner2", 0x1800, 0x100, elf.STB_LOCAL, elf.STT_FUNC),
+		sym("partial", 0x1f00, 0x200, elf.STB_GLOBAL, elf.STT_FUNC),
+		sym("section", 0x3000, 0x100, elf.STB_LOCAL, elf.STT_SECTION),
+		sym("sizeless", 0x4000, 0, elf.STB_GLOBAL, elf.STT_FUNC),
+	}, nil)
+
+	for addr, want := range map[uint64]string{
+		0x1000: "outer",
+		0x1100: "inner",
+		0x11ff: "inner",
+		0x1200: "outer",
+		0x1850: "inner2",
+		0x1900: "outer",
+		0x1f00: "partial",
+		0x2000: "partial",
+		0x2100: "",
+		0x3000: "",
+		0x4000: "",
+		0x0fff: "",
+	} {
+		var name string
+		if r, ok := x.lookup(addr); ok {
+			name = r.name
+		}
+		assert.Equal(t, want, name, fmt.Sprintf("%#x", addr))
+	}
+}
+
+func TestSymIndexUndefined(t *testing.T) {
+	undefined := sym("undefined", 0x1000, 0x100, elf.STB_GLOBAL, elf.STT_FUNC)
+	undefined.Section = elf.SHN_UNDEF
+
+	x := newSymIndex([]elf.Symbol{undefined}, nil)
+	_, ok := x.lookup(0x1000)
+	assert.False(t, ok)
+}
+
+func TestSymIndexSizeless(t *testing.T) {
+	text := sym("memcpy", 0x1000, 0, elf.STB_GLOBAL, elf.STT_FUNC)
+	label := sym(".Lloop", 0x1040, 0, elf.STB_LOCAL, elf.STT_NOTYPE)
+	sized := sym("sized", 0x1100, 0x10, elf.STB_GLOBAL, elf.STT_FUNC)
+	last := sym("last", 0x1200, 0, elf.STB_GLOBAL, elf.STT_FUNC)
+	data := sym("data", 0x2000, 0, elf.STB_GLOBAL, elf.STT_OBJECT)
+	data.Section = 2
+	x := newSymIndex([]elf.Symbol{text, label, sized, last, data}, sectionEnds{1: 0x1300})
+
+	for addr, want := range map[uint64]struct {
+		name   string
+		offset uint64
+	}{
+		0x1000: {"memcpy", 0},
+		0x101a: {"memcpy", 0x1a},
+		0x1050: {".Lloop", 0x10},
+		// Padding after a sized symbol is not resolved to it nor to preceding sizeless ones.
+		0x1110: {"", 0},
+		0x12ff: {"last", 0xff},
+		0x1300: {"", 0},
+		0x2000: {"", 0},
+	} {
+		r, ok := x.lookup(addr)
+		if !ok {
+			r, ok = x.lookupSizeless(addr)
+		}
+		if want.name == "" {
+			assert.False(t, ok, fmt.Sprintf("%#x", addr))
+			continue
+		}
+		assert.True(t, ok, fmt.Sprintf("%#x", addr))
+		assert.Equal(t, want.name, r.name)
+		assert.Equal(t, want.offset, addr-r.value)
+	}
+}
+
+// benchSymbols returns a symbol table as large as the ones of big C++ binaries.
+func benchSymbols(n int) []elf.Symbol {
+	syms := make([]elf.Symbol, 0, n)
+	for i := 0; i < n; i++ {
+		syms = append(syms, sym(fmt.Sprintf("fn%d", i), uint64(0x1000+i*0x40), 0x30, elf.STB_GLOBAL, elf.STT_FUNC))
+	}
+	rand.New(rand.NewSource(1)).Shuffle(len(syms), func(i, j int) {
+		syms[i], syms[j] = syms[j], syms[i]
+	})
+
+	return syms
+}
+
+func benchAddrs(n int) []uint64 {
+	r := rand.New(rand.NewSource(2))
+	addrs := make([]uint64, 1024)
+	for i := range addrs {
+		addrs[i] = uint64(0x1000 + r.Intn(n*0x40))
+	}
+
+	return addrs
+}
+
+func BenchmarkSymIndexLookup(b *testing.B) {
+	for _, n := range []int{1000, 100000, 500000} {
+		x := newSymIndex(benchSymbols(n), nil)
+		addrs := benchAddrs(n)
+		b.Run(fmt.Sprintf("symbols=%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				x.lookup(addrs[i%len(addrs)])
+			}
+		})
+	}
+}
+
+// BenchmarkLinearLookup is the baseline of the symbol lookup by scanning the symbol table.
+func BenchmarkLinearLookup(b *testing.B) {
+	for _, n := range []int{1000, 100000, 500000} {
+		syms := benchSymbols(n)
+		addrs := benchAddrs(n)
+		b.Run(fmt.Sprintf("symbols=%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				ip := addrs[i%len(addrs)]
+				for _, s := range syms {
+					if ip >= s.Value && ip < s.Value+s.Size {
+						_ = s.Name
+					}
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkNewSymIndex(b *testing.B) {
+	syms := benchSymbols(500000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		newSymIndex(syms, nil)
+	}
+}
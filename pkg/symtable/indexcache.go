@@ -0,0 +1,386 @@
+package symtable
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"debug/elf"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultIndexCacheMaxSize is the default maximum size in bytes of the index cache directory.
+	DefaultIndexCacheMaxSize = 512 << 20
+
+	indexCacheDirName = "yap/symbols"
+	indexFileSuffix   = ".idx"
+	indexMagic        = "YAPIDX"
+	indexVersion      = 1
+)
+
+var (
+	ErrIndexNotFound = errors.New("symbol index not found")
+	ErrIndexInvalid  = errors.New("invalid symbol index")
+)
+
+// DefaultIndexCacheDir returns the default directory of the index cache,
+// under the user cache directory.
+func DefaultIndexCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+
+	return filepath.Join(dir, indexCacheDirName)
+}
+
+// IndexCache is a persistent cache of the symbol indexes of ELF files, so
+// that the symbol tables of the same files are not parsed again by each run.
+// The indexes are keyed by the GNU build-id of the files, or by their device,
+// inode, modification time and size if missing, that do not depend on the
+// paths, like the /proc/PID/root ones of the processes in containers.
+// Indexes are written atomically and validated with a checksum when read,
+// so the cache directory can be shared by concurrent processes.
+// The least recently used indexes are removed when the directory
+// exceeds the maximum size.
+type IndexCache struct {
+	dir     string
+	maxSize int64
+	lock    sync.Mutex
+}
+
+type IndexCacheOption func(c *IndexCache)
+
+// WithIndexCacheMaxSize sets the maximum size in bytes of the index cache directory.
+// A size of zero or less makes the cache unbounded.
+func WithIndexCacheMaxSize(size int64) IndexCacheOption {
+	return func(c *IndexCache) {
+		c.maxSize = size
+	}
+}
+
+func NewIndexCache(dir string, opts ...IndexCacheOption) *IndexCache {
+	cache := new(IndexCache)
+	cache.dir = dir
+	cache.maxSize = DefaultIndexCacheMaxSize
+	for _, f := range opts {
+		f(cache)
+	}
+
+	return cache
+}
+
+// indexKey returns the key of the symbol index of the ELF file at pathname,
+// that includes the identity of the separate debug file, if any.
+func indexKey(pathname string, file *elf.File, debugPath string) (string, error) {
+	key, err := fileKey(pathname, file)
+	if err != nil {
+		return "", err
+	}
+	if debugPath != "" {
+		debugKey, err := fileKey(debugPath, nil)
+		if err != nil {
+			return "", err
+		}
+		key += "|debug:" + debugKey
+	}
+
+	return key, nil
+}
+
+func fileKey(pathname string, file *elf.File) (string, error) {
+	if file != nil {
+		if id, err := ReadBuildID(file); err == nil {
+			return "buildid:" + id, nil
+		}
+	}
+	info, err := os.Stat(pathname)
+	if err != nil {
+		return "", errors.Wrap(err, "error reading file info")
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return "", errors.New("error reading file inode")
+	}
+
+	return fmt.Sprintf("inode:%d:%d:%d:%d", st.Dev, st.Ino, info.ModTime().UnixNano(), info.Size()), nil
+}
+
+func (c *IndexCache) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:])+indexFileSuffix)
+}
+
+// Get returns the symbol indexes stored with the key.
+// Invalid indexes, like the ones partially written or corrupted, are removed.
+func (c *IndexCache) Get(key string) ([]sourceSymTab, error) {
+	path := c.path(key)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, ErrIndexNotFound
+	}
+	symtabs, err := decodeIndex(data, key)
+	if err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	// Track the usage for the eviction of the least recently used indexes.
+	now := time.Now()
+	_ = os.Chtimes(path, now, now)
+
+	return symtabs, nil
+}
+
+// Put stores the symbol indexes with the key, and trims the cache to its maximum size.
+func (c *IndexCache) Put(key string, symtabs []sourceSymTab) error {
+	if err := os.MkdirAll(c.dir, 0o755); err != nil {
+		return errors.Wrap(err, "error creating the index cache directory")
+	}
+
+	// Write to a temporary file and rename it, so that concurrent
+	// processes never see a partially written index.
+	tmp, err := os.CreateTemp(c.dir, "index.*.tmp")
+	if err != nil {
+		return errors.Wrap(err, "error creating the index file")
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	if err = encodeIndex(w, key, symtabs); err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "error writing the index file")
+	}
+	if err = tmp.Close(); err != nil {
+		return errors.Wrap(err, "error writing the index file")
+	}
+	if err = os.Rename(tmp.Name(), c.path(key)); err != nil {
+		return errors.Wrap(err, "error writing the index file")
+	}
+
+	return c.trim()
+}
+
+// trim removes the least recently used indexes until the cache directory
+// doesn't exceed the maximum size.
+func (c *IndexCache) trim() error {
+	if c.maxSize <= 0 {
+		return nil
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return errors.Wrap(err, "error reading the index cache directory")
+	}
+	files := make([]os.FileInfo, 0, len(entries))
+	var total int64
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), indexFileSuffix) {
+			continue
+		}
+		// Indexes may have been removed meanwhile by other processes.
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, info)
+		total += info.Size()
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ModTime().Before(files[j].ModTime())
+	})
+	for _, info := range files {
+		if total <= c.maxSize {
+			break
+		}
+		if err := os.Remove(filepath.Join(c.dir, info.Name())); err != nil && !os.IsNotExist(err) {
+			return errors.Wrap(err, "error removing the index file")
+		}
+		total -= info.Size()
+	}
+
+	return nil
+}
+
+// encodeIndex writes the symbol indexes in the format:
+// magic, version, key, string table, symbol tables, CRC32 of the preceding data.
+// Integers are little-endian and strings are prefixed by their length.
+func encodeIndex(w io.Writer, key string, symtabs []sourceSymTab) error {
+	h := crc32.NewIEEE()
+	e := &indexEncoder{w: io.MultiWriter(w, h)}
+
+	// Symbol names are shared by the ranges of overlapping symbols.
+	strs := make([]string, 0)
+	ids := make(map[string]uint32)
+	for _, tab := range symtabs {
+		for _, ranges := range [][]symRange{tab.index.ranges, tab.index.fallback} {
+			for _, r := range ranges {
+				if _, ok := ids[r.name]; !ok {
+					ids[r.name] = uint32(len(strs))
+					strs = append(strs, r.name)
+				}
+			}
+		}
+	}
+
+	e.bytes([]byte(indexMagic))
+	e.uint32(indexVersion)
+	e.string(key)
+	e.uint32(uint32(len(strs)))
+	for _, s := range strs {
+		e.string(s)
+	}
+	e.uint32(uint32(len(symtabs)))
+	for _, tab := range symtabs {
+		e.string(string(tab.source))
+		for _, ranges := range [][]symRange{tab.index.ranges, tab.index.fallback} {
+			e.uint32(uint32(len(ranges)))
+			for _, r := range ranges {
+				e.uint64(r.start)
+				e.uint64(r.end)
+				e.uint64(r.value)
+				e.uint32(ids[r.name])
+			}
+		}
+	}
+	if e.err != nil {
+		return e.err
+	}
+
+	return binary.Write(w, binary.LittleEndian, h.Sum32())
+}
+
+// decodeIndex reads the symbol indexes, and validates them against the key and the checksum.
+func decodeIndex(data []byte, key string) ([]sourceSymTab, error) {
+	if len(data) < len(indexMagic)+8 {
+		return nil, ErrIndexInvalid
+	}
+	body, sum := data[:len(data)-4], binary.LittleEndian.Uint32(data[len(data)-4:])
+	if crc32.ChecksumIEEE(body) != sum {
+		return nil, ErrIndexInvalid
+	}
+
+	d := &indexDecoder{data: body}
+	if string(d.bytes(len(indexMagic))) != indexMagic || d.uint32() != indexVersion || d.string() != key {
+		return nil, ErrIndexInvalid
+	}
+	strs := make([]string, d.count(4))
+	for i := range strs {
+		strs[i] = d.string()
+	}
+	symtabs := make([]sourceSymTab, d.count(4))
+	for i := range symtabs {
+		symtabs[i].source = Source(d.string())
+		index := new(symIndex)
+		for _, ranges := range []*[]symRange{&index.ranges, &index.fallback} {
+			*ranges = make([]symRange, d.count(28))
+			for j := range *ranges {
+				r := &(*ranges)[j]
+				r.start, r.end, r.value = d.uint64(), d.uint64(), d.uint64()
+				id := d.uint32()
+				if int(id) >= len(strs) {
+					return nil, ErrIndexInvalid
+				}
+				r.name = strs[id]
+			}
+		}
+		symtabs[i].index = index
+	}
+	if d.err != nil || len(d.data) > 0 {
+		return nil, ErrIndexInvalid
+	}
+
+	return symtabs, nil
+}
+
+type indexEncoder struct {
+	w   io.Writer
+	err error
+	buf [8]byte
+}
+
+func (e *indexEncoder) bytes(b []byte) {
+	if e.err == nil {
+		_, e.err = e.w.Write(b)
+	}
+}
+
+func (e *indexEncoder) uint32(v uint32) {
+	binary.LittleEndian.PutUint32(e.buf[:4], v)
+	e.bytes(e.buf[:4])
+}
+
+func (e *indexEncoder) uint64(v uint64) {
+	binary.LittleEndian.PutUint64(e.buf[:8], v)
+	e.bytes(e.buf[:8])
+}
+
+func (e *indexEncoder) string(s string) {
+	e.uint32(uint32(len(s)))
+	e.bytes([]byte(s))
+}
+
+type indexDecoder struct {
+	data []byte
+	err  error
+}
+
+func (d *indexDecoder) bytes(n int) []byte {
+	if d.err != nil || n < 0 || n > len(d.data) {
+		d.err = ErrIndexInvalid
+		return nil
+	}
+	b := d.data[:n]
+	d.data = d.data[n:]
+
+	return b
+}
+
+func (d *indexDecoder) uint32() uint32 {
+	if b := d.bytes(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+
+	return 0
+}
+
+func (d *indexDecoder) uint64() uint64 {
+	if b := d.bytes(8); b != nil {
+		return binary.LittleEndian.Uint64(b)
+	}
+
+	return 0
+}
+
+func (d *indexDecoder) string() string {
+	return string(d.bytes(int(d.uint32())))
+}
+
+// count reads the number of the following items of the specified size,
+// validating it against the remaining data to not allocate past it.
+func (d *indexDecoder) count(size int) int {
+	n := int(d.uint32())
+	if n*size > len(d.data) {
+		d.err = ErrIndexInvalid
+		return 0
+	}
+
+	return n
+}
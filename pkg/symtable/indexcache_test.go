@@ -0,0 +1,182 @@
+package symtable_test
+
+import (
+	"debug/elf"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/maxgio92/yap/pkg/symtable"
+)
+
+const testLibm = "/usr/lib/x86_64-linux-gnu/libm.so.6"
+
+func indexFiles(t *testing.T, dir string) []string {
+	files, err := filepath.Glob(filepath.Join(dir, "*.idx"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return files
+}
+
+func loadWithIndexCache(t *testing.T, cache *IndexCache, pathname string) *ELFSymTab {
+	tab := NewELFSymTab(WithIndexCache(cache))
+	if err := tab.Load(pathname); err != nil {
+		t.Fatal(err)
+	}
+
+	return tab
+}
+
+func TestIndexCache(t *testing.T) {
+	if _, err := os.Stat(testLibc); err != nil {
+		t.Skipf("%s not available", testLibc)
+	}
+	dir := t.TempDir()
+	cache := NewIndexCache(dir)
+
+	tab := loadWithIndexCache(t, cache, testLibc)
+	files := indexFiles(t, dir)
+	if !assert.Len(t, files, 1) {
+		return
+	}
+
+	// Loading again reads the index from the cache, and marks it as used.
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(files[0], old, old); err != nil {
+		t.Fatal(err)
+	}
+	cached := loadWithIndexCache(t, cache, testLibc)
+	info, err := os.Stat(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, info.ModTime().After(old))
+
+	// The cached index resolves the addresses as the one built from the file.
+	for addr := uint64(0); addr < 0x200000; addr += 0x100 {
+		name, _ := tab.GetName(addr)
+		cachedName, _ := cached.GetName(addr)
+		assert.Equal(t, name, cachedName)
+	}
+}
+
+// withoutBuildID returns the path of a copy of the test binary, without the GNU build-id.
+func withoutBuildID(t *testing.T) string {
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := elf.Open(exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	// Change the type of the build-id note, at the offset of the note header.
+	if section := file.Section(".note.gnu.build-id"); section != nil {
+		file.ByteOrder.PutUint32(data[section.Offset+8:], 0)
+	}
+	path := filepath.Join(t.TempDir(), "exe")
+	if err = os.WriteFile(path, data, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ReadFileBuildID(path); err == nil {
+		t.Fatalf("%s has a build-id", path)
+	}
+
+	return path
+}
+
+func TestIndexCacheNoBuildID(t *testing.T) {
+	exe := withoutBuildID(t)
+	link := filepath.Join(t.TempDir(), "exe")
+	if err := os.Symlink(exe, link); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	cache := NewIndexCache(dir)
+
+	// The same file at a different path, like in a process root, has the same index.
+	loadWithIndexCache(t, cache, exe)
+	loadWithIndexCache(t, cache, link)
+	assert.Len(t, indexFiles(t, dir), 1)
+}
+
+func TestIndexCacheInvalid(t *testing.T) {
+	if _, err := os.Stat(testLibc); err != nil {
+		t.Skipf("%s not available", testLibc)
+	}
+	dir := t.TempDir()
+	cache := NewIndexCache(dir)
+
+	loadWithIndexCache(t, cache, testLibc)
+	files := indexFiles(t, dir)
+	if !assert.Len(t, files, 1) {
+		return
+	}
+	valid, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A corrupted index is replaced.
+	corrupted := append([]byte{}, valid...)
+	corrupted[len(corrupted)/2] ^= 0xff
+	if err = os.WriteFile(files[0], corrupted, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	loadWithIndexCache(t, cache, testLibc)
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, valid, data)
+
+	// So is a truncated one.
+	if err = os.WriteFile(files[0], valid[:len(valid)/2], 0o644); err != nil {
+		t.Fatal(err)
+	}
+	loadWithIndexCache(t, cache, testLibc)
+	data, err = os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, valid, data)
+}
+
+func TestIndexCacheTrim(t *testing.T) {
+	for _, path := range []string{testLibc, testLibm} {
+		if _, err := os.Stat(path); err != nil {
+			t.Skipf("%s not available", path)
+		}
+	}
+	dir := t.TempDir()
+
+	loadWithIndexCache(t, NewIndexCache(dir), testLibc)
+	files := indexFiles(t, dir)
+	if !assert.Len(t, files, 1) {
+		return
+	}
+	info, err := os.Stat(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-time.Hour)
+	if err = os.Chtimes(files[0], old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	// The least recently used index is removed to make room for the new one.
+	loadWithIndexCache(t, NewIndexCache(dir, WithIndexCacheMaxSize(info.Size())), testLibm)
+	_, err = os.Stat(files[0])
+	assert.True(t, os.IsNotExist(err))
+	assert.LessOrEqual(t, len(indexFiles(t, dir)), 1)
+}
@@ -0,0 +1,389 @@
+package symtable
+
+import (
+	"bufio"
+	"bytes"
+	"container/heap"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/maxgio92/yap/pkg/procmaps"
+	"github.com/maxgio92/yap/pkg/symcache"
+)
+
+const (
+	SourcePerfMap Source = "perfmap"
+	SourceJitDump Source = "jitdump"
+
+	// jitReloadInterval is the minimum interval between checks for changes of the JIT symbol files.
+	jitReloadInterval = time.Second
+
+	jitDumpMagic        = 0x4A695444
+	jitDumpHeaderSize   = 40
+	jitDumpRecordHeader = 16
+	jitCodeLoad         = 0
+	jitCodeMove         = 1
+)
+
+var (
+	ErrMalformedJitDump = errors.New("malformed jitdump file")
+)
+
+// PerfMapPath returns the path of the perf map file where JIT runtimes
+// publish the symbols of the process with the specified ID.
+func PerfMapPath(pid int) string {
+	return fmt.Sprintf("/tmp/perf-%d.map", pid)
+}
+
+// jitDumpPaths returns the paths of the jitdump files mapped by the process.
+// JIT runtimes map them to be discovered by profilers.
+func jitDumpPaths(maps procmaps.Maps) []string {
+	paths := make([]string, 0)
+	seen := make(map[string]struct{})
+	for _, m := range maps {
+		base := filepath.Base(m.Pathname)
+		if !strings.HasPrefix(base, "jit-") || !strings.HasSuffix(base, ".dump") {
+			continue
+		}
+		if _, ok := seen[m.Pathname]; !ok {
+			seen[m.Pathname] = struct{}{}
+			paths = append(paths, m.Pathname)
+		}
+	}
+
+	return paths
+}
+
+type jitSym struct {
+	start  uint64
+	size   uint64
+	name   string
+	source Source
+}
+
+// jitRange is an address range resolved to a JIT symbol, that contains it.
+type jitRange struct {
+	start uint64
+	end   uint64
+	sym   jitSym
+}
+
+type jitFile struct {
+	path    string
+	modTime time.Time
+	size    int64
+	syms    []jitSym
+}
+
+// JITSymTab is the symbol table of the code generated at runtime by JIT
+// compilers, like the ones of the JVM, Node.js, .NET or LuaJIT, as published
+// in perf map and jitdump files. The files are reloaded when they change.
+type JITSymTab struct {
+	files   []*jitFile
+	ranges  []jitRange
+	checked time.Time
+	lock    sync.Mutex
+	cache   *symcache.Cache[[]Frame]
+}
+
+func NewJITSymTab(perfMapPath string, jitDumpPaths ...string) *JITSymTab {
+	tab := new(JITSymTab)
+	tab.files = []*jitFile{{path: perfMapPath}}
+	for _, path := range jitDumpPaths {
+		tab.files = append(tab.files, &jitFile{path: path})
+	}
+	tab.cache = symcache.NewCache[[]Frame]()
+
+	return tab
+}
+
+// Load loads the symbols from the JIT symbol files that changed since the last load.
+func (j *JITSymTab) Load() error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	_, err := j.reload()
+
+	return err
+}
+
+// reload reloads the JIT symbol files that changed, and returns whether any did.
+func (j *JITSymTab) reload() (bool, error) {
+	j.checked = time.Now()
+
+	changed := false
+	for _, f := range j.files {
+		info, err := os.Stat(f.path)
+		if err != nil {
+			continue
+		}
+		if info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+			continue
+		}
+		var syms []jitSym
+		if strings.HasSuffix(f.path, ".dump") {
+			syms, err = parseJitDump(f.path)
+		} else {
+			syms, err = parsePerfMap(f.path)
+		}
+		if err != nil {
+			continue
+		}
+		f.syms, f.modTime, f.size = syms, info.ModTime(), info.Size()
+		changed = true
+	}
+	if !changed {
+		if len(j.ranges) == 0 {
+			return false, ErrSymTableEmpty
+		}
+		return false, nil
+	}
+
+	// Later entries take precedence, as JIT runtimes can reuse code addresses.
+	syms := make([]jitSym, 0)
+	for _, f := range j.files {
+		syms = append(syms, f.syms...)
+	}
+	j.ranges = jitRanges(syms)
+	j.cache.Reset()
+
+	return true, nil
+}
+
+// GetFrames returns the frame of the JIT symbol an instruction pointer address belongs to.
+// If not found, the JIT symbol files are reloaded if they changed.
+func (j *JITSymTab) GetFrames(ip uint64) ([]Frame, error) {
+	// Try from cache.
+	if frames, err := j.cache.Get(ip); err == nil {
+		if len(frames) == 0 {
+			return nil, ErrSymbolNotFound
+		}
+		return frames, nil
+	}
+
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	sym, ok := j.lookup(ip)
+	if !ok && time.Since(j.checked) >= jitReloadInterval {
+		if changed, _ := j.reload(); changed {
+			sym, ok = j.lookup(ip)
+		}
+	}
+	if !ok {
+		j.cache.Set(nil, ip)
+		return nil, ErrSymbolNotFound
+	}
+	frames := []Frame{{Symbol: Symbol{Name: sym.name, Source: sym.source, Offset: ip - sym.start}}}
+	j.cache.Set(frames, ip)
+
+	return frames, nil
+}
+
+// Symbolize resolves the addresses of anonymous executable mappings, where JIT
+// compilers generate code into. The table is not bound to the process ID, as
+// it's built from the files of the process.
+func (j *JITSymTab) Symbolize(pid int, addr uint64, mapping *procmaps.Mapping) ([]Frame, error) {
+	if pid == KernelPID || mapping == nil || !mapping.IsExecutable() || !mapping.IsAnonymous() {
+		return nil, ErrNotHandled
+	}
+
+	return j.GetFrames(addr)
+}
+
+// lookup returns the latest JIT symbol that contains the address.
+func (j *JITSymTab) lookup(ip uint64) (jitSym, bool) {
+	i := sort.Search(len(j.ranges), func(i int) bool {
+		return j.ranges[i].start > ip
+	})
+	if i == 0 || ip >= j.ranges[i-1].end {
+		return jitSym{}, false
+	}
+
+	return j.ranges[i-1].sym, true
+}
+
+// jitRanges returns the non-overlapping address ranges of the symbols, sorted by
+// address, where the later symbols take precedence over the earlier ones they overlap.
+func jitRanges(syms []jitSym) []jitRange {
+	// The symbols by start address, and the bounds of their ranges.
+	order := make([]int, 0, len(syms))
+	bounds := make([]uint64, 0, 2*len(syms))
+	for i, s := range syms {
+		if s.size == 0 {
+			continue
+		}
+		order = append(order, i)
+		bounds = append(bounds, s.start, s.start+s.size)
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return syms[order[a]].start < syms[order[b]].start
+	})
+	sort.Slice(bounds, func(a, b int) bool {
+		return bounds[a] < bounds[b]
+	})
+
+	// Sweep the ranges between the bounds, with the symbols that contain them.
+	ranges := make([]jitRange, 0, len(order))
+	active := new(jitHeap)
+	next, last := 0, -1
+	for k := 0; k+1 < len(bounds); k++ {
+		start, end := bounds[k], bounds[k+1]
+		if start == end {
+			continue
+		}
+		for ; next < len(order) && syms[order[next]].start <= start; next++ {
+			heap.Push(active, order[next])
+		}
+		for active.Len() > 0 && syms[(*active)[0]].start+syms[(*active)[0]].size <= start {
+			heap.Pop(active)
+		}
+		if active.Len() == 0 {
+			last = -1
+			continue
+		}
+		latest := (*active)[0]
+		if latest == last {
+			ranges[len(ranges)-1].end = end
+			continue
+		}
+		ranges = append(ranges, jitRange{start: start, end: end, sym: syms[latest]})
+		last = latest
+	}
+
+	return ranges
+}
+
+// jitHeap is a heap of symbol indexes, with the latest symbol on top.
+type jitHeap []int
+
+func (h jitHeap) Len() int           { return len(h) }
+func (h jitHeap) Less(i, j int) bool { return h[i] > h[j] }
+func (h jitHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *jitHeap) Push(x any) {
+	*h = append(*h, x.(int))
+}
+
+func (h *jitHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+
+	return x
+}
+
+// parsePerfMap parses a perf map file, whose lines are in the form:
+// START SIZE symbolname
+// with START and SIZE being hexadecimal numbers.
+func parsePerfMap(path string) ([]jitSym, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "error opening perf map file")
+	}
+	defer f.Close()
+
+	syms := make([]jitSym, 0)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.SplitN(strings.TrimSpace(scanner.Text()), " ", 3)
+		if len(fields) < 3 {
+			continue
+		}
+		start, err := strconv.ParseUint(strings.TrimPrefix(fields[0], "0x"), 16, 64)
+		if err != nil {
+			continue
+		}
+		size, err := strconv.ParseUint(strings.TrimPrefix(fields[1], "0x"), 16, 64)
+		if err != nil {
+			continue
+		}
+		syms = append(syms, jitSym{start: start, size: size, name: fields[2], source: SourcePerfMap})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "error reading perf map file")
+	}
+
+	return syms, nil
+}
+
+// parseJitDump parses the code load and move records of a jitdump file.
+// The moved code is removed from its old range.
+// A truncated trailing record, that is being written by the runtime, is ignored.
+func parseJitDump(path string) ([]jitSym, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading jitdump file")
+	}
+	if len(data) < jitDumpHeaderSize {
+		return nil, ErrMalformedJitDump
+	}
+
+	var order binary.ByteOrder
+	switch {
+	case binary.LittleEndian.Uint32(data) == jitDumpMagic:
+		order = binary.LittleEndian
+	case binary.BigEndian.Uint32(data) == jitDumpMagic:
+		order = binary.BigEndian
+	default:
+		return nil, ErrMalformedJitDump
+	}
+
+	// Code moves refer to loaded code by its index.
+	byIndex := make(map[uint64]int)
+	syms := make([]jitSym, 0)
+
+	off := uint64(order.Uint32(data[8:12]))
+	for off+jitDumpRecordHeader <= uint64(len(data)) {
+		id := order.Uint32(data[off:])
+		size := uint64(order.Uint32(data[off+4:]))
+		if size < jitDumpRecordHeader || off+size > uint64(len(data)) {
+			break
+		}
+		rec := data[off+jitDumpRecordHeader : off+size]
+		off += size
+
+		switch id {
+		case jitCodeLoad:
+			// pid, tid, vma, code_addr, code_size, code_index, name.
+			if len(rec) < 40 {
+				continue
+			}
+			codeAddr := order.Uint64(rec[16:])
+			codeSize := order.Uint64(rec[24:])
+			codeIndex := order.Uint64(rec[32:])
+			name := rec[40:]
+			if end := bytes.IndexByte(name, 0); end >= 0 {
+				name = name[:end]
+			}
+			byIndex[codeIndex] = len(syms)
+			syms = append(syms, jitSym{start: codeAddr, size: codeSize, name: string(name), source: SourceJitDump})
+		case jitCodeMove:
+			// pid, tid, vma, old_code_addr, new_code_addr, code_size, code_index.
+			if len(rec) < 48 {
+				continue
+			}
+			i, ok := byIndex[order.Uint64(rec[40:])]
+			if !ok {
+				continue
+			}
+			moved := syms[i]
+			moved.start = order.Uint64(rec[24:])
+			moved.size = order.Uint64(rec[32:])
+			syms[i].size = 0
+			byIndex[order.Uint64(rec[40:])] = len(syms)
+			syms = append(syms, moved)
+		}
+	}
+
+	return syms, nil
+}
@@ -0,0 +1,214 @@
+package symtable_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/maxgio92/yap/pkg/symtable"
+)
+
+const testPerfMap = `7f0000001000 100 LazyCompile:~foo /app/index.js:10
+0x7f0000001100 0x80 Interpreter:bar
+malformed line
+7f0000002000 40 java.lang.String::hashCode
+`
+
+func TestJITSymTabPerfMap(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "perf-1.map")
+	if err := os.WriteFile(path, []byte(testPerfMap), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tab := NewJITSymTab(path)
+	if err := tab.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	frames, err := tab.GetFrames(0x7f0000001010)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "LazyCompile:~foo /app/index.js:10", frames[0].Name)
+	assert.Equal(t, SourcePerfMap, frames[0].Source)
+
+	frames, err = tab.GetFrames(0x7f0000001100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "Interpreter:bar", frames[0].Name)
+
+	_, err = tab.GetFrames(0x7f0000001180)
+	assert.ErrorIs(t, err, ErrSymbolNotFound)
+}
+
+func TestJITSymTabReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "perf-1.map")
+	if err := os.WriteFile(path, []byte("7f0000001000 100 foo\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tab := NewJITSymTab(path)
+	if err := tab.Load(); err != nil {
+		t.Fatal(err)
+	}
+	_, err := tab.GetFrames(0x7f0000003000)
+	assert.ErrorIs(t, err, ErrSymbolNotFound)
+
+	// Code is generated at a new address, and the address of foo is reused.
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintln(f, "7f0000003000 100 bar")
+	fmt.Fprintln(f, "7f0000001000 100 baz")
+	f.Close()
+
+	if err = tab.Load(); err != nil {
+		t.Fatal(err)
+	}
+	frames, err := tab.GetFrames(0x7f0000003000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "bar", frames[0].Name)
+
+	frames, err = tab.GetFrames(0x7f0000001000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "baz", frames[0].Name)
+}
+
+func TestJITSymTabOverlapping(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "perf-1.map")
+	perfMap := `7f0000001000 1000 outer
+7f0000001400 100 inner
+7f0000001000 80 recompiled
+`
+	if err := os.WriteFile(path, []byte(perfMap), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tab := NewJITSymTab(path)
+	if err := tab.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The later entries take precedence, and the earlier ones resolve the rest of their ranges.
+	for addr, name := range map[uint64]string{
+		0x7f0000001000: "recompiled",
+		0x7f000000107f: "recompiled",
+		0x7f0000001080: "outer",
+		0x7f0000001400: "inner",
+		0x7f00000014ff: "inner",
+		0x7f0000001500: "outer",
+		0x7f0000001fff: "outer",
+	} {
+		frames, err := tab.GetFrames(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, name, frames[0].Name, "%#x", addr)
+	}
+	frames, err := tab.GetFrames(0x7f0000001500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, uint64(0x500), frames[0].Offset)
+
+	_, err = tab.GetFrames(0x7f0000002000)
+	assert.ErrorIs(t, err, ErrSymbolNotFound)
+}
+
+func TestJITSymTabJitDump(t *testing.T) {
+	var buf bytes.Buffer
+	le := binary.LittleEndian
+
+	// File header.
+	binary.Write(&buf, le, []uint32{0x4A695444, 1, 40, 62, 0, 1})
+	binary.Write(&buf, le, []uint64{0, 0})
+
+	// Code load records.
+	load := func(addr, size, index uint64, name string) {
+		body := new(bytes.Buffer)
+		binary.Write(body, le, []uint32{1, 1})
+		binary.Write(body, le, []uint64{addr, addr, size, index})
+		body.WriteString(name + "\x00")
+		binary.Write(&buf, le, []uint32{0, uint32(16 + body.Len())})
+		binary.Write(&buf, le, uint64(0))
+		buf.Write(body.Bytes())
+	}
+	load(0x7f0000001000, 0x100, 1, "foo")
+	load(0x7f0000002000, 0x100, 2, "bar")
+
+	// Code move record of bar.
+	binary.Write(&buf, le, []uint32{1, 16 + 56})
+	binary.Write(&buf, le, uint64(0))
+	binary.Write(&buf, le, []uint32{1, 1})
+	binary.Write(&buf, le, []uint64{0x7f0000002000, 0x7f0000002000, 0x7f0000005000, 0x80, 2})
+
+	// Truncated record, being written.
+	binary.Write(&buf, le, []uint32{0, 200})
+
+	path := filepath.Join(t.TempDir(), "jit-1.dump")
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tab := NewJITSymTab(filepath.Join(t.TempDir(), "perf-1.map"), path)
+	if err := tab.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	frames, err := tab.GetFrames(0x7f0000001080)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "foo", frames[0].Name)
+	assert.Equal(t, SourceJitDump, frames[0].Source)
+
+	frames, err = tab.GetFrames(0x7f0000005010)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "bar", frames[0].Name)
+
+	// The old range of the moved code is not resolved anymore.
+	_, err = tab.GetFrames(0x7f0000002010)
+	assert.ErrorIs(t, err, ErrSymbolNotFound)
+}
+
+func TestProcSymTabJIT(t *testing.T) {
+	// Map anonymous executable memory, like JIT compilers do.
+	mem, err := syscall.Mmap(-1, 0, os.Getpagesize(), syscall.PROT_READ|syscall.PROT_EXEC, syscall.MAP_PRIVATE|syscall.MAP_ANON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Munmap(mem)
+	addr := uint64(uintptr(unsafe.Pointer(&mem[0])))
+
+	path := PerfMapPath(os.Getpid())
+	if err = os.WriteFile(path, []byte(fmt.Sprintf("%x 10 jitted\n", addr)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(path)
+
+	tab := NewProcSymTab(os.Getpid())
+	if err = tab.Load(); err != nil {
+		t.Fatal(err)
+	}
+	sym, err := tab.GetSymbol(addr + 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "jitted", sym.Name)
+	assert.Equal(t, SourcePerfMap, sym.Source)
+}
@@ -0,0 +1,162 @@
+package symtable
+
+import (
+	"bufio"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/maxgio92/yap/pkg/procmaps"
+	"github.com/maxgio92/yap/pkg/symcache"
+)
+
+const (
+	// DefaultKallsymsPath is the path of the kernel symbol table exported by the proc filesystem.
+	DefaultKallsymsPath = "/proc/kallsyms"
+)
+
+var (
+	ErrSymbolNotFound = errors.New("symbol not found")
+)
+
+type kallSym struct {
+	addr   uint64
+	name   string
+	module string
+}
+
+// KallSymTab is the symbol table of the running kernel, including the
+// symbols of the loaded modules and of the JITed BPF programs, as exported
+// by the kallsyms interface.
+type KallSymTab struct {
+	syms  []kallSym
+	cache *symcache.Cache[Symbol]
+}
+
+func NewKallSymTab() *KallSymTab {
+	tab := new(KallSymTab)
+	tab.syms = make([]kallSym, 0)
+	tab.cache = symcache.NewCache[Symbol]()
+
+	return tab
+}
+
+// Load loads the kernel text symbols from a file in the kallsyms format,
+// like DefaultKallsymsPath.
+func (k *KallSymTab) Load(pathname string) error {
+	// Skip load if symbols have already been loaded.
+	if len(k.syms) > 0 {
+		return nil
+	}
+
+	f, err := os.Open(pathname)
+	if err != nil {
+		return errors.Wrap(err, "error opening kallsyms file")
+	}
+	defer f.Close()
+
+	syms := make([]kallSym, 0)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Lines are in the form:
+		// ffffffffc0a01000 t foo_init	[foo]
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 {
+			continue
+		}
+		// Only text symbols are relevant to symbolize instruction pointers.
+		switch fields[1] {
+		case "t", "T", "w", "W":
+		default:
+			continue
+		}
+		addr, err := strconv.ParseUint(fields[0], 16, 64)
+		if err != nil {
+			return errors.Wrap(err, "error parsing kallsyms address")
+		}
+		// Addresses are zeroed when kptr_restrict prevents from reading them.
+		if addr == 0 {
+			continue
+		}
+		sym := kallSym{addr: addr, name: fields[2]}
+		if len(fields) > 3 {
+			sym.module = strings.Trim(fields[3], "[]")
+		}
+		syms = append(syms, sym)
+	}
+	if err := scanner.Err(); err != nil {
+		return errors.Wrap(err, "error reading kallsyms file")
+	}
+	if len(syms) == 0 {
+		return ErrSymTableEmpty
+	}
+
+	sort.SliceStable(syms, func(i, j int) bool {
+		return syms[i].addr < syms[j].addr
+	})
+	k.syms = syms
+
+	return nil
+}
+
+// GetName returns the name of the kernel symbol an instruction pointer address belongs to.
+func (k *KallSymTab) GetName(ip uint64) (string, error) {
+	sym, err := k.GetSymbol(ip)
+	if err != nil {
+		return "", err
+	}
+
+	return sym.Name, nil
+}
+
+// GetSymbol returns the kernel symbol an instruction pointer address belongs to.
+// As kallsyms does not provide symbol sizes, the nearest preceding symbol is returned.
+// Symbols of modules, including the "bpf" pseudo-module of the JITed BPF programs,
+// are suffixed with the module name in square brackets.
+func (k *KallSymTab) GetSymbol(ip uint64) (*Symbol, error) {
+	// Try from cache.
+	if sym, err := k.cache.Get(ip); err == nil {
+		return &sym, nil
+	}
+	if len(k.syms) == 0 {
+		return nil, ErrSymTableEmpty
+	}
+
+	i := sort.Search(len(k.syms), func(i int) bool {
+		return k.syms[i].addr > ip
+	})
+	if i == 0 {
+		return nil, ErrSymbolNotFound
+	}
+	s := k.syms[i-1]
+
+	sym := Symbol{Name: s.name, Source: SourceKallsyms, Offset: ip - s.addr}
+	if s.module != "" {
+		sym.Name += " [" + s.module + "]"
+	}
+	k.cache.Set(sym, ip)
+
+	return &sym, nil
+}
+
+// GetFrames returns the frame of the kernel symbol an instruction pointer address belongs to.
+func (k *KallSymTab) GetFrames(ip uint64) ([]Frame, error) {
+	sym, err := k.GetSymbol(ip)
+	if err != nil {
+		return nil, err
+	}
+
+	return []Frame{{Symbol: *sym}}, nil
+}
+
+// Symbolize resolves the kernel addresses, that are the ones of KernelPID.
+func (k *KallSymTab) Symbolize(pid int, addr uint64, _ *procmaps.Mapping) ([]Frame, error) {
+	if pid != KernelPID {
+		return nil, ErrNotHandled
+	}
+
+	return k.GetFrames(addr)
+}
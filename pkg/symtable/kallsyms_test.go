@@ -0,0 +1,66 @@
+package symtable_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/maxgio92/yap/pkg/symtable"
+)
+
+const testKallsyms = `0000000000000000 A fixed_percpu_data
+ffffffff81000000 T _stext
+ffffffff81001000 T do_syscall_64
+ffffffff81002000 t __do_sys_getpid
+ffffffff81003000 D some_data
+ffffffffc0a01000 t foo_init	[foo]
+ffffffffc0a02000 t bpf_prog_6deef7357e7b4530_sample_stack_trace	[bpf]
+`
+
+func writeKallsyms(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "kallsyms")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestKallSymTabGetName(t *testing.T) {
+	tab := NewKallSymTab()
+	if err := tab.Load(writeKallsyms(t, testKallsyms)); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[uint64]string{
+		0xffffffff81001010: "do_syscall_64",
+		0xffffffff81002000: "__do_sys_getpid",
+		0xffffffff81003010: "__do_sys_getpid",
+		0xffffffffc0a01234: "foo_init [foo]",
+		0xffffffffc0a02010: "bpf_prog_6deef7357e7b4530_sample_stack_trace [bpf]",
+	}
+	for ip, want := range tests {
+		name, err := tab.GetName(ip)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, want, name)
+	}
+
+	_, err := tab.GetName(0x1000)
+	assert.ErrorIs(t, err, ErrSymbolNotFound)
+
+	sym, err := tab.GetSymbol(0xffffffff8100101a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "do_syscall_64+0x1a", sym.NameWithOffset())
+}
+
+func TestKallSymTabRestricted(t *testing.T) {
+	tab := NewKallSymTab()
+	err := tab.Load(writeKallsyms(t, "0000000000000000 T _stext\n0000000000000000 T do_syscall_64\n"))
+	assert.ErrorIs(t, err, ErrSymTableEmpty)
+}
@@ -0,0 +1,138 @@
+package symtable
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/maxgio92/yap/pkg/procmaps"
+)
+
+var (
+	ErrObjectNotFound = errors.New("object not found")
+)
+
+// OfflineSymTab resolves the addresses of memory mappings recorded on a different
+// machine, against local copies of the mapped ELF objects.
+// The objects are looked up in the search directories by GNU build-id and by path,
+// and are rejected if their build-id does not match the recorded one.
+type OfflineSymTab struct {
+	dirs     []string
+	buildIDs map[string]string
+	objs     map[string]*ELFSymTab
+	errs     map[string]error
+	lock     sync.Mutex
+	opts     []ELFSymTabOption
+}
+
+// NewOfflineSymTab returns the symbol table of the objects recorded with the
+// build-ids, keyed by their original path, which are searched in the directories.
+// The options are applied to the symbol tables of the objects.
+func NewOfflineSymTab(dirs []string, buildIDs map[string]string, opts ...ELFSymTabOption) *OfflineSymTab {
+	tab := new(OfflineSymTab)
+	tab.dirs = dirs
+	tab.buildIDs = buildIDs
+	tab.opts = opts
+	tab.objs = make(map[string]*ELFSymTab)
+	tab.errs = make(map[string]error)
+
+	return tab
+}
+
+// FindObject returns the path of the local copy of the object recorded at pathname.
+// Candidates are looked up by build-id in the .build-id layout of the search
+// directories, by path under the directories as system roots, by file name in
+// the directories, and at the original path.
+// The vDSO is looked up by the file names of the kernel vDSO images, like
+// vdso64.so, in the directories and in the ones of the running kernel.
+// When the build-id is unknown, the first existing candidate is returned.
+func (o *OfflineSymTab) FindObject(pathname string) (string, error) {
+	buildID := o.buildIDs[pathname]
+	// The objects may have been deleted or replaced while profiled.
+	names := []string{procmaps.TrimDeleted(pathname)}
+	if pathname == procmaps.VDSOPathname {
+		names = vdsoNames
+	}
+
+	candidates := make([]string, 0)
+	for _, dir := range o.dirs {
+		if len(buildID) > 2 {
+			candidates = append(candidates, filepath.Join(dir, buildIDDir, buildID[:2], buildID[2:]))
+		}
+		for _, name := range names {
+			candidates = append(candidates,
+				filepath.Join(dir, name),
+				filepath.Join(dir, filepath.Base(name)),
+			)
+		}
+	}
+	if pathname == procmaps.VDSOPathname {
+		candidates = append(candidates, KernelVDSOPaths()...)
+	} else {
+		candidates = append(candidates, names...)
+	}
+
+	for _, candidate := range candidates {
+		if _, err := os.Stat(candidate); err != nil {
+			continue
+		}
+		if buildID == "" {
+			return candidate, nil
+		}
+		if id, err := ReadFileBuildID(candidate); err == nil && id == buildID {
+			return candidate, nil
+		}
+	}
+
+	return "", ErrObjectNotFound
+}
+
+// Symbolize resolves the addresses of the recorded file-backed mappings and of the vDSO.
+func (o *OfflineSymTab) Symbolize(pid int, addr uint64, mapping *procmaps.Mapping) ([]Frame, error) {
+	if pid == KernelPID || mapping == nil {
+		return nil, ErrNotHandled
+	}
+	if !mapping.IsExecutable() || (!mapping.IsFileBacked() && !mapping.IsVDSO()) {
+		return nil, ErrNotFileBacked
+	}
+
+	obj, err := o.getObject(mapping.Pathname)
+	if err != nil {
+		return nil, err
+	}
+
+	// Translate the recorded runtime address to the address in the object's symbol table.
+	addr, err = obj.FileOffsetToAddr(mapping.FileOffset(addr))
+	if err != nil {
+		return nil, err
+	}
+
+	return obj.GetFrames(addr)
+}
+
+// getObject returns the symbol table of the local copy of the object recorded
+// at pathname, loading it on first use. Failed loads are remembered to not retry them.
+func (o *OfflineSymTab) getObject(pathname string) (*ELFSymTab, error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	obj, ok := o.objs[pathname]
+	if !ok {
+		obj = NewELFSymTab(o.opts...)
+		path, err := o.FindObject(pathname)
+		if err == nil {
+			err = obj.Load(path)
+		}
+		if err != nil {
+			o.errs[pathname] = errors.Wrap(err, pathname)
+		}
+		o.objs[pathname] = obj
+	}
+	if err := o.errs[pathname]; err != nil {
+		return nil, err
+	}
+
+	return obj, nil
+}
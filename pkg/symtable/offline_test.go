@@ -0,0 +1,80 @@
+package symtable_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/maxgio92/yap/pkg/procmaps"
+	. "github.com/maxgio92/yap/pkg/symtable"
+)
+
+func TestOfflineSymTabFindObject(t *testing.T) {
+	for _, path := range []string{testLibc, testLibm} {
+		if _, err := os.Stat(path); err != nil {
+			t.Skipf("%s not available", path)
+		}
+	}
+	buildID, err := ReadFileBuildID(testLibc)
+	if err != nil {
+		t.Skip("libc has no build-id")
+	}
+	recorded := "/remote/lib/libc.so.6"
+
+	// The object is found by build-id.
+	dir := t.TempDir()
+	byID := filepath.Join(dir, ".build-id", buildID[:2], buildID[2:])
+	if err = os.MkdirAll(filepath.Dir(byID), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Symlink(testLibc, byID); err != nil {
+		t.Fatal(err)
+	}
+	path, err := NewOfflineSymTab([]string{dir}, map[string]string{recorded: buildID}).FindObject(recorded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, byID, path)
+
+	// Objects with a different build-id are rejected.
+	dir = t.TempDir()
+	if err = os.Symlink(testLibm, filepath.Join(dir, "libc.so.6")); err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewOfflineSymTab([]string{dir}, map[string]string{recorded: buildID}).FindObject(recorded)
+	assert.ErrorIs(t, err, ErrObjectNotFound)
+}
+
+func TestOfflineSymTabSymbolize(t *testing.T) {
+	maps, err := procmaps.Read(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ip := uint64(reflect.ValueOf(TestOfflineSymTabSymbolize).Pointer())
+	mapping, err := maps.Find(ip)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The executable is recorded at a path that doesn't exist locally,
+	// and it's found by file name in the search directory.
+	dir := t.TempDir()
+	if err = os.Symlink(mapping.Pathname, filepath.Join(dir, "app")); err != nil {
+		t.Fatal(err)
+	}
+	recorded := *mapping
+	recorded.Pathname = "/remote/bin/app"
+
+	tab := NewOfflineSymTab([]string{dir}, nil)
+	frames, err := tab.Symbolize(os.Getpid(), ip, &recorded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "github.com/maxgio92/yap/pkg/symtable_test.TestOfflineSymTabSymbolize", frames[len(frames)-1].Name)
+
+	_, err = tab.Symbolize(KernelPID, ip, nil)
+	assert.ErrorIs(t, err, ErrNotHandled)
+}
@@ -0,0 +1,586 @@
+package symtable
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"sync"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+
+	"github.com/maxgio92/yap/pkg/procmaps"
+)
+
+var (
+	ErrMapsNotLoaded = errors.New("process memory mappings are not loaded")
+	ErrNotFileBacked = errors.New("address is not in an executable file-backed mapping")
+)
+
+// ProcSymTab is the symbol table of a process address space.
+// It resolves instruction pointers against the symbol tables of the
+// ELF objects mapped by the process, i.e. the executable and the shared
+// libraries, taking into account the address each one is loaded at.
+// Instruction pointers in anonymous executable mappings are resolved
+// against the symbols published by JIT compilers.
+// The objects are opened through the root directory of the process, so that
+// the ones of processes in other mount namespaces, like containers, are resolved.
+// Snapshots of the process keep the objects open, for when the process exits
+// before being symbolized.
+type ProcSymTab struct {
+	pid     int
+	maps    procmaps.Maps
+	history *procmaps.History
+	objs    map[objectKey]*procObject
+	errs    map[objectKey]error
+	paths   map[objectKey]string
+	files   map[objectKey]*os.File
+	lock    sync.Mutex
+	opts    []ELFSymTabOption
+	jit     *JITSymTab
+
+	// vdso is the ELF image of the vDSO, read from the process memory.
+	vdso []byte
+
+	// jitDumps are the paths of the jitdump files mapped by the process.
+	jitDumps []string
+
+	// unresolved are the mappings of the objects some addresses could not be resolved in.
+	unresolved map[objectKey]procmaps.Mapping
+
+	// unavailable are the objects whose files could not be opened, like the ones
+	// mapped after the last snapshot of a process that exited.
+	unavailable map[string]struct{}
+
+	// mismatched are the objects resolved with files that could not be verified
+	// to be the mapped ones, keyed by their original paths.
+	mismatched map[string]string
+}
+
+// objectKey identifies the ELF object of a mapping by its file, as the file at
+// the pathname may be replaced while profiling, like by upgrades of the libraries
+// loaded again with dlopen.
+type objectKey struct {
+	pathname string
+	dev      string
+	inode    uint64
+}
+
+func newObjectKey(m *procmaps.Mapping) objectKey {
+	return objectKey{pathname: m.Pathname, dev: m.Dev, inode: m.Inode}
+}
+
+// procObject is the symbol table of a mapped ELF object, loaded once on first use.
+type procObject struct {
+	once sync.Once
+	tab  *ELFSymTab
+}
+
+// NewProcSymTab returns the symbol table of the process with the specified ID.
+// The options are applied to the symbol tables of the mapped ELF objects.
+func NewProcSymTab(pid int, opts ...ELFSymTabOption) *ProcSymTab {
+	tab := new(ProcSymTab)
+	tab.pid = pid
+	tab.opts = opts
+	tab.history = procmaps.NewHistory()
+	tab.objs = make(map[objectKey]*procObject)
+	tab.errs = make(map[objectKey]error)
+	tab.paths = make(map[objectKey]string)
+	tab.files = make(map[objectKey]*os.File)
+	tab.mismatched = make(map[string]string)
+	tab.unresolved = make(map[objectKey]procmaps.Mapping)
+	tab.unavailable = make(map[string]struct{})
+
+	return tab
+}
+
+// Load reads the memory mappings of the process from the proc filesystem,
+// and tracks their changes since the previous loads and snapshots.
+// The ELF objects are loaded lazily on the first address resolved in them.
+// If the process has exited, the last snapshot is used, if any.
+func (p *ProcSymTab) Load() error {
+	maps, err := procmaps.Read(p.pid)
+	if err != nil {
+		if p.Maps() != nil {
+			return nil
+		}
+		return errors.Wrap(err, "error reading process memory mappings")
+	}
+	p.setMaps(maps)
+
+	return nil
+}
+
+// Snapshot reads the memory mappings of the process, and opens the mapped
+// ELF objects, so that the process can be symbolized after it exits.
+// Objects already opened by previous snapshots are kept open.
+// The files are released with Close.
+func (p *ProcSymTab) Snapshot() error {
+	maps, err := procmaps.Read(p.pid)
+	if err != nil {
+		return errors.Wrap(err, "error reading process memory mappings")
+	}
+	p.setMaps(maps)
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for i := range maps {
+		m := &maps[i]
+		if m.IsVDSO() {
+			_, _ = p.vdsoImage(m)
+			continue
+		}
+		if !m.IsExecutable() || !m.IsFileBacked() || m.IsAnonymous() {
+			continue
+		}
+		key := newObjectKey(m)
+		if _, ok := p.files[key]; ok {
+			continue
+		}
+		path, err := p.objectPath(m)
+		if err != nil {
+			continue
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+		p.files[key] = f
+	}
+
+	return nil
+}
+
+// Close releases the files opened by the snapshots.
+func (p *ProcSymTab) Close() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	var err error
+	for key, f := range p.files {
+		if cerr := f.Close(); cerr != nil && err == nil {
+			err = errors.Wrap(cerr, "error closing the object file")
+		}
+		delete(p.files, key)
+	}
+
+	return err
+}
+
+// setMaps sets the current memory mappings. It can be run concurrently,
+// like by the snapshots on the process notifications and by the polling.
+func (p *ProcSymTab) setMaps(maps procmaps.Maps) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.maps = maps
+	p.history.Update(maps, procmaps.MonotonicNow())
+
+	// The JIT symbols are optional, as most processes don't generate code at runtime.
+	// The JIT symbol table reloads its files when they change, so it's recreated
+	// only when the process maps other jitdump files.
+	dumps := jitDumpPaths(maps)
+	if p.jit == nil || !slices.Equal(dumps, p.jitDumps) {
+		p.jit = NewJITSymTab(PerfMapPath(p.pid), dumps...)
+		p.jitDumps = dumps
+	}
+	_ = p.jit.Load()
+}
+
+// GetName returns symbol name from an instruction pointer address
+// of the process address space.
+func (p *ProcSymTab) GetName(ip uint64) (string, error) {
+	sym, err := p.GetSymbol(ip)
+	if err != nil {
+		return "", err
+	}
+
+	return sym.Name, nil
+}
+
+// GetSymbol returns the symbol from an instruction pointer address
+// of the process address space.
+func (p *ProcSymTab) GetSymbol(ip uint64) (*Symbol, error) {
+	frames, err := p.GetFrames(ip)
+	if err != nil {
+		return nil, err
+	}
+
+	return &frames[len(frames)-1].Symbol, nil
+}
+
+// GetFrames returns the logical frames from an instruction pointer address
+// of the process address space, innermost first.
+// The frames are cached by the symbol tables of the mapped objects.
+func (p *ProcSymTab) GetFrames(ip uint64) ([]Frame, error) {
+	m, err := p.Mapping(ip)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.getFrames(m, ip)
+}
+
+// Symbolize resolves the addresses of the process, with the mapping if known.
+func (p *ProcSymTab) Symbolize(pid int, addr uint64, mapping *procmaps.Mapping) ([]Frame, error) {
+	if pid != p.pid || pid == KernelPID {
+		return nil, ErrNotHandled
+	}
+	if mapping == nil {
+		return p.GetFrames(addr)
+	}
+
+	return p.getFrames(mapping, addr)
+}
+
+// Maps returns the memory mappings of the process, or nil if not loaded.
+func (p *ProcSymTab) Maps() procmaps.Maps {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.maps
+}
+
+// History returns the memory mappings of the process tracked by the loads and the snapshots.
+func (p *ProcSymTab) History() *procmaps.History {
+	return p.history
+}
+
+// MappingAt returns the memory mapping of the process an address belonged to
+// at the CLOCK_MONOTONIC time in nanoseconds, or the current one if zero.
+func (p *ProcSymTab) MappingAt(ip uint64, t uint64) (*procmaps.Mapping, error) {
+	maps := p.Maps()
+	if maps == nil {
+		return nil, ErrMapsNotLoaded
+	}
+	if t == 0 {
+		return maps.Find(ip)
+	}
+	v, err := p.history.Find(ip, t)
+	if err != nil {
+		return nil, err
+	}
+
+	return &v.Mapping, nil
+}
+
+// Mapping returns the memory mapping of the process an address belongs to.
+func (p *ProcSymTab) Mapping(ip uint64) (*procmaps.Mapping, error) {
+	maps := p.Maps()
+	if maps == nil {
+		return nil, ErrMapsNotLoaded
+	}
+
+	return maps.Find(ip)
+}
+
+func (p *ProcSymTab) getFrames(m *procmaps.Mapping, ip uint64) ([]Frame, error) {
+	if m.IsExecutable() && m.IsAnonymous() {
+		p.lock.Lock()
+		jit := p.jit
+		p.lock.Unlock()
+		if jit == nil {
+			return nil, ErrSymbolNotFound
+		}
+		return jit.GetFrames(ip)
+	}
+	if !m.IsExecutable() || (!m.IsFileBacked() && !m.IsVDSO()) {
+		return nil, ErrNotFileBacked
+	}
+
+	frames, err := p.getObjectFrames(m, ip)
+	if err != nil {
+		p.setUnresolved(m)
+		return nil, err
+	}
+
+	return frames, nil
+}
+
+// getObjectFrames returns the logical frames from an instruction pointer address
+// in the ELF object of the mapping.
+func (p *ProcSymTab) getObjectFrames(m *procmaps.Mapping, ip uint64) ([]Frame, error) {
+	obj, err := p.getObject(m)
+	if err != nil {
+		return nil, err
+	}
+
+	// Translate the runtime address to the address in the object's symbol table,
+	// so that the load bias of PIE executables and shared libraries is removed.
+	addr, err := obj.FileOffsetToAddr(m.FileOffset(ip))
+	if err != nil {
+		return nil, err
+	}
+
+	return obj.GetFrames(addr)
+}
+
+// getObject returns the symbol table of the ELF object of the mapping, or of the vDSO,
+// loading it on first use. Failed loads are remembered to not retry them.
+func (p *ProcSymTab) getObject(m *procmaps.Mapping) (*ELFSymTab, error) {
+	key := newObjectKey(m)
+	obj := p.loadObject(m, key)
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if err := p.errs[key]; err != nil {
+		return nil, err
+	}
+
+	return obj.tab, nil
+}
+
+// loadObject returns the object of the mapping, loading its symbol table once.
+// The symbol tables are loaded out of the lock, as loading the ones of large
+// objects takes long, so that the other objects are resolved meanwhile.
+func (p *ProcSymTab) loadObject(m *procmaps.Mapping, key objectKey) *procObject {
+	p.lock.Lock()
+	obj, ok := p.objs[key]
+	if !ok {
+		obj = new(procObject)
+		p.objs[key] = obj
+	}
+	p.lock.Unlock()
+
+	obj.once.Do(func() {
+		var err error
+		obj.tab, err = p.load(m, key)
+		if err == nil {
+			return
+		}
+		p.lock.Lock()
+		defer p.lock.Unlock()
+		if errors.Is(err, ErrObjectNotFound) {
+			p.unavailable[m.Pathname] = struct{}{}
+		}
+		p.errs[key] = err
+	})
+
+	return obj
+}
+
+// load loads the symbol table of the ELF object of the mapping, or of the vDSO.
+// The symbol table is returned even if the load fails, for its debug files to be added.
+func (p *ProcSymTab) load(m *procmaps.Mapping, key objectKey) (*ELFSymTab, error) {
+	if m.IsVDSO() {
+		obj := NewELFSymTab(p.opts...)
+		p.lock.Lock()
+		defer p.lock.Unlock()
+
+		return obj, p.loadVDSO(obj, m)
+	}
+
+	// The separate debug files are looked up in the mount namespace of the process too.
+	obj := NewELFSymTab(slices.Concat(p.opts, []ELFSymTabOption{WithRoot(procmaps.RootPath(p.pid, ""), m.FilePath())})...)
+	p.lock.Lock()
+	path, err := p.objectPath(m)
+	if err == nil {
+		path = p.openPath(key, path)
+	}
+	p.lock.Unlock()
+	if err != nil {
+		return obj, err
+	}
+	if _, err = os.Stat(path); err != nil {
+		return obj, errors.Wrap(ErrObjectNotFound, m.Pathname)
+	}
+
+	return obj, obj.Load(path)
+}
+
+// openPath returns the path of the ELF object of the key, or the one of the file
+// opened by a snapshot if the former is not available anymore, like after the
+// process has exited.
+func (p *ProcSymTab) openPath(key objectKey, path string) string {
+	f, ok := p.files[key]
+	if !ok {
+		return path
+	}
+	if _, err := os.Stat(path); err == nil {
+		return path
+	}
+
+	return fmt.Sprintf("/proc/self/fd/%d", f.Fd())
+}
+
+// ObjectPath returns the path the ELF object of a file-backed mapping can be
+// opened at, as its pathname is relative to the mount namespace of the process.
+// The object is looked up through the root directory of the process, then at
+// its pathname, and verified to be the mapped file by device and inode numbers,
+// or by build-id against the file in the map_files directory of the process,
+// as the numbers may differ on overlay filesystems.
+// When the file has been deleted or replaced, the mapped one is opened through
+// the map_files directory, or the executable link of the process.
+// As the last resort, the file at the pathname is returned, and it's reported
+// by Mismatched as it could not be verified to be the mapped one.
+func (p *ProcSymTab) ObjectPath(m *procmaps.Mapping) (string, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.objectPath(m)
+}
+
+func (p *ProcSymTab) objectPath(m *procmaps.Mapping) (string, error) {
+	key := newObjectKey(m)
+	if path, ok := p.paths[key]; ok {
+		return path, nil
+	}
+
+	candidates := []string{procmaps.RootPath(p.pid, m.FilePath()), m.FilePath()}
+	path, verified, err := matchMappedFile(m, p.pid, candidates)
+	if err != nil {
+		return "", errors.Wrap(err, m.Pathname)
+	}
+	if !verified {
+		p.mismatched[m.Pathname] = path
+	}
+	p.paths[key] = path
+
+	return path, nil
+}
+
+// Mismatched returns the paths of the ELF objects that have been symbolized with
+// files that could not be verified to be the mapped ones, by their original paths.
+func (p *ProcSymTab) Mismatched() map[string]string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	mismatched := make(map[string]string, len(p.mismatched))
+	for pathname, path := range p.mismatched {
+		mismatched[pathname] = path
+	}
+
+	return mismatched
+}
+
+// matchMappedFile returns the first candidate that is the file of the mapping
+// of the process with the specified ID, or the mapped file itself if none is.
+// If the mapped file can't be opened, the first existing candidate is returned
+// as not verified.
+func matchMappedFile(m *procmaps.Mapping, pid int, candidates []string) (string, bool, error) {
+	if !m.IsDeleted() {
+		for _, candidate := range candidates {
+			if sameInode(candidate, m) {
+				return candidate, true, nil
+			}
+		}
+	}
+
+	mapped := m.MapFilesPath(pid)
+	if buildID, err := ReadFileBuildID(mapped); err == nil {
+		for _, candidate := range candidates {
+			if id, err := ReadFileBuildID(candidate); err == nil && id == buildID {
+				return candidate, true, nil
+			}
+		}
+	}
+	if _, err := os.Stat(mapped); err == nil {
+		return mapped, true, nil
+	}
+	// The executable link refers to the mapped executable even without CAP_SYS_ADMIN.
+	if exe := procmaps.ExePath(pid); sameInode(exe, m) {
+		return exe, true, nil
+	}
+
+	for _, candidate := range candidates {
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate, false, nil
+		}
+	}
+
+	return "", false, ErrObjectNotFound
+}
+
+// sameInode returns whether the file at pathname has the device and inode numbers of the mapped file.
+func sameInode(pathname string, m *procmaps.Mapping) bool {
+	info, err := os.Stat(pathname)
+	if err != nil {
+		return false
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || st.Ino != m.Inode {
+		return false
+	}
+	major, minor, err := m.DevNumbers()
+
+	return err == nil && unix.Major(uint64(st.Dev)) == major && unix.Minor(uint64(st.Dev)) == minor
+}
+
+func (p *ProcSymTab) setUnresolved(m *procmaps.Mapping) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.unresolved[newObjectKey(m)] = *m
+}
+
+// BuildID returns the GNU build-id of the ELF object of the mapping, or of the vDSO.
+func (p *ProcSymTab) BuildID(m *procmaps.Mapping) (string, error) {
+	p.lock.Lock()
+	if m.IsVDSO() {
+		defer p.lock.Unlock()
+		return p.vdsoBuildID()
+	}
+	key := newObjectKey(m)
+	path, ok := p.paths[key]
+	if !ok {
+		path = m.Pathname
+	}
+	path = p.openPath(key, path)
+	p.lock.Unlock()
+
+	return ReadFileBuildID(path)
+}
+
+// Unresolved returns the mappings of the ELF objects in which some of the
+// looked up addresses could not be resolved, one per object, by pathname.
+func (p *ProcSymTab) Unresolved() []procmaps.Mapping {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	maps := make([]procmaps.Mapping, 0, len(p.unresolved))
+	for _, m := range p.unresolved {
+		maps = append(maps, m)
+	}
+	sort.Slice(maps, func(i, j int) bool {
+		if maps[i].Pathname != maps[j].Pathname {
+			return maps[i].Pathname < maps[j].Pathname
+		}
+		return maps[i].Inode < maps[j].Inode
+	})
+
+	return maps
+}
+
+// Unavailable returns the paths of the ELF objects whose files could not be opened,
+// like the ones mapped after the last snapshot of a process that exited.
+func (p *ProcSymTab) Unavailable() []string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	paths := make([]string, 0, len(p.unavailable))
+	for path := range p.unavailable {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	return paths
+}
+
+// AddDebugFile adds the symbols and debugging information of a separate debug
+// file to the ELF object of the mapping, and invalidates its resolved addresses.
+func (p *ProcSymTab) AddDebugFile(m *procmaps.Mapping, debugPath string) error {
+	key := newObjectKey(m)
+	// Loading the object is needed for its segments even if it has no symbols.
+	obj := p.loadObject(m, key)
+	if err := obj.tab.AddDebugFile(debugPath); err != nil {
+		return err
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	delete(p.errs, key)
+	delete(p.unresolved, key)
+
+	return nil
+}
@@ -0,0 +1,304 @@
+package symtable_test
+
+import (
+	"bytes"
+	"debug/elf"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+
+	"github.com/maxgio92/yap/pkg/procmaps"
+	. "github.com/maxgio92/yap/pkg/symtable"
+)
+
+const testSleep = "/bin/sleep"
+
+func TestProcSymTabGetSymbol(t *testing.T) {
+	tab := NewProcSymTab(os.Getpid())
+	if err := tab.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	ip := uint64(reflect.ValueOf(TestProcSymTabGetSymbol).Pointer())
+	sym, err := tab.GetSymbol(ip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "github.com/maxgio92/yap/pkg/symtable_test.TestProcSymTabGetSymbol", sym.Name)
+	// Test binaries are stripped of .symtab, unless built with go test -c.
+	assert.Contains(t, []Source{SourceSymtab, SourceGoPclntab}, sym.Source)
+}
+
+func TestProcSymTabNotLoaded(t *testing.T) {
+	tab := NewProcSymTab(os.Getpid())
+	_, err := tab.GetName(0x1000)
+	assert.ErrorIs(t, err, ErrMapsNotLoaded)
+}
+
+func TestProcSymTabMappingAt(t *testing.T) {
+	tab := NewProcSymTab(os.Getpid())
+	if err := tab.Load(); err != nil {
+		t.Fatal(err)
+	}
+	ip := uint64(reflect.ValueOf(TestProcSymTabMappingAt).Pointer())
+	current, err := tab.Mapping(ip)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The mappings of the first load are valid since the process start.
+	for _, ts := range []uint64{1, procmaps.MonotonicNow()} {
+		mapping, err := tab.MappingAt(ip, ts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, current, mapping)
+	}
+}
+
+func TestProcSymTabVDSO(t *testing.T) {
+	tab := NewProcSymTab(os.Getpid())
+	if err := tab.Load(); err != nil {
+		t.Fatal(err)
+	}
+	var vdso *procmaps.Mapping
+	for _, m := range tab.Maps() {
+		if m.IsVDSO() {
+			vdso = &m
+		}
+	}
+	if vdso == nil {
+		t.Skip("vDSO not mapped")
+	}
+
+	// Look up the address of clock_gettime in the vDSO image.
+	image, err := ReadVDSO(os.Getpid(), vdso)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := elf.NewFile(bytes.NewReader(image))
+	if err != nil {
+		t.Fatal(err)
+	}
+	syms, err := file.DynamicSymbols()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var addr uint64
+	for _, sym := range syms {
+		if sym.Name == "__vdso_clock_gettime" {
+			addr = sym.Value
+		}
+	}
+	if addr == 0 {
+		t.Skip("__vdso_clock_gettime not found")
+	}
+	for _, prog := range file.Progs {
+		if prog.Type == elf.PT_LOAD {
+			addr = vdso.Start + addr - prog.Vaddr + prog.Off
+			break
+		}
+	}
+
+	frames, err := tab.Symbolize(os.Getpid(), addr+1, vdso)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Contains(t, frames[len(frames)-1].Name, "clock_gettime")
+	buildID, err := tab.BuildID(vdso)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, buildID)
+}
+
+func TestProcSymTabObjectPath(t *testing.T) {
+	tab := NewProcSymTab(os.Getpid())
+	if err := tab.Load(); err != nil {
+		t.Fatal(err)
+	}
+	mapping, err := tab.Mapping(uint64(reflect.ValueOf(TestProcSymTabObjectPath).Pointer()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Objects are opened through the root directory of the process.
+	path, err := tab.ObjectPath(mapping)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, procmaps.RootPath(os.Getpid(), mapping.Pathname), path)
+
+	// Files that are not the mapped one are rejected, in favor of the mapped file.
+	if _, err = os.Stat(testLibm); err != nil {
+		t.Skipf("%s not available", testLibm)
+	}
+	replaced := *mapping
+	replaced.Pathname = testLibm + " (deleted)"
+	tab = NewProcSymTab(os.Getpid())
+	path, err = tab.ObjectPath(&replaced)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Contains(t, []string{replaced.MapFilesPath(os.Getpid()), procmaps.ExePath(os.Getpid())}, path)
+	assert.Empty(t, tab.Mismatched())
+
+	// Files that can't be verified are used as the last resort, and reported.
+	unmapped := procmaps.Mapping{Start: 0x1000, End: 0x2000, Perms: "r-xp", Pathname: testLibm}
+	path, err = tab.ObjectPath(&unmapped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, map[string]string{testLibm: path}, tab.Mismatched())
+}
+
+func TestProcSymTabUnavailable(t *testing.T) {
+	tab := NewProcSymTab(os.Getpid())
+	if err := tab.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The objects that can't be opened, like when unmapped before a snapshot, are reported.
+	unmapped := &procmaps.Mapping{Start: 0x1000, End: 0x2000, Perms: "r-xp", Inode: 1, Pathname: "/nonexistent/libfoo.so"}
+	_, err := tab.Symbolize(os.Getpid(), 0x1010, unmapped)
+	assert.ErrorIs(t, err, ErrObjectNotFound)
+	assert.Equal(t, []string{unmapped.Pathname}, tab.Unavailable())
+}
+
+func TestProcSymTabReplacedObject(t *testing.T) {
+	if _, err := os.Stat(testSleep); err != nil {
+		t.Skipf("%s not available", testSleep)
+	}
+	tab := NewProcSymTab(os.Getpid())
+	if err := tab.Load(); err != nil {
+		t.Fatal(err)
+	}
+	ip := uint64(reflect.ValueOf(TestProcSymTabReplacedObject).Pointer())
+	exe, err := tab.Mapping(ip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := "github.com/maxgio92/yap/pkg/symtable_test.TestProcSymTabReplacedObject"
+
+	// An object mapped at the same pathname before and after the file is replaced, like on upgrades.
+	pathname := filepath.Join(t.TempDir(), "libfoo.so")
+	copyFile(t, exe.FilePath(), pathname)
+	before := fileMapping(t, exe, pathname)
+	frames, err := tab.Symbolize(os.Getpid(), ip, before)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, name, frames[len(frames)-1].Name)
+
+	copyFile(t, testSleep, pathname+".new")
+	if err = os.Rename(pathname+".new", pathname); err != nil {
+		t.Fatal(err)
+	}
+	after := fileMapping(t, exe, pathname)
+	if frames, err = tab.Symbolize(os.Getpid(), ip, after); err == nil {
+		assert.NotEqual(t, name, frames[len(frames)-1].Name)
+	}
+
+	// The object of the replaced file is kept for the mappings of it.
+	frames, err = tab.Symbolize(os.Getpid(), ip, before)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, name, frames[len(frames)-1].Name)
+}
+
+// fileMapping returns the mapping with the pathname, and the device and inode numbers of the file at it.
+func fileMapping(t *testing.T, m *procmaps.Mapping, pathname string) *procmaps.Mapping {
+	info, err := os.Stat(pathname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := info.Sys().(*syscall.Stat_t)
+	mapping := *m
+	mapping.Pathname = pathname
+	mapping.Dev = fmt.Sprintf("%02x:%02x", unix.Major(uint64(st.Dev)), unix.Minor(uint64(st.Dev)))
+	mapping.Inode = st.Ino
+
+	return &mapping
+}
+
+func TestProcSymTabConcurrentSnapshots(t *testing.T) {
+	tab := NewProcSymTab(os.Getpid())
+	defer tab.Close()
+
+	// The snapshots are taken on the process notifications and by the polling.
+	ip := uint64(reflect.ValueOf(TestProcSymTabConcurrentSnapshots).Pointer())
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				assert.NoError(t, tab.Snapshot())
+				_, _ = tab.MappingAt(ip, procmaps.MonotonicNow())
+			}
+		}()
+	}
+	wg.Wait()
+
+	mapping, err := tab.Mapping(ip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, mapping.IsExecutable())
+}
+
+func TestProcSymTabSnapshot(t *testing.T) {
+	// Snapshot a child process, that exits before being symbolized.
+	cmd := exec.Command(testSleep, "10")
+	if err := cmd.Start(); err != nil {
+		t.Skipf("error starting %s: %v", testSleep, err)
+	}
+	defer cmd.Process.Kill()
+	// Wait for the dynamic loader to map libc.
+	var libc *procmaps.Mapping
+	for i := 0; i < 100 && libc == nil; i++ {
+		time.Sleep(10 * time.Millisecond)
+		maps, _ := procmaps.Read(cmd.Process.Pid)
+		for j, m := range maps {
+			if m.IsExecutable() && strings.Contains(m.Pathname, "libc") {
+				libc = &maps[j]
+			}
+		}
+	}
+	if libc == nil {
+		t.Skip("libc not mapped")
+	}
+
+	tab := NewProcSymTab(cmd.Process.Pid)
+	defer tab.Close()
+	if err := tab.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cmd.Process.Kill(); err != nil {
+		t.Fatal(err)
+	}
+	cmd.Wait()
+
+	// The snapshot is used in place of the memory mappings of the exited process.
+	if err := tab.Load(); err != nil {
+		t.Fatal(err)
+	}
+	mapping, err := tab.Mapping(libc.Start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, libc.Pathname, mapping.Pathname)
+	// And the objects are read through the files opened by the snapshot.
+	_, err = tab.BuildID(libc)
+	assert.NoError(t, err)
+}
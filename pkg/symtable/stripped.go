@@ -0,0 +1,69 @@
+package symtable
+
+import (
+	"bytes"
+	"debug/elf"
+	"debug/gosym"
+	"io"
+
+	"github.com/pkg/errors"
+	"github.com/ulikunitz/xz"
+)
+
+var (
+	ErrSectionNotFound = errors.New("ELF section not found")
+)
+
+// loadMiniDebugInfo returns the symbols of the MiniDebugInfo, that is an
+// xz-compressed ELF object stored in the .gnu_debugdata section that holds the
+// .symtab entries of the local functions, which are not part of .dynsym.
+func loadMiniDebugInfo(file *elf.File) ([]elf.Symbol, error) {
+	section := file.Section(".gnu_debugdata")
+	if section == nil {
+		return nil, ErrSectionNotFound
+	}
+
+	r, err := xz.NewReader(section.Open())
+	if err != nil {
+		return nil, errors.Wrap(err, "error opening xz stream")
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "error decompressing MiniDebugInfo")
+	}
+
+	debugFile, err := elf.NewFile(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing MiniDebugInfo ELF object")
+	}
+	defer debugFile.Close()
+
+	return debugFile.Symbols()
+}
+
+// loadGoPclntab returns the Go symbol table built from the .gopclntab
+// section, which survives stripping of Go binaries.
+func loadGoPclntab(file *elf.File) (*gosym.Table, error) {
+	pclntab := file.Section(".gopclntab")
+	if pclntab == nil {
+		// Position independent Go executables store it in a relocated read-only section.
+		pclntab = file.Section(".data.rel.ro.gopclntab")
+	}
+	text := file.Section(".text")
+	if pclntab == nil || text == nil {
+		return nil, ErrSectionNotFound
+	}
+
+	pclndata, err := pclntab.Data()
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading .gopclntab section")
+	}
+	var symdata []byte
+	if symtab := file.Section(".gosymtab"); symtab != nil {
+		if symdata, err = symtab.Data(); err != nil {
+			return nil, errors.Wrap(err, "error reading .gosymtab section")
+		}
+	}
+
+	return gosym.NewTable(symdata, gosym.NewLineTable(pclndata, text.Addr))
+}
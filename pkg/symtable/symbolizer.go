@@ -0,0 +1,52 @@
+package symtable
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/maxgio92/yap/pkg/procmaps"
+)
+
+// KernelPID is the process ID kernel addresses are symbolized with.
+const KernelPID = -1
+
+var (
+	ErrNotHandled = errors.New("address not handled by the symbolizer")
+)
+
+// Symbolizer resolves an instruction pointer address of a process to its
+// logical frames, innermost first. The mapping is the memory mapping of the
+// process the address belongs to, or nil if unknown, like for kernel addresses.
+// Symbolizers return ErrNotHandled for the addresses they don't resolve,
+// to let the next ones in a Chain resolve them.
+type Symbolizer interface {
+	Symbolize(pid int, addr uint64, mapping *procmaps.Mapping) ([]Frame, error)
+}
+
+// SymbolizerFunc is an adapter to use a function as a Symbolizer.
+type SymbolizerFunc func(pid int, addr uint64, mapping *procmaps.Mapping) ([]Frame, error)
+
+func (f SymbolizerFunc) Symbolize(pid int, addr uint64, mapping *procmaps.Mapping) ([]Frame, error) {
+	return f(pid, addr, mapping)
+}
+
+// Chain is a Symbolizer that tries the symbolizers in order,
+// until one resolves the address.
+type Chain []Symbolizer
+
+// Symbolize returns the frames from the first symbolizer that resolves the address.
+// If none does, the error of the first one that handled the address is returned,
+// or ErrNotHandled.
+func (c Chain) Symbolize(pid int, addr uint64, mapping *procmaps.Mapping) ([]Frame, error) {
+	err := ErrNotHandled
+	for _, s := range c {
+		frames, serr := s.Symbolize(pid, addr, mapping)
+		if serr == nil && len(frames) > 0 {
+			return frames, nil
+		}
+		if serr != nil && err == ErrNotHandled && !errors.Is(serr, ErrNotHandled) {
+			err = serr
+		}
+	}
+
+	return nil, err
+}
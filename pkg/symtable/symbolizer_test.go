@@ -0,0 +1,97 @@
+package symtable_test
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/maxgio92/yap/pkg/procmaps"
+	. "github.com/maxgio92/yap/pkg/symtable"
+)
+
+func staticSymbolizer(name string, err error) Symbolizer {
+	return SymbolizerFunc(func(pid int, addr uint64, mapping *procmaps.Mapping) ([]Frame, error) {
+		if err != nil {
+			return nil, err
+		}
+		return []Frame{{Symbol: Symbol{Name: name}}}, nil
+	})
+}
+
+func TestChain(t *testing.T) {
+	errBackend := errors.New("backend error")
+
+	frames, err := Chain{
+		staticSymbolizer("", ErrNotHandled),
+		staticSymbolizer("", errBackend),
+		staticSymbolizer("foo", nil),
+		staticSymbolizer("bar", nil),
+	}.Symbolize(1, 0x1000, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "foo", frames[0].Name)
+
+	_, err = Chain{
+		staticSymbolizer("", ErrNotHandled),
+		staticSymbolizer("", errBackend),
+		staticSymbolizer("", ErrSymbolNotFound),
+	}.Symbolize(1, 0x1000, nil)
+	assert.ErrorIs(t, err, errBackend)
+
+	_, err = Chain{}.Symbolize(1, 0x1000, nil)
+	assert.ErrorIs(t, err, ErrNotHandled)
+}
+
+func TestKallSymTabSymbolize(t *testing.T) {
+	tab := NewKallSymTab()
+	if err := tab.Load(writeKallsyms(t, testKallsyms)); err != nil {
+		t.Fatal(err)
+	}
+
+	frames, err := tab.Symbolize(KernelPID, 0xffffffff81001010, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "do_syscall_64", frames[0].Name)
+
+	_, err = tab.Symbolize(os.Getpid(), 0xffffffff81001010, nil)
+	assert.ErrorIs(t, err, ErrNotHandled)
+}
+
+func TestProcSymTabSymbolize(t *testing.T) {
+	tab := NewProcSymTab(os.Getpid())
+	if err := tab.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	ip := uint64(reflect.ValueOf(TestProcSymTabSymbolize).Pointer())
+	mapping, err := tab.Mapping(ip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frames, err := tab.Symbolize(os.Getpid(), ip, mapping)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "github.com/maxgio92/yap/pkg/symtable_test.TestProcSymTabSymbolize", frames[len(frames)-1].Name)
+
+	// The ELF symbol table of the executable resolves the addresses of its mapping.
+	exe := NewELFSymTab()
+	if err = exe.Load(mapping.Pathname); err != nil {
+		t.Fatal(err)
+	}
+	exeFrames, err := exe.Symbolize(os.Getpid(), ip, mapping)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, frames, exeFrames)
+
+	_, err = exe.Symbolize(os.Getpid(), ip, &procmaps.Mapping{Pathname: "/usr/lib/libc.so.6"})
+	assert.ErrorIs(t, err, ErrNotHandled)
+	_, err = tab.Symbolize(KernelPID, ip, nil)
+	assert.ErrorIs(t, err, ErrNotHandled)
+}
@@ -2,34 +2,158 @@ package symtable
 
 import (
 	"debug/elf"
+	"debug/gosym"
+	"fmt"
+	"io"
+
+	"github.com/maxgio92/yap/pkg/procmaps"
 	"github.com/maxgio92/yap/pkg/symcache"
 	"github.com/pkg/errors"
 )
 
 var (
-	ErrSymTableEmpty = errors.New("symtable is empty")
+	ErrSymTableEmpty  = errors.New("symtable is empty")
+	ErrOffsetNotFound = errors.New("file offset not found in loadable segments")
 )
 
+// Source is the source of symbol information a symbol has been resolved from.
+type Source string
+
+const (
+	SourceSymtab        Source = "symtab"
+	SourceDynsym        Source = "dynsym"
+	SourceMiniDebugInfo Source = "minidebuginfo"
+	SourceGoPclntab     Source = "gopclntab"
+	SourceKallsyms      Source = "kallsyms"
+	SourceDWARF         Source = "dwarf"
+	SourceDebugFile     Source = "debugfile"
+)
+
+// Symbol is a symbol resolved from an instruction pointer address.
+type Symbol struct {
+	Name   string
+	Source Source
+
+	// Offset is the offset of the address from the start of the symbol.
+	Offset uint64
+}
+
+// NameWithOffset returns the symbol name followed by the offset
+// of the address from the start of the symbol, like func+0x1a.
+func (s Symbol) NameWithOffset() string {
+	return fmt.Sprintf("%s+%#x", s.Name, s.Offset)
+}
+
+// Frame is a logical stack frame resolved from an instruction pointer address.
+// An address resolves to multiple frames when it belongs to inlined functions:
+// one for each inlined function and one for the function they are inlined into.
+type Frame struct {
+	Symbol
+
+	// File and Line are the source location, when debugging information is available.
+	File string
+	Line int
+
+	// Inlined is whether the function has been inlined into the caller frame.
+	Inlined bool
+}
+
+// sourceSymTab is the index of the ELF symbols read from a specific source.
+type sourceSymTab struct {
+	source Source
+	index  *symIndex
+}
+
+func newSourceSymTab(source Source, syms []elf.Symbol, sections sectionEnds) sourceSymTab {
+	return sourceSymTab{source: source, index: newSymIndex(syms, sections)}
+}
+
 // ELFSymTab is one of the possible abstractions around executable
 // file symbol tables, for ELF files.
+// The symbols are indexed by address range at load time.
 type ELFSymTab struct {
-	symtab []elf.Symbol
-	cache  *symcache.SymCache
+	// symtabs are the symbol lists in the order they are looked up.
+	symtabs []sourceSymTab
+	gotab   *gosym.Table
+	dwarf   *dwarfTable
+	progs   []elf.ProgHeader
+	cache   *symcache.Cache[[]Frame]
+	shared  *symcache.Cache[[]Frame]
+	finder  *DebugFinder
+
+	// indexCache is the persistent cache of the symbol indexes.
+	indexCache *IndexCache
+
+	// pathname is the path of the loaded ELF file.
+	pathname string
+
+	// root is the root directory of the mount namespace the ELF file is mapped in,
+	// and rootPathname its path in there, to look up its separate debug file.
+	root         string
+	rootPathname string
 }
 
-func NewELFSymTab() *ELFSymTab {
+type ELFSymTabOption func(tab *ELFSymTab)
+
+// WithDebugFinder sets the DebugFinder used to look up the separate debug files,
+// when the ELF file has been stripped of the symbol table or debugging information.
+func WithDebugFinder(finder *DebugFinder) ELFSymTabOption {
+	return func(tab *ELFSymTab) {
+		tab.finder = finder
+	}
+}
+
+// WithRoot sets the root directory of the mount namespace the ELF file is mapped in,
+// like /proc/PID/root, and its path in there, so that the separate debug file
+// is looked up in the namespace too.
+func WithRoot(root string, pathname string) ELFSymTabOption {
+	return func(tab *ELFSymTab) {
+		tab.root = root
+		tab.rootPathname = pathname
+	}
+}
+
+// WithCache sets a cache shared with other symbol tables, where the frames
+// resolved from the ELF file are stored in the namespace of its path.
+func WithCache(cache *symcache.Cache[[]Frame]) ELFSymTabOption {
+	return func(tab *ELFSymTab) {
+		tab.shared = cache
+	}
+}
+
+// WithIndexCache sets the persistent cache where the symbol indexes are read
+// from, instead of parsing the symbol tables again, and stored to.
+func WithIndexCache(cache *IndexCache) ELFSymTabOption {
+	return func(tab *ELFSymTab) {
+		tab.indexCache = cache
+	}
+}
+
+func NewELFSymTab(opts ...ELFSymTabOption) *ELFSymTab {
 	tab := new(ELFSymTab)
-	tab.symtab = make([]elf.Symbol, 0)
-	tab.cache = symcache.NewSymCache()
+	tab.symtabs = make([]sourceSymTab, 0)
+	tab.cache = symcache.NewCache[[]Frame]()
+	for _, f := range opts {
+		f(tab)
+	}
 
 	return tab
 }
 
 // Load loads from the underlying filesystem the ELF file
 // with debug/elf.Open and stores it in the ELFSymTab struct.
+// The .symtab section is read, and if missing because the file is stripped,
+// the .dynsym section and the MiniDebugInfo .gnu_debugdata section are read
+// instead, in this order of lookup priority. The Go .gopclntab section is
+// read as the last resort, and to resolve source locations of Go binaries.
+// The DWARF debugging information is read too, when available, to resolve
+// source locations and inlined functions.
+// If the symbol table or the debugging information are missing, they're
+// read from the separate debug file, when a DebugFinder is set.
+// The symbol indexes are read from the persistent cache, when an IndexCache is set.
 func (e *ELFSymTab) Load(pathname string) error {
 	// Skip load if file elf.File has already been loaded.
-	if e.symtab != nil && len(e.symtab) > 0 {
+	if e.loaded() {
 		return nil
 	}
 
@@ -37,35 +161,298 @@ func (e *ELFSymTab) Load(pathname string) error {
 	if err != nil {
 		return errors.Wrap(err, "error opening ELF file")
 	}
+	defer file.Close()
 
-	syms, err := file.Symbols()
+	return e.load(pathname, file)
+}
+
+// LoadReader loads the ELF image read from r, like the one of an object in memory,
+// as Load does for files. The pathname identifies the object, and is used to look up
+// its separate debug file.
+func (e *ELFSymTab) LoadReader(pathname string, r io.ReaderAt) error {
+	if e.loaded() {
+		return nil
+	}
+
+	file, err := elf.NewFile(r)
 	if err != nil {
-		return errors.Wrap(err, "error reading ELF symtable section")
+		return errors.Wrap(err, "error reading ELF image")
+	}
+
+	return e.load(pathname, file)
+}
+
+func (e *ELFSymTab) load(pathname string, file *elf.File) error {
+	e.pathname = pathname
+	if e.shared != nil {
+		e.cache = e.shared.Namespace(pathname)
+	}
+
+	// Keep the loadable segments to translate file offsets to virtual addresses,
+	// even if there are no symbols, as they can be added later from a debug file.
+	e.progs = e.progs[:0]
+	for _, prog := range file.Progs {
+		if prog.Type == elf.PT_LOAD {
+			e.progs = append(e.progs, prog.ProgHeader)
+		}
+	}
+
+	dwarfData, dwarfErr := file.DWARF()
+	// The first entry of the symbol table is the null symbol.
+	symtab := file.SectionByType(elf.SHT_SYMTAB)
+	hasSymtab := symtab != nil && symtab.Size > symtab.Entsize
+
+	// Look up the separate debug file for what's missing.
+	var (
+		debugFile *elf.File
+		debugPath string
+	)
+	if e.finder != nil && (!hasSymtab || dwarfErr != nil) {
+		root, debugName := "", pathname
+		if e.rootPathname != "" {
+			root, debugName = e.root, e.rootPathname
+		}
+		if path, err := e.finder.Find(root, debugName, file); err == nil {
+			if debugFile, err = elf.Open(path); err == nil {
+				debugPath = path
+				defer debugFile.Close()
+			}
+		}
+	}
+	if debugFile != nil && dwarfErr != nil {
+		dwarfData, dwarfErr = debugFile.DWARF()
 	}
 
-	e.symtab = syms
+	// Read the symbol indexes from the persistent cache, or build and store them.
+	var key string
+	if e.indexCache != nil {
+		key, _ = indexKey(pathname, file, debugPath)
+	}
+	if symtabs, err := e.getCachedIndexes(key); err == nil {
+		e.symtabs = symtabs
+	} else {
+		e.symtabs = loadSymTabs(file, debugFile)
+		if key != "" && len(e.symtabs) > 0 {
+			_ = e.indexCache.Put(key, e.symtabs)
+		}
+	}
+	if tab, err := loadGoPclntab(file); err == nil {
+		e.gotab = tab
+	}
+	if dwarfErr == nil {
+		if tab, err := newDWARFTable(dwarfData); err == nil {
+			e.dwarf = tab
+		}
+	}
+	if !e.loaded() {
+		return errors.Wrap(ErrSymTableEmpty, "error reading ELF symbols")
+	}
 
 	return nil
 }
 
+func (e *ELFSymTab) getCachedIndexes(key string) ([]sourceSymTab, error) {
+	if key == "" {
+		return nil, ErrIndexNotFound
+	}
+
+	return e.indexCache.Get(key)
+}
+
+// loadSymTabs reads and indexes the symbols of the ELF file, from the first
+// available source among the .symtab section, the separate debug file, and
+// the .dynsym and MiniDebugInfo .gnu_debugdata sections.
+func loadSymTabs(file *elf.File, debugFile *elf.File) []sourceSymTab {
+	symtabs := make([]sourceSymTab, 0)
+
+	// MiniDebugInfo objects keep the section headers of the file they've been stripped from.
+	sections := newSectionEnds(file)
+	if syms, err := file.Symbols(); err == nil && len(syms) > 0 {
+		symtabs = append(symtabs, newSourceSymTab(SourceSymtab, syms, sections))
+	} else if debugSyms, err := debugSymbols(debugFile); err == nil && len(debugSyms) > 0 {
+		symtabs = append(symtabs, newSourceSymTab(SourceDebugFile, debugSyms, newSectionEnds(debugFile)))
+	} else {
+		if syms, err := file.DynamicSymbols(); err == nil && len(syms) > 0 {
+			symtabs = append(symtabs, newSourceSymTab(SourceDynsym, syms, sections))
+		}
+		if syms, err := loadMiniDebugInfo(file); err == nil && len(syms) > 0 {
+			symtabs = append(symtabs, newSourceSymTab(SourceMiniDebugInfo, syms, sections))
+		}
+	}
+
+	return symtabs
+}
+
+// AddDebugFile adds the symbols and the debugging information of a separate
+// debug file, like the ones fetched from debuginfod, to the ELF symbol table.
+// Its symbols take precedence over the ones already loaded.
+func (e *ELFSymTab) AddDebugFile(pathname string) error {
+	file, err := elf.Open(pathname)
+	if err != nil {
+		return errors.Wrap(err, "error opening ELF debug file")
+	}
+	defer file.Close()
+
+	syms, err := file.Symbols()
+	if err == nil && len(syms) > 0 {
+		e.symtabs = append([]sourceSymTab{newSourceSymTab(SourceDebugFile, syms, newSectionEnds(file))}, e.symtabs...)
+	}
+	if d, err := file.DWARF(); err == nil && e.dwarf == nil {
+		if tab, err := newDWARFTable(d); err == nil {
+			e.dwarf = tab
+		}
+	}
+	if !e.loaded() {
+		return errors.Wrap(ErrSymTableEmpty, "error reading ELF debug file symbols")
+	}
+	e.cache.Reset()
+
+	return nil
+}
+
+// debugSymbols returns the symbols of the separate debug file, if any.
+func debugSymbols(debugFile *elf.File) ([]elf.Symbol, error) {
+	if debugFile == nil {
+		return nil, ErrDebugFileNotFound
+	}
+
+	return debugFile.Symbols()
+}
+
+// loaded returns whether any source of symbols has been loaded.
+func (e *ELFSymTab) loaded() bool {
+	return len(e.symtabs) > 0 || e.gotab != nil || e.dwarf != nil
+}
+
+// FileOffsetToAddr translates an offset in the ELF file to the virtual address
+// the symbol table refers to, by looking up the loadable segment that contains it.
+func (e *ELFSymTab) FileOffsetToAddr(offset uint64) (uint64, error) {
+	for _, prog := range e.progs {
+		if offset >= prog.Off && offset < prog.Off+prog.Filesz {
+			return offset - prog.Off + prog.Vaddr, nil
+		}
+	}
+
+	return 0, ErrOffsetNotFound
+}
+
+// Symbolize resolves the user addresses that belong to the mapping of the ELF file.
+// When the mapping is unknown, the addresses are assumed to be the ones of the
+// ELF file, as for executables loaded at their link-time address.
+func (e *ELFSymTab) Symbolize(pid int, addr uint64, mapping *procmaps.Mapping) ([]Frame, error) {
+	if pid == KernelPID {
+		return nil, ErrNotHandled
+	}
+	if mapping == nil {
+		return e.GetFrames(addr)
+	}
+	if mapping.Pathname != e.pathname {
+		return nil, ErrNotHandled
+	}
+	fileAddr, err := e.FileOffsetToAddr(mapping.FileOffset(addr))
+	if err != nil {
+		return nil, err
+	}
+
+	return e.GetFrames(fileAddr)
+}
+
 // GetName returns symbol name from an instruction pointer address.
 func (e *ELFSymTab) GetName(ip uint64) (string, error) {
+	sym, err := e.GetSymbol(ip)
+	if err != nil {
+		return "", err
+	}
+
+	return sym.Name, nil
+}
+
+// GetSymbol returns the symbol from an instruction pointer address,
+// along with the source it has been resolved from.
+// When the address belongs to inlined functions, the symbol is the
+// one of the function they are inlined into.
+func (e *ELFSymTab) GetSymbol(ip uint64) (*Symbol, error) {
+	frames, err := e.GetFrames(ip)
+	if err != nil {
+		return nil, err
+	}
+
+	return &frames[len(frames)-1].Symbol, nil
+}
+
+// GetFrames returns the logical frames from an instruction pointer address,
+// innermost first. Inlined functions are expanded into separate frames.
+func (e *ELFSymTab) GetFrames(ip uint64) ([]Frame, error) {
 	// Try from cache.
-	sym, err := e.cache.Get(ip)
+	frames, err := e.cache.Get(ip)
 	if err != nil {
 		// Cache miss.
-		if e.symtab == nil || len(e.symtab) == 0 {
-			return "", ErrSymTableEmpty
+		if !e.loaded() {
+			return nil, ErrSymTableEmpty
 		}
-		for _, s := range e.symtab {
-			if ip >= s.Value && ip < (s.Value+s.Size) {
-				sym = s.Name
-			}
+		frames = e.lookup(ip)
+		e.cache.Set(frames, ip)
+	}
+	if len(frames) == 0 {
+		return nil, ErrSymbolNotFound
+	}
+
+	return frames, nil
+}
+
+// lookup looks up the frames an address belongs to, over the loaded sources.
+func (e *ELFSymTab) lookup(ip uint64) []Frame {
+	sym, nearest := e.lookupSymbol(ip)
+
+	frames := []Frame{{Symbol: sym}}
+	if e.dwarf != nil {
+		frames = e.dwarf.lookup(ip)
+	} else if e.gotab != nil {
+		file, line, _ := e.gotab.PCToLine(ip)
+		frames[0].File, frames[0].Line = file, line
+	}
+
+	// Prefer the symbol table name for the out-of-line function,
+	// to be consistent with objects without debugging information,
+	// unless it's just the nearest preceding symbol.
+	outer := &frames[len(frames)-1]
+	if sym.Name != "" && (!nearest || outer.Name == "") {
+		outer.Symbol = sym
+	}
+	if outer.Name == "" {
+		return nil
+	}
+
+	// Skip inlined functions whose name is unknown.
+	resolved := frames[:0]
+	for _, frame := range frames {
+		if frame.Name != "" {
+			resolved = append(resolved, frame)
 		}
-		if e.cache != nil {
-			e.cache.Set(sym, ip)
+	}
+
+	return resolved
+}
+
+// lookupSymbol looks up the symbol an address belongs to, over the loaded symbol sources.
+// The symbols without size are looked up last, as the nearest preceding ones,
+// and whether the symbol is one of them is returned too.
+func (e *ELFSymTab) lookupSymbol(ip uint64) (Symbol, bool) {
+	for _, tab := range e.symtabs {
+		if r, ok := tab.index.lookup(ip); ok {
+			return Symbol{Name: r.name, Source: tab.source, Offset: ip - r.value}, false
+		}
+	}
+	if e.gotab != nil {
+		if fn := e.gotab.PCToFunc(ip); fn != nil {
+			return Symbol{Name: fn.Name, Source: SourceGoPclntab, Offset: ip - fn.Entry}, false
+		}
+	}
+	for _, tab := range e.symtabs {
+		if r, ok := tab.index.lookupSizeless(ip); ok {
+			return Symbol{Name: r.name, Source: tab.source, Offset: ip - r.value}, true
 		}
 	}
 
-	return sym, nil
+	return Symbol{}, false
 }
@@ -0,0 +1,93 @@
+package symtable_test
+
+import (
+	"debug/elf"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/maxgio92/yap/pkg/symcache"
+	. "github.com/maxgio92/yap/pkg/symtable"
+)
+
+const testLibc = "/usr/lib/x86_64-linux-gnu/libc.so.6"
+
+func TestELFSymTabDynsym(t *testing.T) {
+	file, err := elf.Open(testLibc)
+	if err != nil {
+		t.Skipf("%s not available", testLibc)
+	}
+	defer file.Close()
+	if _, err = file.Symbols(); err == nil {
+		t.Skipf("%s is not stripped", testLibc)
+	}
+	syms, err := file.DynamicSymbols()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var getpid elf.Symbol
+	for _, s := range syms {
+		if s.Name == "getpid" {
+			getpid = s
+		}
+	}
+
+	tab := NewELFSymTab()
+	if err := tab.Load(testLibc); err != nil {
+		t.Fatal(err)
+	}
+	sym, err := tab.GetSymbol(getpid.Value + 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "getpid", sym.Name)
+	assert.Equal(t, SourceDynsym, sym.Source)
+	assert.Equal(t, uint64(1), sym.Offset)
+}
+
+func TestELFSymTabSharedCache(t *testing.T) {
+	if _, err := os.Stat(testLibc); err != nil {
+		t.Skipf("%s not available", testLibc)
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache := symcache.NewCache[[]Frame]()
+	libc := NewELFSymTab(WithCache(cache))
+	if err = libc.Load(testLibc); err != nil {
+		t.Fatal(err)
+	}
+	self := NewELFSymTab(WithCache(cache))
+	if err = self.Load(exe); err != nil {
+		t.Fatal(err)
+	}
+
+	// The same address resolves to different frames in each object.
+	ip := uint64(reflect.ValueOf(TestELFSymTabSharedCache).Pointer())
+	frames, err := self.GetFrames(ip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	libcFrames, _ := libc.GetFrames(ip)
+	assert.NotEqual(t, frames, libcFrames)
+
+	frames, err = self.GetFrames(ip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "github.com/maxgio92/yap/pkg/symtable_test.TestELFSymTabSharedCache", frames[len(frames)-1].Name)
+
+	stats := cache.Stats()
+	assert.Equal(t, uint64(1), stats.Hits)
+	assert.Equal(t, uint64(2), stats.Misses)
+}
+
+func TestELFSymTabNotLoaded(t *testing.T) {
+	tab := NewELFSymTab()
+	_, err := tab.GetSymbol(0x1000)
+	assert.ErrorIs(t, err, ErrSymTableEmpty)
+}
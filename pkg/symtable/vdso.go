@@ -0,0 +1,107 @@
+package symtable
+
+import (
+	"bytes"
+	"debug/elf"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+
+	"github.com/maxgio92/yap/pkg/procmaps"
+)
+
+var (
+	ErrVDSONotFound = errors.New("vDSO image not found")
+)
+
+// vdsoNames are the file names of the vDSO images installed by the kernel,
+// like with make vdso_install, or by the distribution debug packages.
+var vdsoNames = []string{"vdso64.so", "vdso.so"}
+
+// ReadVDSO returns the ELF image of the vDSO mapped by the process, read from its memory.
+func ReadVDSO(pid int, m *procmaps.Mapping) ([]byte, error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/mem", pid))
+	if err != nil {
+		return nil, errors.Wrap(err, "error opening the process memory")
+	}
+	defer f.Close()
+
+	image := make([]byte, m.End-m.Start)
+	if _, err = f.ReadAt(image, int64(m.Start)); err != nil {
+		return nil, errors.Wrap(err, "error reading the vDSO image")
+	}
+
+	return image, nil
+}
+
+// KernelVDSOPaths returns the paths of the vDSO images of the running kernel.
+func KernelVDSOPaths() []string {
+	var uts unix.Utsname
+	if err := unix.Uname(&uts); err != nil {
+		return nil
+	}
+	release := unix.ByteSliceToString(uts.Release[:])
+
+	paths := make([]string, 0)
+	for _, dir := range []string{"/lib/modules", "/usr/lib/debug/lib/modules"} {
+		for _, name := range vdsoNames {
+			paths = append(paths, filepath.Join(dir, release, "vdso", name))
+		}
+	}
+
+	return paths
+}
+
+// vdsoImage returns the ELF image of the vDSO of the process, read on first use
+// from its memory, as it's the same for the whole process lifetime.
+// The caller must hold the lock.
+func (p *ProcSymTab) vdsoImage(m *procmaps.Mapping) ([]byte, error) {
+	if p.vdso != nil {
+		return p.vdso, nil
+	}
+	if m == nil {
+		return nil, ErrVDSONotFound
+	}
+	image, err := ReadVDSO(p.pid, m)
+	if err != nil {
+		return nil, err
+	}
+	p.vdso = image
+
+	return image, nil
+}
+
+// loadVDSO loads the symbols of the vDSO of the process, from its image in the
+// process memory, or in the last snapshot if the process has exited, and from
+// the vDSO image of the running kernel as the last resort.
+// The caller must hold the lock.
+func (p *ProcSymTab) loadVDSO(obj *ELFSymTab, m *procmaps.Mapping) error {
+	if image, err := p.vdsoImage(m); err == nil {
+		return obj.LoadReader(m.Pathname, bytes.NewReader(image))
+	}
+	for _, path := range KernelVDSOPaths() {
+		if err := obj.Load(path); err == nil {
+			return nil
+		}
+	}
+
+	return ErrVDSONotFound
+}
+
+// vdsoBuildID returns the GNU build-id of the vDSO of the process.
+// The caller must hold the lock.
+func (p *ProcSymTab) vdsoBuildID() (string, error) {
+	image, err := p.vdsoImage(nil)
+	if err != nil {
+		return "", err
+	}
+	file, err := elf.NewFile(bytes.NewReader(image))
+	if err != nil {
+		return "", errors.Wrap(err, "error reading the vDSO image")
+	}
+
+	return ReadBuildID(file)
+}
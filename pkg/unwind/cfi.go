@@ -0,0 +1,389 @@
+package unwind
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Pointer encodings of the .eh_frame section, from the Linux Standard Base.
+const (
+	peAbsptr = 0x00
+	peULEB   = 0x01
+	peUdata2 = 0x02
+	peUdata4 = 0x03
+	peUdata8 = 0x04
+	peSLEB   = 0x09
+	peSdata2 = 0x0a
+	peSdata4 = 0x0b
+	peSdata8 = 0x0c
+	pePcrel  = 0x10
+	peOmit   = 0xff
+
+	peFormatMask = 0x0f
+	peApplyMask  = 0x70
+)
+
+// cie is a Common Information Entry, shared by the frame descriptions of a section.
+type cie struct {
+	codeAlign   uint64
+	dataAlign   int64
+	fdeEncoding byte
+	addrSize    int
+	order       binary.ByteOrder
+	// augData is whether the frame descriptions have the augmentation data length.
+	augData      bool
+	instructions []byte
+}
+
+// fde is a Frame Description Entry, that describes how to unwind the frames of
+// the code in the [begin, end) range of addresses.
+type fde struct {
+	cie          *cie
+	begin        uint64
+	end          uint64
+	instructions []byte
+}
+
+// frameSection is a section of call frame information, either .eh_frame or .debug_frame.
+type frameSection struct {
+	data     []byte
+	addr     uint64
+	order    binary.ByteOrder
+	addrSize int
+	// eh is whether it's the .eh_frame section, that differs from .debug_frame
+	// in the CIE identifiers and pointers, and in the pointer encodings.
+	eh   bool
+	cies map[int]*cie
+}
+
+// parseFDEs returns the frame descriptions of the section. The ones of unsupported
+// augmentations, and of code discarded by the linker, are skipped.
+func (s *frameSection) parseFDEs() ([]fde, error) {
+	s.cies = make(map[int]*cie)
+	fdes := make([]fde, 0)
+
+	for off := 0; off < len(s.data); {
+		e, idOff, id, end, err := s.entry(off)
+		if err != nil {
+			return nil, err
+		}
+		if e == nil {
+			// The .eh_frame section is terminated by a zero length entry.
+			if s.eh {
+				break
+			}
+			off = end
+			continue
+		}
+		off = end
+		if s.isCIE(id) {
+			continue
+		}
+
+		cieOff := int(id)
+		if s.eh {
+			// The CIE pointer is relative to its own position.
+			cieOff = idOff - int(id)
+		}
+		c, err := s.cie(cieOff)
+		if err != nil {
+			return nil, err
+		}
+		if c == nil {
+			continue
+		}
+
+		begin := e.encoded(c.fdeEncoding, c.addrSize, s.addr)
+		size := e.encoded(c.fdeEncoding&peFormatMask, c.addrSize, 0)
+		if c.augData {
+			e.skip(int(e.uleb()))
+		}
+		if e.err != nil {
+			return nil, errors.Wrapf(ErrMalformedFrameInfo, "FDE at %#x", idOff)
+		}
+		if begin == 0 || size == 0 {
+			continue
+		}
+		fdes = append(fdes, fde{cie: c, begin: begin, end: begin + size, instructions: e.rest()})
+	}
+
+	return fdes, nil
+}
+
+// entry returns the reader of the CIE or FDE at the offset, positioned after the
+// identifier, with the offset and the value of the identifier, and the offset
+// of the next entry. The reader is nil for zero length entries.
+func (s *frameSection) entry(off int) (*reader, int, uint64, int, error) {
+	r := &reader{data: s.data, off: off, order: s.order}
+	length := uint64(r.u32())
+	dwarf64 := length == 0xffffffff
+	if dwarf64 {
+		length = r.u64()
+	}
+	if r.err != nil || length > uint64(len(s.data)-r.off) {
+		return nil, 0, 0, 0, errors.Wrapf(ErrMalformedFrameInfo, "entry at %#x", off)
+	}
+	end := r.off + int(length)
+	if length == 0 {
+		return nil, 0, 0, end, nil
+	}
+
+	// Entries can't be read past their end.
+	r.data = s.data[:end]
+	idOff := r.off
+	var id uint64
+	if dwarf64 {
+		id = r.u64()
+	} else {
+		id = uint64(r.u32())
+		// Extend the 32-bit CIE identifier of the .debug_frame section.
+		if !s.eh && id == 0xffffffff {
+			id = ^uint64(0)
+		}
+	}
+
+	return r, idOff, id, end, r.err
+}
+
+func (s *frameSection) isCIE(id uint64) bool {
+	if s.eh {
+		return id == 0
+	}
+
+	return id == ^uint64(0)
+}
+
+// cie returns the CIE at the offset, parsing it on first use.
+// It's nil if its augmentation is not supported.
+func (s *frameSection) cie(off int) (*cie, error) {
+	if c, ok := s.cies[off]; ok {
+		return c, nil
+	}
+
+	r, _, id, _, err := s.entry(off)
+	if err != nil {
+		return nil, err
+	}
+	if r == nil || !s.isCIE(id) {
+		return nil, errors.Wrapf(ErrMalformedFrameInfo, "CIE not found at %#x", off)
+	}
+
+	c := &cie{addrSize: s.addrSize, order: s.order, fdeEncoding: peAbsptr}
+	version := r.u8()
+	augmentation := r.cstring()
+	if strings.Contains(augmentation, "eh") {
+		// The obsolete GCC eh augmentation is followed by the exception table pointer.
+		r.skip(s.addrSize)
+	}
+	if version >= 4 {
+		c.addrSize = int(r.u8())
+		// Segment selector size.
+		r.u8()
+	}
+	c.codeAlign = r.uleb()
+	c.dataAlign = r.sleb()
+	// Return address register.
+	if version == 1 {
+		r.u8()
+	} else {
+		r.uleb()
+	}
+
+	supported := true
+	switch {
+	case strings.HasPrefix(augmentation, "z"):
+		c.augData = true
+		length := int(r.uleb())
+		augEnd := r.off + length
+	loop:
+		for _, ch := range augmentation[1:] {
+			switch ch {
+			case 'L':
+				// LSDA encoding.
+				r.u8()
+			case 'P':
+				// Personality routine.
+				r.encoded(r.u8(), c.addrSize, 0)
+			case 'R':
+				c.fdeEncoding = r.u8()
+			case 'S', 'B', 'G':
+				// Signal frames, and the AArch64 and memory tagging extensions, have no data.
+			default:
+				break loop
+			}
+		}
+		r.off = augEnd
+	case augmentation != "" && augmentation != "eh":
+		supported = false
+	}
+	if r.err != nil {
+		return nil, errors.Wrapf(ErrMalformedFrameInfo, "CIE at %#x", off)
+	}
+	c.instructions = r.rest()
+
+	if !supported {
+		c = nil
+	}
+	s.cies[off] = c
+
+	return c, nil
+}
+
+// reader reads the call frame information values. Reads past the end of the data
+// set the error, and return zero values.
+type reader struct {
+	data  []byte
+	off   int
+	order binary.ByteOrder
+	err   error
+}
+
+func (r *reader) bytes(n int) []byte {
+	if r.err != nil || n < 0 || n > len(r.data)-r.off {
+		r.err = ErrMalformedFrameInfo
+		return nil
+	}
+	b := r.data[r.off : r.off+n]
+	r.off += n
+
+	return b
+}
+
+func (r *reader) skip(n int) {
+	r.bytes(n)
+}
+
+func (r *reader) rest() []byte {
+	return r.bytes(len(r.data) - r.off)
+}
+
+func (r *reader) u8() byte {
+	b := r.bytes(1)
+	if b == nil {
+		return 0
+	}
+
+	return b[0]
+}
+
+func (r *reader) u16() uint16 {
+	b := r.bytes(2)
+	if b == nil {
+		return 0
+	}
+
+	return r.order.Uint16(b)
+}
+
+func (r *reader) u32() uint32 {
+	b := r.bytes(4)
+	if b == nil {
+		return 0
+	}
+
+	return r.order.Uint32(b)
+}
+
+func (r *reader) u64() uint64 {
+	b := r.bytes(8)
+	if b == nil {
+		return 0
+	}
+
+	return r.order.Uint64(b)
+}
+
+func (r *reader) uleb() uint64 {
+	var v uint64
+	for shift := uint(0); ; shift += 7 {
+		b := r.u8()
+		if r.err != nil {
+			return 0
+		}
+		if shift < 64 {
+			v |= uint64(b&0x7f) << shift
+		}
+		if b&0x80 == 0 {
+			return v
+		}
+	}
+}
+
+func (r *reader) sleb() int64 {
+	var v int64
+	var shift uint
+	for {
+		b := r.u8()
+		if r.err != nil {
+			return 0
+		}
+		if shift < 64 {
+			v |= int64(b&0x7f) << shift
+		}
+		shift += 7
+		if b&0x80 == 0 {
+			if shift < 64 && b&0x40 != 0 {
+				v |= -1 << shift
+			}
+			return v
+		}
+	}
+}
+
+func (r *reader) cstring() string {
+	i := bytes.IndexByte(r.data[r.off:], 0)
+	if i < 0 {
+		r.err = ErrMalformedFrameInfo
+		return ""
+	}
+	s := string(r.data[r.off : r.off+i])
+	r.off += i + 1
+
+	return s
+}
+
+// encoded reads a pointer of the encoding. PC-relative pointers are relative
+// to their address, from the address of the section data.
+func (r *reader) encoded(encoding byte, addrSize int, addr uint64) uint64 {
+	if encoding == peOmit {
+		return 0
+	}
+	pos := addr + uint64(r.off)
+
+	var v uint64
+	switch encoding & peFormatMask {
+	case peAbsptr:
+		if addrSize == 4 {
+			v = uint64(r.u32())
+		} else {
+			v = r.u64()
+		}
+	case peULEB:
+		v = r.uleb()
+	case peUdata2:
+		v = uint64(r.u16())
+	case peUdata4:
+		v = uint64(r.u32())
+	case peUdata8:
+		v = r.u64()
+	case peSLEB:
+		v = uint64(r.sleb())
+	case peSdata2:
+		v = uint64(int16(r.u16()))
+	case peSdata4:
+		v = uint64(int32(r.u32()))
+	case peSdata8:
+		v = r.u64()
+	default:
+		r.err = ErrMalformedFrameInfo
+		return 0
+	}
+	if encoding&peApplyMask == pePcrel {
+		v += pos
+	}
+
+	return v
+}
@@ -0,0 +1,373 @@
+package unwind
+
+import (
+	"debug/elf"
+	"math"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// DWARF register numbers of x86-64.
+const (
+	regRBP = 6
+	regRSP = 7
+)
+
+var (
+	ErrUnsupportedArch    = errors.New("unsupported architecture")
+	ErrNoFrameInfo        = errors.New("no call frame information")
+	ErrMalformedFrameInfo = errors.New("malformed call frame information")
+)
+
+// CFAType is how the canonical frame address, the value of the stack pointer
+// in the caller before the call instruction, is computed.
+type CFAType uint8
+
+const (
+	// CFATypeEnd marks the end of the code described by the table,
+	// where the frames can only be unwound with frame pointers.
+	CFATypeEnd CFAType = iota
+
+	// CFATypeRSP is the stack pointer plus the offset.
+	CFATypeRSP
+
+	// CFATypeRBP is the frame pointer plus the offset.
+	CFATypeRBP
+
+	// CFATypeUnsupported is for DWARF expressions and other registers.
+	CFATypeUnsupported
+)
+
+// RBPType is how the frame pointer of the caller is restored.
+type RBPType uint8
+
+const (
+	// RBPTypeSame is for the frame pointer not changed by the function.
+	RBPTypeSame RBPType = iota
+
+	// RBPTypeOffset is for the frame pointer saved at the canonical frame address plus the offset.
+	RBPTypeOffset
+
+	// RBPTypeUnsupported is for the other rules.
+	RBPTypeUnsupported
+)
+
+// Row is the rule to unwind the frames of the code from its address, up to the
+// address of the next row. On x86-64 the return address is always saved just
+// below the canonical frame address.
+type Row struct {
+	PC        uint64
+	CFAType   CFAType
+	RBPType   RBPType
+	CFAOffset int16
+	RBPOffset int16
+}
+
+// sameRule returns whether the rows unwind the frames the same way.
+func (r Row) sameRule(o Row) bool {
+	return r.CFAType == o.CFAType && r.RBPType == o.RBPType &&
+		r.CFAOffset == o.CFAOffset && r.RBPOffset == o.RBPOffset
+}
+
+// Table is a compact unwind table of an ELF object, with the rows sorted by address.
+// The addresses are the virtual addresses of the object, as its symbols.
+type Table []Row
+
+// ReadFile returns the unwind table of the ELF file at the path.
+func ReadFile(pathname string) (Table, error) {
+	file, err := elf.Open(pathname)
+	if err != nil {
+		return nil, errors.Wrap(err, "error opening ELF file")
+	}
+	defer file.Close()
+
+	return NewTable(file)
+}
+
+// NewTable compiles the call frame information of the .eh_frame section of the
+// ELF file, or of its .debug_frame section when missing, into an unwind table.
+func NewTable(file *elf.File) (Table, error) {
+	if file.Machine != elf.EM_X86_64 {
+		return nil, errors.Wrap(ErrUnsupportedArch, file.Machine.String())
+	}
+
+	for _, name := range []string{".eh_frame", ".debug_frame"} {
+		section := file.Section(name)
+		if section == nil || section.Type == elf.SHT_NOBITS {
+			continue
+		}
+		data, err := section.Data()
+		if err != nil {
+			return nil, errors.Wrapf(err, "error reading %s", name)
+		}
+		s := &frameSection{
+			data:     data,
+			addr:     section.Addr,
+			order:    file.ByteOrder,
+			addrSize: 8,
+			eh:       name == ".eh_frame",
+		}
+		fdes, err := s.parseFDEs()
+		if err != nil {
+			return nil, errors.Wrapf(err, "error parsing %s", name)
+		}
+		if table := newTable(fdes); len(table) > 0 {
+			return table, nil
+		}
+	}
+
+	return nil, ErrNoFrameInfo
+}
+
+// Find returns the row that covers the address.
+func (t Table) Find(pc uint64) (Row, bool) {
+	i := sort.Search(len(t), func(i int) bool { return t[i].PC > pc })
+	if i == 0 || t[i-1].CFAType == CFATypeEnd {
+		return Row{}, false
+	}
+
+	return t[i-1], true
+}
+
+// newTable returns the table of the rows of the frame descriptions, where
+// consecutive rows with the same rule are merged.
+func newTable(fdes []fde) Table {
+	rows := make([]Row, 0)
+	for _, f := range fdes {
+		fdeRows, err := run(f)
+		if err != nil {
+			continue
+		}
+		rows = append(rows, fdeRows...)
+	}
+	// The end of a description is overridden by the rows of the next one at the same address.
+	sort.SliceStable(rows, func(i, j int) bool {
+		if rows[i].PC != rows[j].PC {
+			return rows[i].PC < rows[j].PC
+		}
+		return rows[i].CFAType == CFATypeEnd && rows[j].CFAType != CFATypeEnd
+	})
+
+	table := make(Table, 0, len(rows))
+	for i, row := range rows {
+		if i+1 < len(rows) && rows[i+1].PC == row.PC {
+			continue
+		}
+		if n := len(table); n > 0 && table[n-1].sameRule(row) {
+			continue
+		}
+		if len(table) == 0 && row.CFAType == CFATypeEnd {
+			continue
+		}
+		table = append(table, row)
+	}
+
+	return table
+}
+
+// cfaRule is the rule of the canonical frame address.
+type cfaRule struct {
+	reg    uint64
+	offset int64
+	expr   bool
+}
+
+// rbpRule is the rule of the frame pointer.
+type rbpRule struct {
+	typ    RBPType
+	offset int64
+}
+
+type state struct {
+	cfa cfaRule
+	rbp rbpRule
+}
+
+// row returns the row of the state at the address.
+func (s state) row(pc uint64) Row {
+	row := Row{PC: pc, CFAType: CFATypeUnsupported, RBPType: s.rbp.typ}
+	switch {
+	case s.cfa.expr || s.cfa.offset < math.MinInt16 || s.cfa.offset > math.MaxInt16:
+	case s.cfa.reg == regRSP:
+		row.CFAType = CFATypeRSP
+	case s.cfa.reg == regRBP:
+		row.CFAType = CFATypeRBP
+	}
+	if row.CFAType != CFATypeUnsupported {
+		row.CFAOffset = int16(s.cfa.offset)
+	}
+	if row.RBPType == RBPTypeOffset {
+		if s.rbp.offset < math.MinInt16 || s.rbp.offset > math.MaxInt16 {
+			row.RBPType = RBPTypeUnsupported
+		} else {
+			row.RBPOffset = int16(s.rbp.offset)
+		}
+	}
+
+	return row
+}
+
+// Call frame instructions.
+const (
+	cfaAdvanceLoc        = 0x40
+	cfaOffset            = 0x80
+	cfaRestore           = 0xc0
+	cfaNop               = 0x00
+	cfaSetLoc            = 0x01
+	cfaAdvanceLoc1       = 0x02
+	cfaAdvanceLoc2       = 0x03
+	cfaAdvanceLoc4       = 0x04
+	cfaOffsetExtended    = 0x05
+	cfaRestoreExtended   = 0x06
+	cfaUndefined         = 0x07
+	cfaSameValue         = 0x08
+	cfaRegister          = 0x09
+	cfaRememberState     = 0x0a
+	cfaRestoreState      = 0x0b
+	cfaDefCFA            = 0x0c
+	cfaDefCFARegister    = 0x0d
+	cfaDefCFAOffset      = 0x0e
+	cfaDefCFAExpression  = 0x0f
+	cfaExpression        = 0x10
+	cfaOffsetExtendedSF  = 0x11
+	cfaDefCFASF          = 0x12
+	cfaDefCFAOffsetSF    = 0x13
+	cfaValOffset         = 0x14
+	cfaValOffsetSF       = 0x15
+	cfaValExpression     = 0x16
+	cfaGNUArgsSize       = 0x2e
+	cfaGNUNegOffsetExtSF = 0x2f
+
+	cfaHighMask = 0xc0
+	cfaLowMask  = 0x3f
+)
+
+// run executes the instructions of the CIE and of the frame description,
+// and returns the rows of the described code, followed by its end.
+func run(f fde) ([]Row, error) {
+	c := f.cie
+	rows := make([]Row, 0)
+	loc := f.begin
+
+	// The state of the CIE instructions is the initial one, restored by DW_CFA_restore.
+	var s, initial state
+	stack := make([]state, 0)
+	emit := func() {
+		row := s.row(loc)
+		if n := len(rows); n > 0 && rows[n-1].PC == loc {
+			rows[n-1] = row
+			return
+		}
+		rows = append(rows, row)
+	}
+	setRBP := func(reg uint64, rule rbpRule) {
+		if reg == regRBP {
+			s.rbp = rule
+		}
+	}
+
+	for i, instructions := range [][]byte{c.instructions, f.instructions} {
+		if i == 1 {
+			initial = s
+		}
+		r := &reader{data: instructions, order: c.order}
+		for r.off < len(r.data) && r.err == nil {
+			op := r.u8()
+			switch op & cfaHighMask {
+			case cfaAdvanceLoc:
+				emit()
+				loc += uint64(op&cfaLowMask) * c.codeAlign
+				continue
+			case cfaOffset:
+				setRBP(uint64(op&cfaLowMask), rbpRule{RBPTypeOffset, int64(r.uleb()) * c.dataAlign})
+				continue
+			case cfaRestore:
+				setRBP(uint64(op&cfaLowMask), initial.rbp)
+				continue
+			}
+
+			switch op {
+			case cfaNop:
+			case cfaSetLoc:
+				emit()
+				loc = r.encoded(c.fdeEncoding, c.addrSize, 0)
+			case cfaAdvanceLoc1:
+				emit()
+				loc += uint64(r.u8()) * c.codeAlign
+			case cfaAdvanceLoc2:
+				emit()
+				loc += uint64(r.u16()) * c.codeAlign
+			case cfaAdvanceLoc4:
+				emit()
+				loc += uint64(r.u32()) * c.codeAlign
+			case cfaOffsetExtended:
+				reg := r.uleb()
+				setRBP(reg, rbpRule{RBPTypeOffset, int64(r.uleb()) * c.dataAlign})
+			case cfaOffsetExtendedSF:
+				reg := r.uleb()
+				setRBP(reg, rbpRule{RBPTypeOffset, r.sleb() * c.dataAlign})
+			case cfaGNUNegOffsetExtSF:
+				reg := r.uleb()
+				setRBP(reg, rbpRule{RBPTypeOffset, -int64(r.uleb()) * c.dataAlign})
+			case cfaRestoreExtended:
+				setRBP(r.uleb(), initial.rbp)
+			case cfaSameValue:
+				setRBP(r.uleb(), rbpRule{typ: RBPTypeSame})
+			case cfaUndefined:
+				setRBP(r.uleb(), rbpRule{typ: RBPTypeUnsupported})
+			case cfaRegister:
+				reg := r.uleb()
+				r.uleb()
+				setRBP(reg, rbpRule{typ: RBPTypeUnsupported})
+			case cfaValOffset:
+				reg := r.uleb()
+				r.uleb()
+				setRBP(reg, rbpRule{typ: RBPTypeUnsupported})
+			case cfaValOffsetSF:
+				reg := r.uleb()
+				r.sleb()
+				setRBP(reg, rbpRule{typ: RBPTypeUnsupported})
+			case cfaExpression, cfaValExpression:
+				reg := r.uleb()
+				r.skip(int(r.uleb()))
+				setRBP(reg, rbpRule{typ: RBPTypeUnsupported})
+			case cfaRememberState:
+				stack = append(stack, s)
+			case cfaRestoreState:
+				if len(stack) == 0 {
+					return nil, ErrMalformedFrameInfo
+				}
+				s = stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+			case cfaDefCFA:
+				s.cfa = cfaRule{reg: r.uleb(), offset: int64(r.uleb())}
+			case cfaDefCFASF:
+				s.cfa = cfaRule{reg: r.uleb(), offset: r.sleb() * c.dataAlign}
+			case cfaDefCFARegister:
+				s.cfa.reg = r.uleb()
+				s.cfa.expr = false
+			case cfaDefCFAOffset:
+				s.cfa.offset = int64(r.uleb())
+			case cfaDefCFAOffsetSF:
+				s.cfa.offset = r.sleb() * c.dataAlign
+			case cfaDefCFAExpression:
+				r.skip(int(r.uleb()))
+				s.cfa.expr = true
+			case cfaGNUArgsSize:
+				r.uleb()
+			default:
+				return nil, errors.Wrapf(ErrMalformedFrameInfo, "unknown instruction %#x", op)
+			}
+		}
+		if r.err != nil {
+			return nil, r.err
+		}
+	}
+	if loc < f.end {
+		emit()
+	}
+	rows = append(rows, Row{PC: f.end, CFAType: CFATypeEnd})
+
+	return rows, nil
+}
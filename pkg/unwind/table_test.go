@@ -0,0 +1,62 @@
+package unwind_test
+
+import (
+	"debug/elf"
+	"os"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/maxgio92/yap/pkg/unwind"
+)
+
+const testLibc = "/usr/lib/x86_64-linux-gnu/libc.so.6"
+
+func TestNewTable(t *testing.T) {
+	if _, err := os.Stat(testLibc); err != nil {
+		t.Skipf("%s not available", testLibc)
+	}
+	file, err := elf.Open(testLibc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	table, err := NewTable(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NotEmpty(t, table)
+	assert.True(t, sort.SliceIsSorted(table, func(i, j int) bool { return table[i].PC < table[j].PC }))
+
+	// At the entry of a function, the return address has just been pushed by the call.
+	syms, err := file.DynamicSymbols()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sym := range syms {
+		if elf.ST_TYPE(sym.Info) != elf.STT_FUNC || sym.Value == 0 {
+			continue
+		}
+		row, ok := table.Find(sym.Value)
+		if assert.True(t, ok, sym.Name) {
+			assert.Equal(t, CFATypeRSP, row.CFAType, sym.Name)
+			assert.Equal(t, int16(8), row.CFAOffset, sym.Name)
+		}
+	}
+
+	// Functions with frame pointers save the one of the caller below the return address.
+	var rbp bool
+	for _, row := range table {
+		if row.CFAType == CFATypeRBP && row.RBPType == RBPTypeOffset {
+			assert.Equal(t, int16(16), row.CFAOffset)
+			assert.Equal(t, int16(-16), row.RBPOffset)
+			rbp = true
+		}
+	}
+	assert.True(t, rbp)
+
+	_, ok := table.Find(0)
+	assert.False(t, ok)
+}